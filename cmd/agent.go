@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"text/tabwriter"
 
 	"github.com/bamboo-firewall/agent/buildinfo"
@@ -19,6 +22,11 @@ func main() {
 	)
 	flag.StringVar(&pathConfig, "config-file", "", "path to env config file")
 	flag.BoolVar(&versionFlag, "version", false, "Show version information.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [cleanup]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "cleanup removes all rules and ipsets of agent from host then exits")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if versionFlag {
@@ -41,5 +49,27 @@ func main() {
 		logLevel = slog.LevelInfo
 	}
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
-	daemon.Run(cfg)
+
+	switch flag.Arg(0) {
+	case "":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			slog.Info("shutting down agent ...")
+		}()
+		if err = daemon.Run(ctx, cfg); err != nil {
+			slog.Error("run agent failed", "error", err)
+			os.Exit(1)
+		}
+	case "cleanup":
+		if err = daemon.Cleanup(cfg); err != nil {
+			slog.Error("cleanup failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("cleanup done")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 }
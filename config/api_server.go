@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseAPIServerPort returns the port of api-server address, the default port of scheme when address has no port
+func ParseAPIServerPort(address string) (uint16, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return 0, fmt.Errorf("malformed api-server address %q: %w", address, err)
+	}
+	if p := u.Port(); p != "" {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || port == 0 {
+			return 0, fmt.Errorf("malformed port %q of api-server address %q", p, address)
+		}
+		return uint16(port), nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return 80, nil
+	case "https":
+		return 443, nil
+	default:
+		return 0, fmt.Errorf("api-server address %q has no port", address)
+	}
+}
+
+// lookupIP resolves host names of api-server, replaced in tests
+var lookupIP = net.LookupIP
+
+// ParseAPIServerIPs parses a comma separated list of api-server addresses, networks or host names of one ip
+// family. Host names are resolved once to their addresses of that family.
+func ParseAPIServerIPs(s string, ipv6 bool) ([]string, error) {
+	var ips []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ip := net.ParseIP(item)
+		if ip == nil {
+			var err error
+			if ip, _, err = net.ParseCIDR(item); err != nil {
+				resolved, err := resolveAPIServerHost(item, ipv6)
+				if err != nil {
+					return nil, err
+				}
+				ips = append(ips, resolved...)
+				continue
+			}
+		}
+		if (ip.To4() == nil) != ipv6 {
+			return nil, fmt.Errorf("api-server ip %q is not of the expected ip family", item)
+		}
+		ips = append(ips, item)
+	}
+	return ips, nil
+}
+
+// resolveAPIServerHost returns the addresses of host of one ip family
+func resolveAPIServerHost(host string, ipv6 bool) ([]string, error) {
+	resolved, err := lookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("api-server ip %q is neither an address, a network nor a resolvable host: %w", host, err)
+	}
+	var ips []string
+	for _, ip := range resolved {
+		if (ip.To4() == nil) == ipv6 {
+			ips = append(ips, ip.String())
+		}
+	}
+	if len(ips) == 0 {
+		family := "ipv4"
+		if ipv6 {
+			family = "ipv6"
+		}
+		return nil, fmt.Errorf("api-server host %q has no %s address", host, family)
+	}
+	return ips, nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAPIServerPort(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected uint16
+		hasErr   bool
+	}{
+		{
+			name:     "explicit port",
+			input:    "http://localhost:8080",
+			expected: 8080,
+		},
+		{
+			name:     "http default port",
+			input:    "http://api.bamboo.local",
+			expected: 80,
+		},
+		{
+			name:     "https default port",
+			input:    "https://[2001:db8::1]",
+			expected: 443,
+		},
+		{
+			name:   "unknown scheme",
+			input:  "api.bamboo.local",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, err := ParseAPIServerPort(tt.input)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, port)
+		})
+	}
+}
+
+func TestParseAPIServerIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		ipv6     bool
+		expected []string
+		hasErr   bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:     "ipv4 list",
+			input:    "10.0.0.1, 10.0.1.0/24",
+			expected: []string{"10.0.0.1", "10.0.1.0/24"},
+		},
+		{
+			name:     "ipv6 list",
+			input:    "2001:db8::1,2001:db8:1::/64",
+			ipv6:     true,
+			expected: []string{"2001:db8::1", "2001:db8:1::/64"},
+		},
+		{
+			name:   "wrong family",
+			input:  "2001:db8::1",
+			hasErr: true,
+		},
+		{
+			name:     "ipv4 of host",
+			input:    "api.bamboo.local,10.0.1.0/24",
+			expected: []string{"10.0.0.1", "10.0.0.2", "10.0.1.0/24"},
+		},
+		{
+			name:     "ipv6 of host",
+			input:    "api.bamboo.local",
+			ipv6:     true,
+			expected: []string{"2001:db8::1"},
+		},
+		{
+			name:   "host without address of family",
+			input:  "v4.bamboo.local",
+			ipv6:   true,
+			hasErr: true,
+		},
+		{
+			name:   "unresolvable host",
+			input:  "unknown.bamboo.local",
+			hasErr: true,
+		},
+	}
+	hosts := map[string][]net.IP{
+		"api.bamboo.local": {net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1"), net.ParseIP("10.0.0.2")},
+		"v4.bamboo.local":  {net.ParseIP("10.0.0.1")},
+	}
+	defer func(original func(string) ([]net.IP, error)) { lookupIP = original }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := ParseAPIServerIPs(tt.input, tt.ipv6)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, ips)
+		})
+	}
+}
@@ -6,20 +6,83 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	DataplaneBackendIPTables = "iptables"
+	DataplaneBackendNFTables = "nftables"
+
+	DefaultActionDrop   = "drop"
+	DefaultActionReject = "reject"
+
+	// ShutdownPolicyKeep leaves our rules and ipsets on host when agent stops, host keeps being protected
+	ShutdownPolicyKeep = "keep"
+	// ShutdownPolicyRemove removes our rules and ipsets when agent stops
+	ShutdownPolicyRemove = "remove"
+
+	// defaultICMPv6EssentialTypes multicast listener query, report and done(130-132), router solicitation and
+	// advertisement(133-134), neighbor solicitation and advertisement(135-136), MLDv2 report(143)
+	defaultICMPv6EssentialTypes = "130,131,132,133,134,135,136,143"
+
+	// defaultLogPrefix is short, iptables keeps 28 characters of prefix, e.g. "bfw:in:2 " leaves 19 for
+	// the policy name
+	defaultLogPrefix = "bfw:" + LogPrefixDirection + ":" + LogPrefixRule + " " + LogPrefixPolicy
+	defaultLogLevel  = "notice"
+
+	defaultNFLogGroup    = 20
+	defaultFlowLogOutput = "stdout"
+
+	defaultNetSetThreshold = 8
+
+	defaultForwardMark = "0x100000"
+)
+
 type Config struct {
 	APIServerAddress           string
 	APIServerIPv4              string
+	APIServerIPv6              string
 	TenantID                   uint64
 	HostIP                     string
 	IPV6Support                bool
+	DataplaneBackend           string
+	DefaultAction              string
+	FailsafeInboundHostPorts   string
+	FailsafeOutboundHostPorts  string
+	ICMPv6EssentialTypes       string
+	LogPrefix                  string
+	LogLevel                   string
+	LogRateLimit               string
+	LogRateLimitBurst          int
+	NFLogGroup                 int
+	FlowLogs                   bool
+	FlowLogOutput              string
+	NetSetThreshold            int
+	ForwardEnforcement         bool
+	ForwardMark                string
 	IPTablesLockSecondsTimeout int
+	JumpPosition               string
 	DatastoreRefreshInterval   time.Duration
+	DatastoreWatch             bool
+	DatastoreWatchTimeout      time.Duration
 	DataplaneRefreshInterval   time.Duration
+	DriftCheckInterval         time.Duration
+	DriftRepair                bool
+	ShutdownPolicy             string
+	DryRun                     bool
+	StateFile                  string
+	StatusAddress              string
+	MetricsAddress             string
+	MetricsAllowRemote         bool
 	Debug                      bool
 }
 
 func New(path string) (Config, error) {
 	viper.AutomaticEnv()
+	viper.SetDefault("ICMPV6_ESSENTIAL_TYPES", defaultICMPv6EssentialTypes)
+	viper.SetDefault("LOG_PREFIX", defaultLogPrefix)
+	viper.SetDefault("LOG_LEVEL", defaultLogLevel)
+	viper.SetDefault("NFLOG_GROUP", defaultNFLogGroup)
+	viper.SetDefault("FLOW_LOG_OUTPUT", defaultFlowLogOutput)
+	viper.SetDefault("NET_SET_THRESHOLD", defaultNetSetThreshold)
+	viper.SetDefault("FORWARD_MARK", defaultForwardMark)
 	if path != "" {
 		viper.SetConfigFile(path)
 		if err := viper.ReadInConfig(); err != nil {
@@ -29,12 +92,39 @@ func New(path string) (Config, error) {
 	return Config{
 		APIServerAddress:           viper.GetString("API_SERVER_ADDRESS"),
 		APIServerIPv4:              viper.GetString("API_SERVER_IPV4"),
+		APIServerIPv6:              viper.GetString("API_SERVER_IPV6"),
 		TenantID:                   viper.GetUint64("TENANT_ID"),
 		HostIP:                     viper.GetString("HOST_IPV4"),
 		IPV6Support:                viper.GetBool("IPV6_SUPPORT"),
+		DataplaneBackend:           viper.GetString("DATAPLANE_BACKEND"),
+		DefaultAction:              viper.GetString("DEFAULT_ACTION"),
+		FailsafeInboundHostPorts:   viper.GetString("FAILSAFE_INBOUND_HOST_PORTS"),
+		FailsafeOutboundHostPorts:  viper.GetString("FAILSAFE_OUTBOUND_HOST_PORTS"),
+		ICMPv6EssentialTypes:       viper.GetString("ICMPV6_ESSENTIAL_TYPES"),
+		LogPrefix:                  viper.GetString("LOG_PREFIX"),
+		LogLevel:                   viper.GetString("LOG_LEVEL"),
+		LogRateLimit:               viper.GetString("LOG_RATE_LIMIT"),
+		LogRateLimitBurst:          viper.GetInt("LOG_RATE_LIMIT_BURST"),
+		NFLogGroup:                 viper.GetInt("NFLOG_GROUP"),
+		FlowLogs:                   viper.GetBool("FLOW_LOGS"),
+		FlowLogOutput:              viper.GetString("FLOW_LOG_OUTPUT"),
+		NetSetThreshold:            viper.GetInt("NET_SET_THRESHOLD"),
+		ForwardEnforcement:         viper.GetBool("FORWARD_ENFORCEMENT"),
+		ForwardMark:                viper.GetString("FORWARD_MARK"),
 		IPTablesLockSecondsTimeout: viper.GetInt("IPTABLES_LOCK_SECONDS_TIMEOUT"),
+		JumpPosition:               viper.GetString("JUMP_POSITION"),
 		DatastoreRefreshInterval:   viper.GetDuration("DATASTORE_REFRESH_INTERVAL"),
+		DatastoreWatch:             viper.GetBool("DATASTORE_WATCH"),
+		DatastoreWatchTimeout:      viper.GetDuration("DATASTORE_WATCH_TIMEOUT"),
 		DataplaneRefreshInterval:   viper.GetDuration("DATAPLANE_REFRESH_INTERVAL"),
+		DriftCheckInterval:         viper.GetDuration("DRIFT_CHECK_INTERVAL"),
+		DriftRepair:                viper.GetBool("DRIFT_REPAIR"),
+		ShutdownPolicy:             viper.GetString("SHUTDOWN_POLICY"),
+		DryRun:                     viper.GetBool("DRY_RUN"),
+		StateFile:                  viper.GetString("STATE_FILE"),
+		StatusAddress:              viper.GetString("STATUS_ADDRESS"),
+		MetricsAddress:             viper.GetString("METRICS_ADDRESS"),
+		MetricsAllowRemote:         viper.GetBool("METRICS_ALLOW_REMOTE"),
 		Debug:                      viper.GetBool("DEBUG"),
 	}, nil
 }
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// ParseForwardMark parses the packet mark bit tracking forwarded packets allowed by forward inbound policies,
+// written in hex(0x100000) or decimal. Only that bit of the mark is set and cleared, so it must be a single bit
+// no other program of host uses.
+func ParseForwardMark(s string) (uint32, error) {
+	mark, err := strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed forward mark %q: %w", s, err)
+	}
+	if bits.OnesCount64(mark) != 1 {
+		return 0, fmt.Errorf("forward mark %q must be a single bit", s)
+	}
+	return uint32(mark), nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForwardMark(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected uint32
+		hasErr   bool
+	}{
+		{
+			name:     "hex",
+			input:    "0x100000",
+			expected: 0x100000,
+		},
+		{
+			name:     "decimal",
+			input:    "1024",
+			expected: 0x400,
+		},
+		{
+			name:     "highest bit",
+			input:    "0x80000000",
+			expected: 0x80000000,
+		},
+		{
+			name:   "several bits",
+			input:  "0x300000",
+			hasErr: true,
+		},
+		{
+			name:   "zero",
+			input:  "0",
+			hasErr: true,
+		},
+		{
+			name:   "out of range",
+			input:  "0x100000000",
+			hasErr: true,
+		},
+		{
+			name:   "malformed",
+			input:  "mark",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mark, err := ParseForwardMark(tt.input)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, mark)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseICMPTypes parses a comma separated list of icmp type numbers, e.g. "133,134".
+// "none" or an empty string is an empty list.
+func ParseICMPTypes(s string) ([]uint8, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "none") {
+		return nil, nil
+	}
+
+	var icmpTypes []uint8
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		icmpType, err := strconv.ParseUint(item, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("malformed icmp type %q", item)
+		}
+		icmpTypes = append(icmpTypes, uint8(icmpType))
+	}
+	return icmpTypes, nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseICMPTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []uint8
+		hasErr   bool
+	}{
+		{
+			name:  "none",
+			input: "none",
+		},
+		{
+			name:     "list",
+			input:    "133, 134,",
+			expected: []uint8{133, 134},
+		},
+		{
+			name:   "out of range",
+			input:  "256",
+			hasErr: true,
+		},
+		{
+			name:   "name",
+			input:  "echo-request",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			icmpTypes, err := ParseICMPTypes(tt.input)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, icmpTypes)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// JumpPositionFirst places our jump above the rules of other programs
+	JumpPositionFirst = "first"
+	// JumpPositionLast places our jump below the rules of other programs
+	JumpPositionLast = "last"
+	// JumpPositionAfter places our jump right after the jump of another program to a chain, e.g. "after:KUBE-FIREWALL"
+	JumpPositionAfter = "after"
+)
+
+var chainNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,28}$`)
+
+// JumpPosition where our jumps are placed in INPUT and OUTPUT, which are shared with other programs(docker, kube-proxy)
+type JumpPosition struct {
+	Kind string
+	// AfterChain target of the jump our jump follows, for kind after. Our jump is last when no rule jumps to it
+	AfterChain string
+}
+
+// ParseJumpPosition parses "first", "last" or "after:<chain>", empty is last
+func ParseJumpPosition(s string) (JumpPosition, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", JumpPositionLast:
+		return JumpPosition{Kind: JumpPositionLast}, nil
+	case JumpPositionFirst:
+		return JumpPosition{Kind: JumpPositionFirst}, nil
+	}
+	kind, chain, ok := strings.Cut(s, ":")
+	if !ok || !strings.EqualFold(kind, JumpPositionAfter) {
+		return JumpPosition{}, fmt.Errorf("malformed jump position %q, expected first, last or after:<chain>", s)
+	}
+	if !chainNameRegexp.MatchString(chain) {
+		return JumpPosition{}, fmt.Errorf("malformed chain name %q of jump position", chain)
+	}
+	return JumpPosition{Kind: JumpPositionAfter, AfterChain: chain}, nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJumpPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected JumpPosition
+		hasErr   bool
+	}{
+		{
+			name:     "default",
+			input:    "",
+			expected: JumpPosition{Kind: JumpPositionLast},
+		},
+		{
+			name:     "first",
+			input:    "First",
+			expected: JumpPosition{Kind: JumpPositionFirst},
+		},
+		{
+			name:     "after chain",
+			input:    "after:KUBE-FIREWALL",
+			expected: JumpPosition{Kind: JumpPositionAfter, AfterChain: "KUBE-FIREWALL"},
+		},
+		{
+			name:   "after without chain",
+			input:  "after:",
+			hasErr: true,
+		},
+		{
+			name:   "unknown",
+			input:  "middle",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			position, err := ParseJumpPosition(tt.input)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, position)
+		})
+	}
+}
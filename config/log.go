@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LogPrefixPolicy, LogPrefixRule and LogPrefixDirection are replaced in the log prefix template by
+	// the policy name, the index of rule in policy and the direction(in or out) of rule
+	LogPrefixPolicy    = "{policy}"
+	LogPrefixRule      = "{rule}"
+	LogPrefixDirection = "{direction}"
+)
+
+var (
+	logLevels       = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	logLevelAliases = map[string]uint8{"warn": 4, "error": 3}
+
+	logRateLimitRegexp = regexp.MustCompile(`^[1-9][0-9]*/(second|minute|hour|day)$`)
+)
+
+// ParseLogLevel parses a syslog level, as a name(warning) or a number(4)
+func ParseLogLevel(s string) (uint8, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if level, err := strconv.ParseUint(s, 10, 8); err == nil && int(level) < len(logLevels) {
+		return uint8(level), nil
+	}
+	for level, name := range logLevels {
+		if s == name {
+			return uint8(level), nil
+		}
+	}
+	if level, ok := logLevelAliases[s]; ok {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unsupported log level %q", s)
+}
+
+// ValidateLogRateLimit checks a rate limit of log rules written as number/unit, e.g. "10/minute".
+// An empty rate limit logs every packet.
+func ValidateLogRateLimit(s string) error {
+	if s == "" || logRateLimitRegexp.MatchString(s) {
+		return nil
+	}
+	return fmt.Errorf("malformed log rate limit %q, expected number/second|minute|hour|day", s)
+}
+
+// ParseNFLogGroup checks the netlink group of nflog rules
+func ParseNFLogGroup(group int) (uint16, error) {
+	if group < 0 || group > math.MaxUint16 {
+		return 0, fmt.Errorf("nflog group %d out of range 0-%d", group, math.MaxUint16)
+	}
+	return uint16(group), nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtoPort a protocol and port pair, written as "tcp:22"
+type ProtoPort struct {
+	Protocol string
+	Port     uint16
+}
+
+func (p ProtoPort) String() string {
+	return fmt.Sprintf("%s:%d", p.Protocol, p.Port)
+}
+
+// ParseProtoPorts parses a comma separated list of protocol and port pairs, e.g. "tcp:22,udp:53".
+// "none" or an empty string is an empty list.
+func ParseProtoPorts(s string) ([]ProtoPort, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "none") {
+		return nil, nil
+	}
+
+	var protoPorts []ProtoPort
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed protocol and port %q, expected protocol:port", item)
+		}
+		protocol := strings.ToLower(parts[0])
+		switch protocol {
+		case "tcp", "udp", "sctp", "udplite":
+		default:
+			return nil, fmt.Errorf("unsupported protocol %q in %q", parts[0], item)
+		}
+		port, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("malformed port %q in %q", parts[1], item)
+		}
+		protoPorts = append(protoPorts, ProtoPort{Protocol: protocol, Port: uint16(port)})
+	}
+	return protoPorts, nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProtoPorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []ProtoPort
+		hasErr   bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "none",
+			input: "None",
+		},
+		{
+			name:  "list",
+			input: "tcp:22, UDP:53,",
+			expected: []ProtoPort{
+				{Protocol: "tcp", Port: 22},
+				{Protocol: "udp", Port: 53},
+			},
+		},
+		{
+			name:   "missing port",
+			input:  "tcp",
+			hasErr: true,
+		},
+		{
+			name:   "unsupported protocol",
+			input:  "icmp:1",
+			hasErr: true,
+		},
+		{
+			name:   "port out of range",
+			input:  "tcp:65536",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protoPorts, err := ParseProtoPorts(tt.input)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, protoPorts)
+		})
+	}
+}
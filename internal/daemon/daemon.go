@@ -2,16 +2,17 @@ package daemon
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"log/slog"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/bamboo-firewall/agent/config"
 	"github.com/bamboo-firewall/agent/internal/dataplane/linux"
+	"github.com/bamboo-firewall/agent/internal/flowlog"
+	"github.com/bamboo-firewall/agent/internal/metrics"
+	"github.com/bamboo-firewall/agent/internal/status"
 	"github.com/bamboo-firewall/agent/pkg/apiserver/client"
 	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
 	"github.com/bamboo-firewall/agent/pkg/model"
@@ -20,6 +21,10 @@ import (
 
 const (
 	defaultDatastoreRefreshInterval = 5 * time.Second
+	defaultDatastoreWatchTimeout    = 60 * time.Second
+	// minDatastoreWatchInterval minimum time between the start of two watches, an api-server answering
+	// immediately(a proxy cutting long-polls, changes in a row) must not be flooded
+	minDatastoreWatchInterval = time.Second
 )
 
 type dataplaneDriver interface {
@@ -30,6 +35,8 @@ type dataplaneDriver interface {
 
 type apiServer interface {
 	FetchHostEndpointPolicy(ctx context.Context, tenantID uint64, ip string) ([]*dto.HostEndpointPolicy, error)
+	WatchHostEndpointPolicy(ctx context.Context, tenantID uint64, ip string, metadata dto.HostEndPointPolicyMetadata,
+		timeout time.Duration) ([]*dto.HostEndpointPolicy, error)
 }
 
 type dataplaneConnector struct {
@@ -40,22 +47,36 @@ type dataplaneConnector struct {
 	hostIP                     string
 	dataStoreRefreshInterval   time.Duration
 	ctx                        context.Context
-	ctxCancelFunc              context.CancelFunc
+
+	// datastoreWatch long-poll api-server instead of fetching every dataStoreRefreshInterval.
+	// It is turned off when api-server does not support watch.
+	datastoreWatch        bool
+	datastoreWatchTimeout time.Duration
+	// datastoreWatchMinInterval minimum time between the start of two watches
+	datastoreWatchMinInterval time.Duration
+
+	// state last policy sent to dataplane, applied on boot before api-server is reachable
+	state *policyState
+	// status reports versions and fetch results to the status endpoint
+	status *status.Status
 }
 
-func Run(conf config.Config) {
+// Run runs agent until ctx is done
+func Run(ctx context.Context, conf config.Config, opts ...option) error {
 	if conf.TenantID == 0 || conf.HostIP == "" {
-		log.Fatal("tenant_id and host_ip are required")
+		return errors.New("tenant_id and host_ip are required")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	dataplane, err := linux.NewInternalDataplane(ctx, conf)
+	o := newOptions(opts)
+	st := status.New()
+	dataplane, err := linux.NewInternalDataplane(ctx, conf, st, linux.WithExecutor(o.executor))
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("new dataplane failed: %w", err)
 	}
 	as := client.NewAPIServer(conf.APIServerAddress)
 	if err = as.Ping(ctx); err != nil {
-		log.Fatal(err)
+		// keep running with the persisted policy, policies are reconciled when api-server is back
+		slog.Warn("api-server is unreachable", "err", err)
 	}
 	var datastoreRefreshInterval time.Duration
 	if conf.DatastoreRefreshInterval <= 0 {
@@ -63,20 +84,28 @@ func Run(conf config.Config) {
 	} else {
 		datastoreRefreshInterval = conf.DatastoreRefreshInterval
 	}
+	var datastoreWatchTimeout time.Duration
+	if conf.DatastoreWatchTimeout <= 0 {
+		datastoreWatchTimeout = defaultDatastoreWatchTimeout
+	} else {
+		datastoreWatchTimeout = conf.DatastoreWatchTimeout
+	}
 	connector := &dataplaneConnector{
-		dataplane:                dataplane,
-		apiServer:                as,
-		tenantID:                 conf.TenantID,
-		hostIP:                   conf.HostIP,
-		dataStoreRefreshInterval: datastoreRefreshInterval,
-		ctx:                      ctx,
-		ctxCancelFunc:            cancel,
+		dataplane:                 dataplane,
+		apiServer:                 as,
+		tenantID:                  conf.TenantID,
+		hostIP:                    conf.HostIP,
+		dataStoreRefreshInterval:  datastoreRefreshInterval,
+		datastoreWatch:            conf.DatastoreWatch,
+		datastoreWatchTimeout:     datastoreWatchTimeout,
+		datastoreWatchMinInterval: minDatastoreWatchInterval,
+		state:                     newPolicyState(conf.StateFile),
+		status:                    st,
+		ctx:                       ctx,
 	}
 
-	go interruptHandle(connector)
-
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(3)
 
 	// start interval sync to dataplane
 	go func() {
@@ -88,34 +117,111 @@ func Run(conf config.Config) {
 		defer wg.Done()
 		connector.sendMessageToDataplaneDriver()
 	}()
+	// persist policies once dataplane applied them
+	go func() {
+		defer wg.Done()
+		connector.persistAppliedPolicies()
+	}()
+
+	// start local status endpoint
+	if conf.StatusAddress != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server := status.NewServer(conf.StatusAddress, st, status.LivenessTimeout(conf.DataplaneRefreshInterval))
+			if err := server.Run(ctx); err != nil {
+				slog.Error("status server error:", "err", err)
+			}
+		}()
+	}
+
+	// start prometheus metrics endpoint
+	if conf.MetricsAddress != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := metrics.Run(ctx, conf.MetricsAddress, conf.MetricsAllowRemote); err != nil {
+				slog.Error("metrics server error:", "err", err)
+			}
+		}()
+	}
+
+	// start flow log collector of packets logged by nflog rules
+	if conf.FlowLogs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runFlowLogCollector(ctx, conf); err != nil {
+				slog.Error("flow log collector error:", "err", err)
+			}
+		}()
+	}
 
 	wg.Wait()
 	slog.Info("agent exited")
+	return nil
 }
 
-func interruptHandle(dc *dataplaneConnector) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-
-	slog.Debug("listening signal...")
-	s := <-c
-	slog.Info("shutting down server ...", "received signal.", s)
-
-	dc.ctxCancelFunc()
+// Cleanup removes our rules, ipsets and persisted policy from host without running the agent,
+// e.g. when decommissioning a host
+func Cleanup(conf config.Config, opts ...option) error {
+	o := newOptions(opts)
+	if err := linux.Cleanup(conf, linux.WithExecutor(o.executor)); err != nil {
+		return err
+	}
+	return newPolicyState(conf.StateFile).remove()
 }
 
 func (dc *dataplaneConnector) sendMessageToDataplaneDriver() {
+	dc.sendPersistedPolicyToDataplaneDriver()
+
 	timer := time.NewTimer(dc.dataStoreRefreshInterval)
+	var lastWatchTime time.Time
 	for {
 		var (
 			hostEndpointPolicies []*dto.HostEndpointPolicy
 			err                  error
 		)
+		if dc.datastoreWatch {
+			if !dc.waitWatchInterval(lastWatchTime) {
+				slog.Info("stop fetch agent")
+				return
+			}
+			slog.Debug("starting watch policies to api-server")
+			startTime := time.Now()
+			lastWatchTime = startTime
+			hostEndpointPolicies, err = dc.apiServer.WatchHostEndpointPolicy(dc.ctx, dc.tenantID, dc.hostIP,
+				dc.currentPolicyMetadata(), dc.datastoreWatchTimeout)
+			if !errors.Is(err, client.ErrWatchNotSupported) {
+				dc.reportFetchResult(fetchModeWatch, startTime, ignoreNotModified(err))
+			}
+			if err == nil {
+				dc.handleHostEndpointPolicies(hostEndpointPolicies)
+				continue
+			}
+			if errors.Is(err, client.ErrNotModified) {
+				continue
+			}
+			if errors.Is(err, client.ErrWatchNotSupported) {
+				slog.Warn("api-server does not support watch, fall back to fetch policies interval",
+					"interval", dc.dataStoreRefreshInterval)
+				dc.datastoreWatch = false
+				continue
+			}
+			// wait an interval before watching again
+			slog.Error("watch host endpoint policies error:", "err", err)
+		}
+
 		utils.ResetTimer(timer, dc.dataStoreRefreshInterval)
 		select {
 		case <-timer.C:
+			if dc.datastoreWatch {
+				continue
+			}
 			slog.Debug("starting fetch policies to api-server")
+			startTime := time.Now()
 			hostEndpointPolicies, err = dc.apiServer.FetchHostEndpointPolicy(dc.ctx, dc.tenantID, dc.hostIP)
+			dc.reportFetchResult(fetchModePoll, startTime, err)
 		case <-dc.ctx.Done():
 			slog.Info("stop fetch agent")
 			return
@@ -125,38 +231,171 @@ func (dc *dataplaneConnector) sendMessageToDataplaneDriver() {
 			continue
 		}
 
-		var hostEndpointPolicy *dto.HostEndpointPolicy
-		if len(hostEndpointPolicies) == 0 {
-			// Not setup HEP
-			if dc.hostEndpointPolicyMetadata == nil {
-				slog.Error("not found host endpoint")
-				continue
-			}
+		dc.handleHostEndpointPolicies(hostEndpointPolicies)
+	}
+}
 
-			// HEP is deleted
-			slog.Debug("host endpoint is deleted")
-			hostEndpointPolicy = new(dto.HostEndpointPolicy)
-			dc.hostEndpointPolicyMetadata = nil
-		} else {
-			// current only one hep is supported
-			hostEndpointPolicy = hostEndpointPolicies[0]
+// waitWatchInterval waits until datastoreWatchMinInterval elapsed since lastWatchTime. It returns false
+// when ctx is done.
+func (dc *dataplaneConnector) waitWatchInterval(lastWatchTime time.Time) bool {
+	wait := dc.datastoreWatchMinInterval - time.Since(lastWatchTime)
+	if wait <= 0 {
+		return dc.ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-dc.ctx.Done():
+		return false
+	}
+}
 
-			if !dc.isNeedUpdatePolicy(hostEndpointPolicy.MetaData) {
-				continue
-			}
+// handleHostEndpointPolicies sends policies to dataplane when they differ from the current versions
+func (dc *dataplaneConnector) handleHostEndpointPolicies(hostEndpointPolicies []*dto.HostEndpointPolicy) {
+	if len(hostEndpointPolicies) == 0 {
+		// Not setup HEP
+		if dc.hostEndpointPolicyMetadata == nil {
+			slog.Error("not found host endpoint")
+			return
+		}
 
-			slog.Debug("need update policies")
-			dc.hostEndpointPolicyMetadata = &model.HostEndpointPolicyMetadata{
-				HEPVersions: hostEndpointPolicy.MetaData.HEPVersions,
-				GNPVersions: hostEndpointPolicy.MetaData.GNPVersions,
-				GNSVersions: hostEndpointPolicy.MetaData.GNSVersions,
-			}
+		// HEPs are deleted
+		slog.Debug("host endpoints are deleted")
+		dc.hostEndpointPolicyMetadata = nil
+	} else {
+		metadata := mergePolicyMetadata(hostEndpointPolicies)
+		if !dc.isNeedUpdatePolicy(metadata) {
+			return
 		}
 
-		if err = dc.dataplane.SendMessage(hostEndpointPolicy); err != nil {
-			slog.Error("send message error:", "err", err)
+		slog.Debug("need update policies", "hostEndpoints", len(hostEndpointPolicies))
+		dc.hostEndpointPolicyMetadata = &model.HostEndpointPolicyMetadata{
+			HEPVersions: metadata.HEPVersions,
+			GNPVersions: metadata.GNPVersions,
+			GNSVersions: metadata.GNSVersions,
 		}
 	}
+
+	if err := dc.dataplane.SendMessage(hostEndpointPolicies); err != nil {
+		slog.Error("send message error:", "err", err)
+		return
+	}
+	dc.reportPolicyMetadata()
+}
+
+// persistAppliedPolicies persists the policies applied by dataplane, so that a policy failing to apply
+// is never restored on boot
+func (dc *dataplaneConnector) persistAppliedPolicies() {
+	for {
+		msg, err := dc.dataplane.ReceiveMessage()
+		if err != nil {
+			slog.Info("stop persisting policies")
+			return
+		}
+		applied, ok := msg.(*linux.MessageApplied)
+		if !ok {
+			continue
+		}
+		hostEndpointPolicies, ok := applied.Msg.([]*dto.HostEndpointPolicy)
+		if !ok {
+			continue
+		}
+		if len(hostEndpointPolicies) == 0 {
+			// host endpoints are deleted
+			err = dc.state.remove()
+		} else {
+			err = dc.state.save(hostEndpointPolicies)
+		}
+		if err != nil {
+			slog.Error("persist policy error:", "err", err)
+		}
+	}
+}
+
+// sendPersistedPolicyToDataplaneDriver applies the policy of last run, so host has our rules
+// without waiting for api-server
+func (dc *dataplaneConnector) sendPersistedPolicyToDataplaneDriver() {
+	hostEndpointPolicies, err := dc.state.load()
+	if err != nil {
+		slog.Error("load persisted policy error:", "err", err)
+		return
+	}
+	if len(hostEndpointPolicies) == 0 {
+		return
+	}
+
+	slog.Info("apply persisted policy", "path", dc.state.path)
+	metadata := mergePolicyMetadata(hostEndpointPolicies)
+	dc.hostEndpointPolicyMetadata = &model.HostEndpointPolicyMetadata{
+		HEPVersions: metadata.HEPVersions,
+		GNPVersions: metadata.GNPVersions,
+		GNSVersions: metadata.GNSVersions,
+	}
+	if err = dc.dataplane.SendMessage(hostEndpointPolicies); err != nil {
+		slog.Error("send message error:", "err", err)
+		return
+	}
+	dc.reportPolicyMetadata()
+}
+
+// mergePolicyMetadata merges versions of all host endpoints of host, policies and sets shared by
+// host endpoints have the same version
+func mergePolicyMetadata(hostEndpointPolicies []*dto.HostEndpointPolicy) dto.HostEndPointPolicyMetadata {
+	metadata := dto.HostEndPointPolicyMetadata{
+		HEPVersions: make(map[string]uint),
+		GNPVersions: make(map[string]uint),
+		GNSVersions: make(map[string]uint),
+	}
+	for _, hostEndpointPolicy := range hostEndpointPolicies {
+		for uuid, version := range hostEndpointPolicy.MetaData.HEPVersions {
+			metadata.HEPVersions[uuid] = version
+		}
+		for uuid, version := range hostEndpointPolicy.MetaData.GNPVersions {
+			metadata.GNPVersions[uuid] = version
+		}
+		for uuid, version := range hostEndpointPolicy.MetaData.GNSVersions {
+			metadata.GNSVersions[uuid] = version
+		}
+	}
+	return metadata
+}
+
+func (dc *dataplaneConnector) reportPolicyMetadata() {
+	if dc.hostEndpointPolicyMetadata == nil {
+		dc.status.SetPolicyMetadata(nil)
+		return
+	}
+	metadata := dc.currentPolicyMetadata()
+	dc.status.SetPolicyMetadata(&metadata)
+}
+
+func (dc *dataplaneConnector) reportFetchResult(mode string, startTime time.Time, err error) {
+	fetchPolicyDurationSeconds.WithLabelValues(mode).Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		fetchPolicyFailures.WithLabelValues(mode).Inc()
+	}
+	dc.status.SetFetchResult(err)
+}
+
+func ignoreNotModified(err error) error {
+	if errors.Is(err, client.ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// currentPolicyMetadata returns the versions of policies that agent holds, empty when agent has no policy
+func (dc *dataplaneConnector) currentPolicyMetadata() dto.HostEndPointPolicyMetadata {
+	if dc.hostEndpointPolicyMetadata == nil {
+		return dto.HostEndPointPolicyMetadata{}
+	}
+	return dto.HostEndPointPolicyMetadata{
+		HEPVersions: dc.hostEndpointPolicyMetadata.HEPVersions,
+		GNPVersions: dc.hostEndpointPolicyMetadata.GNPVersions,
+		GNSVersions: dc.hostEndpointPolicyMetadata.GNSVersions,
+	}
 }
 
 func (dc *dataplaneConnector) isNeedUpdatePolicy(newVersion dto.HostEndPointPolicyMetadata) bool {
@@ -164,7 +403,7 @@ func (dc *dataplaneConnector) isNeedUpdatePolicy(newVersion dto.HostEndPointPoli
 		return true
 	}
 	newGNPVersions := newVersion.GNPVersions
-	newHEPVersions := dc.hostEndpointPolicyMetadata.HEPVersions
+	newHEPVersions := newVersion.HEPVersions
 	newGNSVersions := newVersion.GNSVersions
 	if len(newGNPVersions) != len(dc.hostEndpointPolicyMetadata.GNPVersions) {
 		return true
@@ -195,3 +434,22 @@ func (dc *dataplaneConnector) isNeedUpdatePolicy(newVersion dto.HostEndPointPoli
 	}
 	return false
 }
+
+func runFlowLogCollector(ctx context.Context, conf config.Config) error {
+	group, err := config.ParseNFLogGroup(conf.NFLogGroup)
+	if err != nil {
+		return err
+	}
+	output, err := flowlog.OpenOutput(conf.FlowLogOutput)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	source, err := flowlog.NewNetlinkSource(group)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	slog.Info("flow log collector started", "group", group, "output", conf.FlowLogOutput)
+	return flowlog.NewCollector(source, output).Run(ctx)
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"github.com/bamboo-firewall/agent/pkg/executor"
+)
+
+type options struct {
+	// executor runs the iptables and ipset commands, the commands of host when nil
+	executor executor.Executor
+}
+
+type option func(*options)
+
+// WithExecutor runs iptables and ipset commands with e instead of the commands of host
+func WithExecutor(e executor.Executor) option {
+	return func(o *options) {
+		o.executor = e
+	}
+}
+
+func newOptions(opts []option) *options {
+	o := &options{executor: executor.OS{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,221 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/internal/dataplane/linux"
+	"github.com/bamboo-firewall/agent/internal/status"
+	"github.com/bamboo-firewall/agent/pkg/apiserver/client"
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+)
+
+type fakeDataplane struct {
+	mu       sync.Mutex
+	messages []interface{}
+	// received messages returned by ReceiveMessage, until it is closed
+	received chan interface{}
+}
+
+func (f *fakeDataplane) SendMessage(msg interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fakeDataplane) ReceiveMessage() (interface{}, error) {
+	msg, ok := <-f.received
+	if !ok {
+		return nil, errors.New("dataplane stopped")
+	}
+	return msg, nil
+}
+
+func (f *fakeDataplane) Start() {}
+
+func (f *fakeDataplane) messageCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.messages)
+}
+
+// fakeAPIServer answers every call immediately with the results of watch and fetch
+type fakeAPIServer struct {
+	mu         sync.Mutex
+	watch      func(call int) ([]*dto.HostEndpointPolicy, error)
+	fetch      func(call int) ([]*dto.HostEndpointPolicy, error)
+	watchCalls int
+	fetchCalls int
+}
+
+func (f *fakeAPIServer) FetchHostEndpointPolicy(_ context.Context, _ uint64, _ string) ([]*dto.HostEndpointPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetchCalls++
+	return f.fetch(f.fetchCalls)
+}
+
+func (f *fakeAPIServer) WatchHostEndpointPolicy(_ context.Context, _ uint64, _ string, _ dto.HostEndPointPolicyMetadata,
+	_ time.Duration) ([]*dto.HostEndpointPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchCalls++
+	return f.watch(f.watchCalls)
+}
+
+func (f *fakeAPIServer) calls() (watchCalls, fetchCalls int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.watchCalls, f.fetchCalls
+}
+
+func hostEndpointPolicies(gnpVersion uint) []*dto.HostEndpointPolicy {
+	return []*dto.HostEndpointPolicy{
+		{
+			MetaData: dto.HostEndPointPolicyMetadata{
+				HEPVersions: map[string]uint{"hep": 1},
+				GNPVersions: map[string]uint{"gnp": gnpVersion},
+				GNSVersions: map[string]uint{},
+			},
+			HEP: &dto.HostEndpoint{},
+		},
+	}
+}
+
+func newTestConnector(t *testing.T, ctx context.Context, as apiServer, dataplane dataplaneDriver) *dataplaneConnector {
+	return &dataplaneConnector{
+		dataplane:                 dataplane,
+		apiServer:                 as,
+		tenantID:                  1,
+		hostIP:                    "10.0.0.1",
+		dataStoreRefreshInterval:  10 * time.Millisecond,
+		datastoreWatch:            true,
+		datastoreWatchTimeout:     time.Minute,
+		datastoreWatchMinInterval: 50 * time.Millisecond,
+		state:                     newPolicyState(filepath.Join(t.TempDir(), "state.json")),
+		status:                    status.New(),
+		ctx:                       ctx,
+	}
+}
+
+// runConnector runs the fetch loop of connector for duration
+func runConnector(connector *dataplaneConnector, cancel context.CancelFunc, duration time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		connector.sendMessageToDataplaneDriver()
+	}()
+	time.Sleep(duration)
+	cancel()
+	<-done
+}
+
+func TestWatchHostEndpointPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch func(call int) ([]*dto.HostEndpointPolicy, error)
+		// maxWatchCalls bounds the watches in 300ms, one per datastoreWatchMinInterval
+		maxWatchCalls int
+		// sentEveryWatch every watch returns new versions sent to dataplane, otherwise only the first
+		sentEveryWatch bool
+	}{
+		{
+			name: "not modified immediately",
+			watch: func(call int) ([]*dto.HostEndpointPolicy, error) {
+				if call == 1 {
+					return hostEndpointPolicies(1), nil
+				}
+				return nil, client.ErrNotModified
+			},
+			maxWatchCalls: 7,
+		},
+		{
+			name: "modified immediately",
+			watch: func(call int) ([]*dto.HostEndpointPolicy, error) {
+				return hostEndpointPolicies(uint(call)), nil
+			},
+			maxWatchCalls:  7,
+			sentEveryWatch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			as := &fakeAPIServer{watch: tt.watch}
+			dataplane := &fakeDataplane{}
+			connector := newTestConnector(t, ctx, as, dataplane)
+
+			runConnector(connector, cancel, 300*time.Millisecond)
+
+			watchCalls, fetchCalls := as.calls()
+			assert.GreaterOrEqual(t, watchCalls, 2)
+			assert.LessOrEqual(t, watchCalls, tt.maxWatchCalls)
+			assert.Zero(t, fetchCalls)
+			assert.True(t, connector.datastoreWatch)
+			if tt.sentEveryWatch {
+				assert.Equal(t, watchCalls, dataplane.messageCount())
+			} else {
+				assert.Equal(t, 1, dataplane.messageCount())
+			}
+		})
+	}
+}
+
+func TestWatchNotSupportedFallsBackToFetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	as := &fakeAPIServer{
+		watch: func(int) ([]*dto.HostEndpointPolicy, error) {
+			return nil, client.ErrWatchNotSupported
+		},
+		fetch: func(int) ([]*dto.HostEndpointPolicy, error) {
+			return hostEndpointPolicies(1), nil
+		},
+	}
+	dataplane := &fakeDataplane{}
+	connector := newTestConnector(t, ctx, as, dataplane)
+
+	runConnector(connector, cancel, 100*time.Millisecond)
+
+	watchCalls, fetchCalls := as.calls()
+	assert.Equal(t, 1, watchCalls)
+	assert.False(t, connector.datastoreWatch)
+	// fetched every dataStoreRefreshInterval, the same versions are sent once
+	assert.Greater(t, fetchCalls, 1)
+	require.Equal(t, 1, dataplane.messageCount())
+}
+
+func TestPersistAppliedPolicies(t *testing.T) {
+	dataplane := &fakeDataplane{received: make(chan interface{}, 2)}
+	connector := newTestConnector(t, context.Background(), &fakeAPIServer{}, dataplane)
+
+	// policies are not persisted until dataplane applied them
+	connector.handleHostEndpointPolicies(hostEndpointPolicies(1))
+	require.Equal(t, 1, dataplane.messageCount())
+	persisted, err := connector.state.load()
+	require.NoError(t, err)
+	assert.Nil(t, persisted)
+
+	dataplane.received <- &linux.MessageApplied{Msg: hostEndpointPolicies(1)}
+	close(dataplane.received)
+	connector.persistAppliedPolicies()
+	persisted, err = connector.state.load()
+	require.NoError(t, err)
+	assert.Equal(t, hostEndpointPolicies(1), persisted)
+
+	// deleted host endpoints remove the state once applied
+	dataplane.received = make(chan interface{}, 1)
+	dataplane.received <- &linux.MessageApplied{Msg: []*dto.HostEndpointPolicy{}}
+	close(dataplane.received)
+	connector.persistAppliedPolicies()
+	persisted, err = connector.state.load()
+	require.NoError(t, err)
+	assert.Nil(t, persisted)
+}
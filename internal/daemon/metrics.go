@@ -0,0 +1,23 @@
+package daemon
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	fetchModePoll  = "poll"
+	fetchModeWatch = "watch"
+)
+
+var (
+	fetchPolicyDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "bamboo_agent_fetch_policy_duration_seconds",
+		Help: "Time taken to fetch policies of host endpoint from api-server. Watch requests include the time held by api-server.",
+	}, []string{"mode"})
+	fetchPolicyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_agent_fetch_policy_failures_total",
+		Help: "Number of failed fetches of policies of host endpoint from api-server.",
+	}, []string{"mode"})
+)
+
+func init() {
+	prometheus.MustRegister(fetchPolicyDurationSeconds, fetchPolicyFailures)
+}
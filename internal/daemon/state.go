@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+)
+
+const (
+	defaultStateFile = "/var/lib/bamboo-agent/state.json"
+
+	stateDirPerm  = 0700
+	stateFilePerm = 0600
+)
+
+// policyState persists the last policy sent to dataplane, so agent can restore rules on boot
+// before api-server is reachable.
+type policyState struct {
+	path string
+}
+
+func newPolicyState(path string) *policyState {
+	if path == "" {
+		path = defaultStateFile
+	}
+	return &policyState{path: path}
+}
+
+// load returns the persisted policies, nil when there is no state file
+func (s *policyState) load() ([]*dto.HostEndpointPolicy, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read state file failed: %w", err)
+	}
+	var hostEndpointPolicies []*dto.HostEndpointPolicy
+	if err = json.Unmarshal(data, &hostEndpointPolicies); err != nil {
+		// state file of agent supporting only one host endpoint
+		var hostEndpointPolicy *dto.HostEndpointPolicy
+		if errSingle := json.Unmarshal(data, &hostEndpointPolicy); errSingle != nil || hostEndpointPolicy == nil {
+			return nil, fmt.Errorf("unmarshal state file failed: %w", err)
+		}
+		if hostEndpointPolicy.HEP == nil {
+			return nil, nil
+		}
+		hostEndpointPolicies = []*dto.HostEndpointPolicy{hostEndpointPolicy}
+	}
+	return hostEndpointPolicies, nil
+}
+
+// save writes policies to a temporary file then renames it, so a crash never leaves a partial state file.
+// The file is synced before the rename and the directory after, so the new state survives a power loss.
+func (s *policyState) save(hostEndpointPolicies []*dto.HostEndpointPolicy) error {
+	data, err := json.Marshal(hostEndpointPolicies)
+	if err != nil {
+		return fmt.Errorf("marshal state failed: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), stateDirPerm); err != nil {
+		return fmt.Errorf("create state dir failed: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err = writeFileSync(tmpPath, data); err != nil {
+		return fmt.Errorf("write state file failed: %w", err)
+	}
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("rename state file failed: %w", err)
+	}
+	if err = syncDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("sync state dir failed: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the state file, used when host endpoint is deleted
+func (s *policyState) remove() error {
+	if err := os.Remove(s.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("remove state file failed: %w", err)
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("sync state dir failed: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync writes data to path and flushes it to disk
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stateFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the entries of dir to disk, making a rename or a removal in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+)
+
+func TestPolicyStateLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []*dto.HostEndpointPolicy
+		wantErr  bool
+	}{
+		{
+			name:    "policies of host endpoints",
+			content: `[{"metadata":{"hepVersions":{"hep":1}},"hostEndpoint":{"uuid":"hep","spec":{"interfaceName":"eth0"}}}]`,
+			expected: []*dto.HostEndpointPolicy{
+				{
+					MetaData: dto.HostEndPointPolicyMetadata{HEPVersions: map[string]uint{"hep": 1}},
+					HEP:      &dto.HostEndpoint{UUID: "hep", Spec: dto.HostEndpointSpec{InterfaceName: "eth0"}},
+				},
+			},
+		},
+		{
+			name:    "single host endpoint of previous versions",
+			content: `{"metadata":{"hepVersions":{"hep":2}},"hostEndpoint":{"uuid":"hep"}}`,
+			expected: []*dto.HostEndpointPolicy{
+				{
+					MetaData: dto.HostEndPointPolicyMetadata{HEPVersions: map[string]uint{"hep": 2}},
+					HEP:      &dto.HostEndpoint{UUID: "hep"},
+				},
+			},
+		},
+		{
+			name:    "single host endpoint of previous versions without host endpoint",
+			content: `{"metadata":{}}`,
+		},
+		{
+			name:    "corrupted",
+			content: `[{"metadata":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), stateFilePerm))
+
+			hostEndpointPolicies, err := newPolicyState(path).load()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, hostEndpointPolicies)
+		})
+	}
+}
+
+func TestPolicyStateSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bamboo-agent", "state.json")
+	state := newPolicyState(path)
+
+	// no state file before the first save
+	loaded, err := state.load()
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	// the state dir is created and no temporary file is left
+	require.NoError(t, state.save(hostEndpointPolicies(1)))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "state.json", entries[0].Name())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(stateFilePerm), info.Mode().Perm())
+
+	loaded, err = state.load()
+	require.NoError(t, err)
+	assert.Equal(t, hostEndpointPolicies(1), loaded)
+
+	// remove is idempotent
+	require.NoError(t, state.remove())
+	require.NoError(t, state.remove())
+	loaded, err = state.load()
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+}
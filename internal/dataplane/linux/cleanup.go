@@ -0,0 +1,90 @@
+package linux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/internal/status"
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/nftables"
+)
+
+// notPresentMessages are printed by iptables, ipset and nft when the table or the ip family is missing on host
+var notPresentMessages = []string{
+	"does not exist",
+	"can't initialize",
+	"No such file or directory",
+	"Address family not supported",
+	"Protocol not supported",
+	"not found in $PATH",
+}
+
+// Cleanup removes our rules, chains and ipsets of both ip families from host, whatever IPV6_SUPPORT is,
+// e.g. when decommissioning a host. Only tables and ipsets are built, so the config of api-server and
+// policies is not needed. A family or a table missing on host has nothing to clean. The nftables table
+// shared by both families is deleted once they are cleaned.
+func Cleanup(conf config.Config, opts ...option) error {
+	dp := &InternalDataplane{
+		parentCtx: context.Background(),
+		status:    status.New(),
+		executor:  executor.OS{},
+		// our jumps are removed from every default chain
+		forwardEnforcement: true,
+	}
+	for _, opt := range opts {
+		opt(dp)
+	}
+	if err := dp.setBackend(&conf); err != nil {
+		return err
+	}
+
+	for _, ipVersion := range []int{generictables.IPFamily4, generictables.IPFamily6} {
+		table, err := newFilterTable(conf, ipVersion, config.JumpPosition{Kind: config.JumpPositionLast}, dp.status, dp.executor)
+		if err != nil {
+			slog.Warn("skip cleaning table", "backend", conf.DataplaneBackend, "ipVersion", ipVersion, "err", err)
+		} else {
+			dp.filterTables = append(dp.filterTables, table)
+		}
+		set, err := newIPSet(conf, ipVersion, dp.status, dp.executor)
+		if err != nil {
+			slog.Warn("skip cleaning ipset", "backend", conf.DataplaneBackend, "ipVersion", ipVersion, "err", err)
+		} else {
+			dp.ipsets = append(dp.ipsets, set)
+		}
+	}
+	dp.allTables = append(dp.allTables, dp.filterTables...)
+	if err := dp.Cleanup(); err != nil {
+		return err
+	}
+
+	if conf.DataplaneBackend != config.DataplaneBackendNFTables {
+		return nil
+	}
+	if conf.DryRun {
+		slog.Info("dry-run mode, nftables table is not deleted", "table", nftables.TableName)
+		return nil
+	}
+	if err := nftables.DeleteTable(dp.executor, nftables.TableName); err != nil && !isNotPresent(err) {
+		return fmt.Errorf("delete nftables table %s failed: %w", nftables.TableName, err)
+	}
+	return nil
+}
+
+// isNotPresent returns whether err is caused by a table, an ip family or a command missing on host
+func isNotPresent(err error) bool {
+	if errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	for _, message := range notPresentMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
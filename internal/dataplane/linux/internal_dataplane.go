@@ -2,17 +2,22 @@ package linux
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bamboo-firewall/agent/config"
 	"github.com/bamboo-firewall/agent/internal/dataplane/linux/manager"
 	"github.com/bamboo-firewall/agent/internal/dataplane/linux/rulerenderer"
+	"github.com/bamboo-firewall/agent/internal/status"
+	"github.com/bamboo-firewall/agent/pkg/executor"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 	"github.com/bamboo-firewall/agent/pkg/ipset"
 	"github.com/bamboo-firewall/agent/pkg/iptables"
+	"github.com/bamboo-firewall/agent/pkg/nftables"
 	"github.com/bamboo-firewall/agent/pkg/utils"
 )
 
@@ -24,6 +29,20 @@ type Manager interface {
 	OnUpdate(msg interface{})
 }
 
+// IPSetDataplane sets of network in dataplane. It is implemented by ipset for the iptables backend
+// and by nftables sets for the nftables backend.
+type IPSetDataplane interface {
+	manager.IPSetDataplane
+	Apply() error
+	CleanUnusedSet()
+	Clean() error
+}
+
+// MessageApplied is received from dataplane once the rules of Msg are applied
+type MessageApplied struct {
+	Msg interface{}
+}
+
 type InternalDataplane struct {
 	parentCtx     context.Context
 	toDataplane   chan interface{}
@@ -34,7 +53,7 @@ type InternalDataplane struct {
 
 	filterTables []generictables.Table
 
-	ipsets []*ipset.IPSet
+	ipsets []IPSetDataplane
 
 	tableManagers []Manager
 	ipsetManagers []Manager
@@ -45,18 +64,45 @@ type InternalDataplane struct {
 	// dataplaneNeedsSync set to true when a certain period of time allows
 	dataplaneNeedsSync bool
 
+	// unconfirmedMsg last message from datastore not applied yet
+	unconfirmedMsg interface{}
+
 	// dataplaneRefreshInterval interval time to refresh dataplane
 	dataplaneRefreshInterval time.Duration
 
-	// apiServerIPV4 allow agent call to api-server
-	apiServerIPV4 string
+	// driftCheckInterval interval time to check our rules were not changed by others, 0 disables it
+	driftCheckInterval time.Duration
+	// repairDrift applies as soon as drift is detected
+	repairDrift bool
+
+	// forwardEnforcement jumps from FORWARD to our forward chain
+	forwardEnforcement bool
+
+	// removeOnShutdown removes our rules and ipsets when the dataplane loop stops
+	removeOnShutdown bool
+
+	// newMatch and actionFactory build the static rules for the configured backend
+	newMatch      func() generictables.MatchCriteria
+	actionFactory generictables.ActionFactory
+
+	// status reports the results of applying to dataplane
+	status *status.Status
+
+	// executor runs the iptables and ipset commands
+	executor executor.Executor
 }
 
-func NewInternalDataplane(parentCtx context.Context, conf config.Config) (*InternalDataplane, error) {
+func NewInternalDataplane(parentCtx context.Context, conf config.Config, st *status.Status,
+	opts ...option) (*InternalDataplane, error) {
 	dp := &InternalDataplane{
 		parentCtx:     parentCtx,
 		toDataplane:   make(chan interface{}),
-		fromDataplane: make(chan interface{}),
+		fromDataplane: make(chan interface{}, 1),
+		status:        st,
+		executor:      executor.OS{},
+	}
+	for _, opt := range opts {
+		opt(dp)
 	}
 
 	if conf.DataplaneRefreshInterval <= 0 {
@@ -65,30 +111,127 @@ func NewInternalDataplane(parentCtx context.Context, conf config.Config) (*Inter
 		dp.dataplaneRefreshInterval = conf.DataplaneRefreshInterval
 	}
 
-	ipsetV4, err := ipset.NewIPSet(generictables.IPFamily4)
+	if conf.DriftCheckInterval > 0 {
+		dp.driftCheckInterval = conf.DriftCheckInterval
+	}
+	dp.repairDrift = conf.DriftRepair
+	dp.forwardEnforcement = conf.ForwardEnforcement
+
+	if conf.DryRun {
+		slog.Warn("dry-run mode, policies are rendered but not applied to dataplane")
+	}
+
+	var rejectByDefault bool
+	switch conf.DefaultAction {
+	case "", config.DefaultActionDrop:
+	case config.DefaultActionReject:
+		rejectByDefault = true
+	default:
+		return nil, fmt.Errorf("unsupported default action: %s", conf.DefaultAction)
+	}
+
+	switch conf.ShutdownPolicy {
+	case "", config.ShutdownPolicyKeep:
+	case config.ShutdownPolicyRemove:
+		dp.removeOnShutdown = true
+	default:
+		return nil, fmt.Errorf("unsupported shutdown policy: %s", conf.ShutdownPolicy)
+	}
+
+	if err := dp.setBackend(&conf); err != nil {
+		return nil, err
+	}
+
+	failsafeInboundHostPorts, err := config.ParseProtoPorts(conf.FailsafeInboundHostPorts)
+	if err != nil {
+		return nil, fmt.Errorf("parse fail-safe inbound host ports failed: %w", err)
+	}
+	failsafeOutboundHostPorts, err := config.ParseProtoPorts(conf.FailsafeOutboundHostPorts)
+	if err != nil {
+		return nil, fmt.Errorf("parse fail-safe outbound host ports failed: %w", err)
+	}
+
+	icmpv6EssentialTypes, err := config.ParseICMPTypes(conf.ICMPv6EssentialTypes)
+	if err != nil {
+		return nil, fmt.Errorf("parse icmpv6 essential types failed: %w", err)
+	}
+	logLevel, err := config.ParseLogLevel(conf.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("parse log level failed: %w", err)
+	}
+	if err = config.ValidateLogRateLimit(conf.LogRateLimit); err != nil {
+		return nil, err
+	}
+	nflogGroup, err := config.ParseNFLogGroup(conf.NFLogGroup)
+	if err != nil {
+		return nil, err
+	}
+	apiServerPort, err := config.ParseAPIServerPort(conf.APIServerAddress)
+	if err != nil {
+		return nil, fmt.Errorf("parse api-server port failed: %w", err)
+	}
+	apiServerIPV4s, err := config.ParseAPIServerIPs(conf.APIServerIPv4, false)
+	if err != nil {
+		return nil, fmt.Errorf("parse api-server ipv4 failed: %w", err)
+	}
+	apiServerIPV6s, err := config.ParseAPIServerIPs(conf.APIServerIPv6, true)
+	if err != nil {
+		return nil, fmt.Errorf("parse api-server ipv6 failed: %w", err)
+	}
+	if conf.IPV6Support && len(apiServerIPV6s) == 0 {
+		slog.Warn("ipv6 support is enabled without api-server ipv6, agent can not reach api-server over ipv6")
+	}
+	jumpPosition, err := config.ParseJumpPosition(conf.JumpPosition)
+	if err != nil {
+		return nil, fmt.Errorf("parse jump position failed: %w", err)
+	}
+	// our nftables chains hook into the dataplane by priority, there is no rule of other programs to sit among
+	if conf.DataplaneBackend == config.DataplaneBackendNFTables && jumpPosition.Kind != config.JumpPositionLast {
+		return nil, fmt.Errorf("jump position %q is not supported by the nftables backend", conf.JumpPosition)
+	}
+	var forwardMark uint32
+	if conf.ForwardEnforcement {
+		if forwardMark, err = config.ParseForwardMark(conf.ForwardMark); err != nil {
+			return nil, fmt.Errorf("parse forward mark failed: %w", err)
+		}
+	}
+
+	ipsetV4, err := newIPSet(conf, generictables.IPFamily4, st, dp.executor)
 	if err != nil {
 		return nil, fmt.Errorf("new ipset v4 failed: %w", err)
 	}
 
-	filerTableIPV4, err := iptables.NewTable(
-		generictables.TableFilter,
-		generictables.HashPrefix,
-		iptables.WithIPFamily(generictables.IPFamily4),
-		iptables.WithLockSecondsTimeout(conf.IPTablesLockSecondsTimeout),
-	)
+	filerTableIPV4, err := newFilterTable(conf, generictables.IPFamily4, jumpPosition, st, dp.executor)
 	if err != nil {
-		return nil, fmt.Errorf("new iptables v4 failed: %w", err)
+		return nil, fmt.Errorf("new %s v4 failed: %w", conf.DataplaneBackend, err)
 	}
 
 	ipsetNameConventionV4 := ipset.NewNameConvention()
 
-	ruleRendererV4 := rulerenderer.NewRenderer(generictables.LogPrefix, ipsetNameConventionV4)
+	ruleRendererV4 := rulerenderer.NewRenderer(rulerenderer.Config{
+		IPVersion:                 generictables.IPFamily4,
+		NFTables:                  conf.DataplaneBackend == config.DataplaneBackendNFTables,
+		LogPrefix:                 conf.LogPrefix,
+		LogLevel:                  logLevel,
+		LogRateLimit:              conf.LogRateLimit,
+		LogRateLimitBurst:         conf.LogRateLimitBurst,
+		NFLogGroup:                nflogGroup,
+		FlowLogs:                  conf.FlowLogs,
+		NetSetThreshold:           conf.NetSetThreshold,
+		ForwardEnforcement:        conf.ForwardEnforcement,
+		ForwardMark:               forwardMark,
+		RejectByDefault:           rejectByDefault,
+		APIServerIPs:              apiServerIPV4s,
+		APIServerPort:             apiServerPort,
+		FailsafeInboundHostPorts:  failsafeInboundHostPorts,
+		FailsafeOutboundHostPorts: failsafeOutboundHostPorts,
+	}, ipsetNameConventionV4)
 
 	dp.ipsetManagers = append(dp.ipsetManagers,
-		manager.NewIPSet(ipsetV4, ipsetNameConventionV4),
+		manager.NewIPSet(ipsetV4, ipsetNameConventionV4, ruleRendererV4),
 	)
 	dp.tableManagers = append(dp.tableManagers,
-		manager.NewPolicy(filerTableIPV4, generictables.IPFamily4, conf.APIServerIPv4, ruleRendererV4),
+		manager.NewPolicy(filerTableIPV4, generictables.IPFamily4, ruleRendererV4, st),
 	)
 
 	dp.ipsets = append(dp.ipsets, ipsetV4)
@@ -97,28 +240,41 @@ func NewInternalDataplane(parentCtx context.Context, conf config.Config) (*Inter
 	)
 
 	if conf.IPV6Support {
-		ipsetV6, err := ipset.NewIPSet(generictables.IPFamily6)
+		ipsetV6, err := newIPSet(conf, generictables.IPFamily6, st, dp.executor)
 		if err != nil {
 			return nil, fmt.Errorf("new ipset v6 failed: %w", err)
 		}
 
-		filterTableIPV6, err := iptables.NewTable(
-			generictables.TableFilter,
-			generictables.HashPrefix,
-			iptables.WithIPFamily(generictables.IPFamily6),
-			iptables.WithLockSecondsTimeout(conf.IPTablesLockSecondsTimeout),
-		)
+		filterTableIPV6, err := newFilterTable(conf, generictables.IPFamily6, jumpPosition, st, dp.executor)
 		if err != nil {
-			return nil, fmt.Errorf("new iptables v6 failed: %w", err)
+			return nil, fmt.Errorf("new %s v6 failed: %w", conf.DataplaneBackend, err)
 		}
 
 		ipsetNameConventionV6 := ipset.NewNameConvention()
 
-		ruleRendererV6 := rulerenderer.NewRenderer(generictables.LogPrefix, ipsetNameConventionV6)
-
-		dp.ipsetManagers = append(dp.ipsetManagers, manager.NewIPSet(ipsetV6, ipsetNameConventionV6))
+		ruleRendererV6 := rulerenderer.NewRenderer(rulerenderer.Config{
+			IPVersion:                 generictables.IPFamily6,
+			NFTables:                  conf.DataplaneBackend == config.DataplaneBackendNFTables,
+			LogPrefix:                 conf.LogPrefix,
+			LogLevel:                  logLevel,
+			LogRateLimit:              conf.LogRateLimit,
+			LogRateLimitBurst:         conf.LogRateLimitBurst,
+			NFLogGroup:                nflogGroup,
+			FlowLogs:                  conf.FlowLogs,
+			NetSetThreshold:           conf.NetSetThreshold,
+			ForwardEnforcement:        conf.ForwardEnforcement,
+			ForwardMark:               forwardMark,
+			RejectByDefault:           rejectByDefault,
+			APIServerIPs:              apiServerIPV6s,
+			APIServerPort:             apiServerPort,
+			FailsafeInboundHostPorts:  failsafeInboundHostPorts,
+			FailsafeOutboundHostPorts: failsafeOutboundHostPorts,
+			ICMPv6EssentialTypes:      icmpv6EssentialTypes,
+		}, ipsetNameConventionV6)
+
+		dp.ipsetManagers = append(dp.ipsetManagers, manager.NewIPSet(ipsetV6, ipsetNameConventionV6, ruleRendererV6))
 		dp.tableManagers = append(dp.tableManagers,
-			manager.NewPolicy(filterTableIPV6, generictables.IPFamily6, conf.APIServerIPv4, ruleRendererV6))
+			manager.NewPolicy(filterTableIPV6, generictables.IPFamily6, ruleRendererV6, st))
 		dp.filterTables = append(dp.filterTables, filterTableIPV6)
 		dp.ipsets = append(dp.ipsets, ipsetV6)
 	}
@@ -127,6 +283,72 @@ func NewInternalDataplane(parentCtx context.Context, conf config.Config) (*Inter
 	return dp, nil
 }
 
+// setBackend sets the builders of static rules for the backend of conf, empty backend is iptables
+func (dp *InternalDataplane) setBackend(conf *config.Config) error {
+	switch conf.DataplaneBackend {
+	case "", config.DataplaneBackendIPTables:
+		conf.DataplaneBackend = config.DataplaneBackendIPTables
+		dp.newMatch = iptables.NewMatch
+		dp.actionFactory = iptables.NewAction()
+	case config.DataplaneBackendNFTables:
+		dp.newMatch = func() generictables.MatchCriteria {
+			return nftables.NewMatch(generictables.IPFamily4)
+		}
+		dp.actionFactory = nftables.NewAction()
+	default:
+		return fmt.Errorf("unsupported dataplane backend: %s", conf.DataplaneBackend)
+	}
+	return nil
+}
+
+func newFilterTable(conf config.Config, ipVersion int, jumpPosition config.JumpPosition, st *status.Status,
+	e executor.Executor) (generictables.Table, error) {
+	if conf.DataplaneBackend == config.DataplaneBackendNFTables {
+		return nftables.NewTable(
+			nftables.TableName,
+			generictables.HashPrefix,
+			nftables.WithIPFamily(ipVersion),
+			nftables.WithDryRun(newDryRun(conf, st, nftables.TableName, ipVersion)),
+			nftables.WithExecutor(e),
+		)
+	}
+	return iptables.NewTable(
+		generictables.TableFilter,
+		generictables.HashPrefix,
+		iptables.WithIPFamily(ipVersion),
+		iptables.WithLockSecondsTimeout(conf.IPTablesLockSecondsTimeout),
+		iptables.WithDryRun(newDryRun(conf, st, generictables.TableFilter, ipVersion)),
+		iptables.WithExecutor(e),
+		iptables.WithDefaultRulePosition(generictables.RulePosition{
+			Kind:       jumpPosition.Kind,
+			AfterChain: jumpPosition.AfterChain,
+		}),
+	)
+}
+
+func newIPSet(conf config.Config, ipVersion int, st *status.Status, e executor.Executor) (IPSetDataplane, error) {
+	if conf.DataplaneBackend == config.DataplaneBackendNFTables {
+		return nftables.NewIPSet(nftables.TableName, ipVersion,
+			nftables.WithSetDryRun(newDryRun(conf, st, "ipset", ipVersion)),
+			nftables.WithSetDestroyDryRun(newDryRun(conf, st, "ipset-destroy", ipVersion)),
+			nftables.WithSetExecutor(e))
+	}
+	return ipset.NewIPSet(ipVersion,
+		ipset.WithDryRun(newDryRun(conf, st, "ipset", ipVersion)),
+		ipset.WithDestroyDryRun(newDryRun(conf, st, "ipset-destroy", ipVersion)),
+		ipset.WithExecutor(e))
+}
+
+// newDryRun reports the payloads that are not applied to status endpoint, nil when dry-run mode is off
+func newDryRun(conf config.Config, st *status.Status, component string, ipVersion int) *generictables.DryRun {
+	if !conf.DryRun {
+		return nil
+	}
+	return generictables.NewDryRun(func(payload string) {
+		st.SetDryRunPayload(component, ipVersion, payload)
+	})
+}
+
 func (dp *InternalDataplane) Start() {
 	dp.setStaticConfigForDataplane()
 	var wg sync.WaitGroup
@@ -145,43 +367,73 @@ func (dp *InternalDataplane) setStaticConfigForDataplane() {
 func (dp *InternalDataplane) setStaticIptables() {
 	for _, filterTable := range dp.filterTables {
 		filterTable.SetDefaultRuleOfDefaultChain(generictables.DefaultChainInput, generictables.Rule{
-			Match:   iptables.NewMatch(),
-			Action:  iptables.NewAction().Jump(generictables.OurDefaultInputChain),
+			Match:   dp.newMatch(),
+			Action:  dp.actionFactory.Jump(generictables.OurDefaultInputChain),
 			Comment: []string{"Jump to bamboo input chain"},
 		})
 
 		filterTable.SetDefaultRuleOfDefaultChain(generictables.DefaultChainOutput, generictables.Rule{
-			Match:   iptables.NewMatch(),
-			Action:  iptables.NewAction().Jump(generictables.OurDefaultOutputChain),
+			Match:   dp.newMatch(),
+			Action:  dp.actionFactory.Jump(generictables.OurDefaultOutputChain),
 			Comment: []string{"Jump to bamboo output chain"},
 		})
+
+		if dp.forwardEnforcement {
+			filterTable.SetDefaultRuleOfDefaultChain(generictables.DefaultChainForward, generictables.Rule{
+				Match:   dp.newMatch(),
+				Action:  dp.actionFactory.Jump(generictables.OurDefaultForwardChain),
+				Comment: []string{"Jump to bamboo forward chain"},
+			})
+		}
 	}
 }
 
 func (dp *InternalDataplane) intervalUpdateDataplane() {
 	// implement interval algorithm call to get data from dataplane
 	timer := time.NewTimer(dp.dataplaneRefreshInterval)
+	var driftCheck <-chan time.Time
+	if dp.driftCheckInterval > 0 {
+		ticker := time.NewTicker(dp.driftCheckInterval)
+		defer ticker.Stop()
+		driftCheck = ticker.C
+	}
 	for {
 		utils.ResetTimer(timer, dp.dataplaneRefreshInterval)
+		dp.status.MarkDataplaneLoopAlive()
 		select {
 		case msg := <-dp.toDataplane:
 			dp.processMsgToManager(msg)
 		case <-timer.C:
 			dp.dataplaneNeedsSync = true
+		case <-driftCheck:
+			if dp.datastoreInSync && dp.checkDrift() && dp.repairDrift {
+				slog.Info("repair drift of dataplane")
+				dp.dataplaneNeedsSync = true
+			}
 		case <-dp.parentCtx.Done():
 			slog.Info("stop interval update dataplane")
+			if dp.removeOnShutdown {
+				if err := dp.Cleanup(); err != nil {
+					slog.Error("remove rules on shutdown error:", "err", err)
+				}
+			}
 			return
 		}
 		if dp.datastoreInSync && dp.dataplaneNeedsSync {
 			slog.Debug("start applying to dataplane")
-			dp.apply()
+			if dp.apply() && dp.unconfirmedMsg != nil {
+				dp.confirmApplied(dp.unconfirmedMsg)
+				dp.unconfirmedMsg = nil
+			}
 			slog.Debug("finished applying to dataplane")
 		}
 	}
 }
 
 func (dp *InternalDataplane) processMsgToManager(msg interface{}) {
+	dp.unconfirmedMsg = msg
 	dp.datastoreInSync = true
+	dp.status.SetDatastoreInSync(true)
 	dp.dataplaneNeedsSync = true
 	var wgIPSetManager sync.WaitGroup
 	for _, m := range dp.ipsetManagers {
@@ -204,15 +456,32 @@ func (dp *InternalDataplane) processMsgToManager(msg interface{}) {
 	wgTableManager.Wait()
 }
 
-func (dp *InternalDataplane) apply() {
+// confirmApplied tells the sender that msg is applied. Only the latest confirmation is kept when the
+// sender does not receive it.
+func (dp *InternalDataplane) confirmApplied(msg interface{}) {
+	select {
+	case <-dp.fromDataplane:
+	default:
+	}
+	dp.fromDataplane <- &MessageApplied{Msg: msg}
+}
+
+// apply applies ipsets and tables to dataplane, it returns whether all of them are applied
+func (dp *InternalDataplane) apply() bool {
 	dp.dataplaneNeedsSync = false
+	startTime := time.Now()
+	var failed atomic.Bool
 
 	var wgIPSet = sync.WaitGroup{}
 	for _, set := range dp.ipsets {
 		wgIPSet.Add(1)
-		go func(set *ipset.IPSet) {
+		go func(set IPSetDataplane) {
 			defer wgIPSet.Done()
-			set.Apply()
+			err := set.Apply()
+			if err != nil {
+				failed.Store(true)
+			}
+			dp.status.SetIPSetApplyResult(set.GetIPVersion(), err)
 		}(set)
 	}
 	wgIPSet.Wait()
@@ -222,26 +491,95 @@ func (dp *InternalDataplane) apply() {
 		wgTable.Add(1)
 		go func(table generictables.Table) {
 			defer wgTable.Done()
-			table.Apply()
+			err := table.Apply()
+			if err != nil {
+				failed.Store(true)
+			}
+			dp.status.SetTableApplyResult(table.GetName(), table.GetIPVersion(), err)
 		}(table)
 	}
 	wgTable.Wait()
 
+	applyDurationSeconds.Observe(time.Since(startTime).Seconds())
+	if !failed.Load() {
+		lastSuccessfulApplyTime.Store(time.Now().UnixNano())
+	}
+
 	for _, set := range dp.ipsets {
 		wgIPSet.Add(1)
-		go func(set *ipset.IPSet) {
+		go func(set IPSetDataplane) {
 			defer wgIPSet.Done()
 			set.CleanUnusedSet()
 		}(set)
 	}
 	wgIPSet.Wait()
+	return !failed.Load()
 }
 
-func (dp *InternalDataplane) SendMessage(msg interface{}) error {
-	dp.toDataplane <- msg
+// checkDrift returns whether our rules of any table were changed by others since the last apply
+func (dp *InternalDataplane) checkDrift() bool {
+	var drifted bool
+	for _, table := range dp.allTables {
+		drifts, err := table.CheckDrift()
+		if err != nil {
+			slog.Warn("check drift failed", "table", table.GetName(), "ipVersion", table.GetIPVersion(), "err", err)
+			continue
+		}
+		if len(drifts) > 0 {
+			drifted = true
+		}
+	}
+	return drifted
+}
+
+// Cleanup removes all our rules, chains and ipsets from dataplane. Tables are cleaned first because
+// ipsets referenced by rules can not be destroyed.
+func (dp *InternalDataplane) Cleanup() error {
+	slog.Info("start removing our rules and ipsets")
+	dp.setStaticConfigForDataplane()
+
+	var errs []error
+	for _, table := range dp.allTables {
+		table.NeedClean()
+		if err := table.Apply(); err != nil {
+			if isNotPresent(err) {
+				slog.Info("table is not present, nothing to clean", "table", table.GetName(), "ipVersion", table.GetIPVersion())
+				continue
+			}
+			errs = append(errs, fmt.Errorf("clean table %s ipv%d failed: %w", table.GetName(), table.GetIPVersion(), err))
+		}
+	}
+	for _, set := range dp.ipsets {
+		if err := set.Clean(); err != nil {
+			if isNotPresent(err) {
+				slog.Info("ipset is not present, nothing to clean", "ipVersion", set.GetIPVersion())
+				continue
+			}
+			errs = append(errs, fmt.Errorf("clean ipset ipv%d failed: %w", set.GetIPVersion(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	slog.Info("finish removing our rules and ipsets")
 	return nil
 }
 
+func (dp *InternalDataplane) SendMessage(msg interface{}) error {
+	select {
+	case dp.toDataplane <- msg:
+		return nil
+	case <-dp.parentCtx.Done():
+		return dp.parentCtx.Err()
+	}
+}
+
+// ReceiveMessage waits for the next message of dataplane, e.g. MessageApplied
 func (dp *InternalDataplane) ReceiveMessage() (interface{}, error) {
-	return <-dp.fromDataplane, nil
+	select {
+	case msg := <-dp.fromDataplane:
+		return msg, nil
+	case <-dp.parentCtx.Done():
+		return nil, dp.parentCtx.Err()
+	}
 }
@@ -0,0 +1,12 @@
+package linux
+
+import "github.com/bamboo-firewall/agent/pkg/executor"
+
+type option func(*InternalDataplane)
+
+// WithExecutor runs iptables and ipset commands with e instead of the commands of host
+func WithExecutor(e executor.Executor) option {
+	return func(dp *InternalDataplane) {
+		dp.executor = e
+	}
+}
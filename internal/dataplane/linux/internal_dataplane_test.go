@@ -0,0 +1,64 @@
+package linux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/internal/status"
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+)
+
+func TestNewInternalDataplaneJumpPosition(t *testing.T) {
+	tests := []struct {
+		name         string
+		backend      string
+		jumpPosition string
+		hasErr       bool
+	}{
+		{
+			name:         "iptables first",
+			backend:      config.DataplaneBackendIPTables,
+			jumpPosition: "first",
+		},
+		{
+			name:         "nftables default",
+			backend:      config.DataplaneBackendNFTables,
+			jumpPosition: "",
+		},
+		{
+			name:         "nftables last",
+			backend:      config.DataplaneBackendNFTables,
+			jumpPosition: "last",
+		},
+		{
+			name:         "nftables first",
+			backend:      config.DataplaneBackendNFTables,
+			jumpPosition: "first",
+			hasErr:       true,
+		},
+		{
+			name:         "nftables after chain",
+			backend:      config.DataplaneBackendNFTables,
+			jumpPosition: "after:DOCKER-USER",
+			hasErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewInternalDataplane(context.Background(), config.Config{
+				APIServerAddress: "http://10.0.0.1:9091",
+				LogLevel:         "notice",
+				DataplaneBackend: tt.backend,
+				JumpPosition:     tt.jumpPosition,
+			}, status.New(), WithExecutor(fake.NewDataplane()))
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
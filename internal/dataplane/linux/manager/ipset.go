@@ -2,6 +2,7 @@ package manager
 
 import (
 	"log/slog"
+	"strconv"
 
 	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
@@ -14,30 +15,71 @@ const (
 	sourceSetGNS = "gns"
 )
 
+// IPSetDataplane sets of network in dataplane, implemented by ipset and nftables sets
+type IPSetDataplane interface {
+	GetIPVersion() int
+	UpdateIPSet(ipset map[string]map[string]struct{})
+}
+
+// NetSetRenderer renders the agent managed sets of literal nets referenced by rules
+type NetSetRenderer interface {
+	HostEndpointPoliciesToNetSets(hostEndpointPolicies []*dto.HostEndpointPolicy, ipVersion int) map[string]map[string]struct{}
+}
+
 type IPSet struct {
-	ipset               *ipset.IPSet
+	ipset               IPSetDataplane
 	ipsetNameConvention *ipset.NameConvention
+	netSetRenderer      NetSetRenderer
 }
 
-func NewIPSet(ipset *ipset.IPSet, ipsetNameConvention *ipset.NameConvention) *IPSet {
+func NewIPSet(ipset IPSetDataplane, ipsetNameConvention *ipset.NameConvention, netSetRenderer NetSetRenderer) *IPSet {
 	return &IPSet{
 		ipset:               ipset,
 		ipsetNameConvention: ipsetNameConvention,
+		netSetRenderer:      netSetRenderer,
 	}
 }
 
 func (i *IPSet) OnUpdate(msg interface{}) {
 	switch m := msg.(type) {
-	case *dto.HostEndpointPolicy:
-		sets := i.networkSetsToIPSets(m.ParsedHEPs, m.ParsedGNSs)
+	case []*dto.HostEndpointPolicy:
+		// host endpoints of the same host share most of their sets
+		var (
+			parsedHEPs []*dto.ParsedHEP
+			parsedGNSs []*dto.ParsedGNS
+		)
+		seenUUIDs := make(map[string]struct{})
+		for _, hostEndpointPolicy := range m {
+			for _, parsedHEP := range hostEndpointPolicy.ParsedHEPs {
+				if _, ok := seenUUIDs[parsedHEP.UUID]; !ok {
+					seenUUIDs[parsedHEP.UUID] = struct{}{}
+					parsedHEPs = append(parsedHEPs, parsedHEP)
+				}
+			}
+			for _, parsedGNS := range hostEndpointPolicy.ParsedGNSs {
+				if _, ok := seenUUIDs[parsedGNS.UUID]; !ok {
+					seenUUIDs[parsedGNS.UUID] = struct{}{}
+					parsedGNSs = append(parsedGNSs, parsedGNS)
+				}
+			}
+		}
+		sets := i.networkSetsToIPSets(parsedHEPs, parsedGNSs)
+		for name, members := range i.netSetRenderer.HostEndpointPoliciesToNetSets(m, i.ipset.GetIPVersion()) {
+			sets[name] = members
+		}
 
 		i.ipset.UpdateIPSet(sets)
+
+		var numMembers int
+		for _, members := range sets {
+			numMembers += len(members)
+		}
+		ipsetMembersGauge.WithLabelValues(strconv.Itoa(i.ipset.GetIPVersion())).Set(float64(numMembers))
 	}
 }
 
 func (i *IPSet) networkSetsToIPSets(parsedHEPs []*dto.ParsedHEP, parsedGNSs []*dto.ParsedGNS) map[string]map[string]struct{} {
 	sets := make(map[string]map[string]struct{})
-	var index int
 	for _, parsedHEP := range parsedHEPs {
 		var ips []string
 		if i.ipset.GetIPVersion() == generictables.IPFamily4 && len(parsedHEP.IPsV4) > 0 {
@@ -58,13 +100,11 @@ func (i *IPSet) networkSetsToIPSets(parsedHEPs []*dto.ParsedHEP, parsedGNSs []*d
 			members[ipnet.String()] = struct{}{}
 		}
 
-		mainName := i.ipsetNameConvention.SetMainNameOfSet(parsedHEP.UUID, index, i.ipset.GetIPVersion(), sourceSetHEP, parsedHEP.Name)
+		mainName := i.ipsetNameConvention.SetMainNameOfSet(parsedHEP.UUID, i.ipset.GetIPVersion(), sourceSetHEP, parsedHEP.Name)
 
 		sets[mainName] = members
-		index++
 	}
 
-	index = 0
 	for _, parsedGNS := range parsedGNSs {
 		var nets []string
 		if i.ipset.GetIPVersion() == generictables.IPFamily4 {
@@ -80,10 +120,9 @@ func (i *IPSet) networkSetsToIPSets(parsedHEPs []*dto.ParsedHEP, parsedGNSs []*d
 			members[net] = struct{}{}
 		}
 
-		mainName := i.ipsetNameConvention.SetMainNameOfSet(parsedGNS.UUID, index, i.ipset.GetIPVersion(), sourceSetGNS, parsedGNS.Name)
+		mainName := i.ipsetNameConvention.SetMainNameOfSet(parsedGNS.UUID, i.ipset.GetIPVersion(), sourceSetGNS, parsedGNS.Name)
 
 		sets[mainName] = members
-		index++
 	}
 
 	return sets
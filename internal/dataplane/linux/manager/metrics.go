@@ -0,0 +1,22 @@
+package manager
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	chainsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bamboo_dataplane_chains",
+		Help: "Number of our chains rendered from policies.",
+	}, []string{"ip_version"})
+	rulesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bamboo_dataplane_rules",
+		Help: "Number of rules in our chains rendered from policies.",
+	}, []string{"ip_version"})
+	ipsetMembersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bamboo_dataplane_ipset_members",
+		Help: "Number of members of our ipsets.",
+	}, []string{"ip_version"})
+)
+
+func init() {
+	prometheus.MustRegister(chainsGauge, rulesGauge, ipsetMembersGauge)
+}
@@ -1,41 +1,57 @@
 package manager
 
 import (
+	"strconv"
+
 	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 )
 
 type RuleRenderer interface {
-	PoliciesToIptablesChains(policies []*dto.ParsedGNP, ipVersion int, apiServerIPV4 string) []*generictables.Chain
+	HostEndpointPoliciesToChains(hostEndpointPolicies []*dto.HostEndpointPolicy, ipVersion int) []*generictables.Chain
+}
+
+// ChainsReporter receives the chains rendered from policies
+type ChainsReporter interface {
+	SetChains(ipVersion int, chains []*generictables.Chain)
 }
 
 type policy struct {
 	filterTable generictables.Table
 
-	ruleRenderer  RuleRenderer
-	ipVersion     int
-	apiServerIPV4 string
+	ruleRenderer   RuleRenderer
+	ipVersion      int
+	chainsReporter ChainsReporter
 }
 
-func NewPolicy(filterTable generictables.Table, ipVersion int, apiServerIPV4 string, renderer RuleRenderer) *policy {
+func NewPolicy(filterTable generictables.Table, ipVersion int, renderer RuleRenderer,
+	chainsReporter ChainsReporter) *policy {
 	return &policy{
-		filterTable:   filterTable,
-		ruleRenderer:  renderer,
-		ipVersion:     ipVersion,
-		apiServerIPV4: apiServerIPV4,
+		filterTable:    filterTable,
+		ruleRenderer:   renderer,
+		ipVersion:      ipVersion,
+		chainsReporter: chainsReporter,
 	}
 }
 
 func (p *policy) OnUpdate(msg interface{}) {
 	switch m := msg.(type) {
-	case *dto.HostEndpointPolicy:
+	case []*dto.HostEndpointPolicy:
 		var chains []*generictables.Chain
-		if m.HEP == nil {
+		if len(m) == 0 {
 			p.filterTable.NeedClean()
 		} else {
-			chains = p.ruleRenderer.PoliciesToIptablesChains(m.ParsedGNPs, p.ipVersion, p.apiServerIPV4)
+			chains = p.ruleRenderer.HostEndpointPoliciesToChains(m, p.ipVersion)
 		}
 
 		p.filterTable.UpdateChains(chains)
+		p.chainsReporter.SetChains(p.ipVersion, chains)
+
+		var numRules int
+		for _, chain := range chains {
+			numRules += len(chain.Rules)
+		}
+		chainsGauge.WithLabelValues(strconv.Itoa(p.ipVersion)).Set(float64(len(chains)))
+		rulesGauge.WithLabelValues(strconv.Itoa(p.ipVersion)).Set(float64(numRules))
 	}
 }
@@ -0,0 +1,35 @@
+package linux
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// lastSuccessfulApplyTime unix nano of the last apply without error, process start time before that
+	lastSuccessfulApplyTime atomic.Int64
+
+	lastSuccessfulApplyTimestamp = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bamboo_dataplane_last_successful_apply_timestamp_seconds",
+		Help: "Unix time of the last apply of every table and ipset without error.",
+	}, func() float64 {
+		return float64(lastSuccessfulApplyTime.Load()) / float64(time.Second)
+	})
+	secondsSinceLastSuccessfulApply = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bamboo_dataplane_seconds_since_last_successful_apply",
+		Help: "Seconds since the last apply of every table and ipset without error.",
+	}, func() float64 {
+		return time.Since(time.Unix(0, lastSuccessfulApplyTime.Load())).Seconds()
+	})
+	applyDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "bamboo_dataplane_apply_duration_seconds",
+		Help: "Time taken to apply every table and ipset to dataplane.",
+	})
+)
+
+func init() {
+	lastSuccessfulApplyTime.Store(time.Now().UnixNano())
+	prometheus.MustRegister(lastSuccessfulApplyTimestamp, secondsSinceLastSuccessfulApply, applyDurationSeconds)
+}
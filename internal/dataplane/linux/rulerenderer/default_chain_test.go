@@ -0,0 +1,164 @@
+package rulerenderer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+)
+
+// chainRulesOf renders the rules of chains, indexed by chain name
+func chainRulesOf(chains []*generictables.Chain) map[string][]string {
+	chainRules := make(map[string][]string)
+	for _, chain := range chains {
+		rules := make([]string, 0, len(chain.Rules))
+		for _, rule := range chain.Rules {
+			rules = append(rules, rule.Match.Render()+" "+rule.Action.ToParameter())
+		}
+		chainRules[chain.Name] = rules
+	}
+	return chainRules
+}
+
+// indexOfJump returns the index of the first rule jumping to chainName, -1 when no rule does
+func indexOfJump(rules []string, chainName string) int {
+	return slices.IndexFunc(rules, func(rule string) bool {
+		return strings.HasSuffix(rule, "-j "+chainName)
+	})
+}
+
+func TestFailsafeRules(t *testing.T) {
+	allowWeb := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"80"}}}
+	policy := &dto.ParsedGNP{UUID: "1", Name: "p", InboundRules: allowWeb, OutboundRules: allowWeb}
+	inboundChain := iptables.GetCustomChainName(generictables.OurInputChainPrefix+"p", "1")
+	outboundChain := iptables.GetCustomChainName(generictables.OurOutputChainPrefix+"p", "1")
+	tests := []struct {
+		name      string
+		ipVersion int
+		inbound   []config.ProtoPort
+		outbound  []config.ProtoPort
+		// expected fail-safe rules of our default input and output chains
+		expectedInput  []string
+		expectedOutput []string
+	}{
+		{
+			name:      "no fail-safe port by default",
+			ipVersion: generictables.IPFamily4,
+		},
+		{
+			name:           "ipv4",
+			ipVersion:      generictables.IPFamily4,
+			inbound:        []config.ProtoPort{{Protocol: "tcp", Port: 22}},
+			outbound:       []config.ProtoPort{{Protocol: "udp", Port: 53}, {Protocol: "tcp", Port: 53}},
+			expectedInput:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT"},
+			expectedOutput: []string{"-p udp -m multiport --destination-ports 53 -j ACCEPT", "-p tcp -m multiport --destination-ports 53 -j ACCEPT"},
+		},
+		{
+			name:           "ipv6",
+			ipVersion:      generictables.IPFamily6,
+			inbound:        []config.ProtoPort{{Protocol: "tcp", Port: 22}},
+			outbound:       []config.ProtoPort{{Protocol: "udp", Port: 123}},
+			expectedInput:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT"},
+			expectedOutput: []string{"-p udp -m multiport --destination-ports 123 -j ACCEPT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{
+				IPVersion:                 tt.ipVersion,
+				FailsafeInboundHostPorts:  tt.inbound,
+				FailsafeOutboundHostPorts: tt.outbound,
+			}, ipset.NewNameConvention())
+			chainRules := chainRulesOf(r.HostEndpointPoliciesToChains([]*dto.HostEndpointPolicy{{
+				HEP:        &dto.HostEndpoint{Metadata: dto.HostEndpointMetadata{Name: "host"}},
+				ParsedGNPs: []*dto.ParsedGNP{policy},
+			}}, tt.ipVersion))
+
+			for _, c := range []struct {
+				chainName   string
+				policyChain string
+				expected    []string
+			}{
+				{generictables.OurDefaultInputChain, inboundChain, tt.expectedInput},
+				{generictables.OurDefaultOutputChain, outboundChain, tt.expectedOutput},
+			} {
+				rules := chainRules[c.chainName]
+				jumpIndex := indexOfJump(rules, c.policyChain)
+				require.GreaterOrEqual(t, jumpIndex, 0, c.chainName)
+				for _, expected := range c.expected {
+					failsafeIndex := slices.Index(rules, expected)
+					assert.GreaterOrEqual(t, failsafeIndex, 0, "%s: missing %s", c.chainName, expected)
+					assert.Less(t, failsafeIndex, jumpIndex, "%s: %s after policy jump", c.chainName, expected)
+				}
+				if len(c.expected) == 0 {
+					for _, rule := range rules[:jumpIndex] {
+						assert.NotContains(t, rule, "--destination-ports", c.chainName)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestICMPv6EssentialRules(t *testing.T) {
+	allowWeb := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"80"}}}
+	policy := &dto.ParsedGNP{UUID: "1", Name: "p", InboundRules: allowWeb, OutboundRules: allowWeb}
+	inboundChain := iptables.GetCustomChainName(generictables.OurInputChainPrefix+"p", "1")
+	outboundChain := iptables.GetCustomChainName(generictables.OurOutputChainPrefix+"p", "1")
+	icmpv6Types := []uint8{133, 135}
+	tests := []struct {
+		name      string
+		ipVersion int
+		// expected icmpv6 rules of our default input and output chains
+		expected []string
+	}{
+		{
+			name:      "ipv4",
+			ipVersion: generictables.IPFamily4,
+		},
+		{
+			name:      "ipv6",
+			ipVersion: generictables.IPFamily6,
+			expected: []string{
+				"-p icmpv6 -m icmp6 --icmpv6-type 133 -j ACCEPT",
+				"-p icmpv6 -m icmp6 --icmpv6-type 135 -j ACCEPT",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: tt.ipVersion, ICMPv6EssentialTypes: icmpv6Types}, ipset.NewNameConvention())
+			chainRules := chainRulesOf(r.HostEndpointPoliciesToChains([]*dto.HostEndpointPolicy{{
+				HEP:        &dto.HostEndpoint{Metadata: dto.HostEndpointMetadata{Name: "host"}},
+				ParsedGNPs: []*dto.ParsedGNP{policy},
+			}}, tt.ipVersion))
+
+			for chainName, policyChain := range map[string]string{
+				generictables.OurDefaultInputChain:  inboundChain,
+				generictables.OurDefaultOutputChain: outboundChain,
+			} {
+				rules := chainRules[chainName]
+				jumpIndex := indexOfJump(rules, policyChain)
+				require.GreaterOrEqual(t, jumpIndex, 0, chainName)
+				var icmpv6Rules []string
+				for _, rule := range rules {
+					if strings.Contains(rule, "icmp6") {
+						icmpv6Rules = append(icmpv6Rules, rule)
+					}
+				}
+				assert.Equal(t, tt.expected, icmpv6Rules, chainName)
+				for _, expected := range tt.expected {
+					assert.Less(t, slices.Index(rules, expected), jumpIndex, "%s: %s after policy jump", chainName, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package rulerenderer
+
+import (
+	"strings"
+
+	"github.com/bamboo-firewall/agent/internal/flowlog"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+// flowLogRule sends packets of match to the flow log collector before the rule deciding their verdict
+func (r *DefaultRuleRenderer) flowLogRule(match generictables.MatchCriteria, verdict string, origin ruleOrigin) generictables.Rule {
+	return generictables.Rule{
+		Match:  match,
+		Action: r.NFLog(r.nflogGroup, flowLogPrefix(verdict, origin)),
+	}
+}
+
+func flowLogPrefix(verdict string, origin ruleOrigin) string {
+	return flowlog.EncodePrefix(flowlog.Tag{
+		Verdict:   verdict,
+		Direction: origin.direction,
+		Rule:      origin.index,
+		Policy:    origin.policyName,
+	})
+}
+
+// verdictOfAction returns the verdict of rule action, false for actions that do not end the evaluation
+// of packets
+func verdictOfAction(action string) (string, bool) {
+	switch strings.ToLower(action) {
+	case "allow":
+		return flowlog.VerdictAllow, true
+	case "reject":
+		return flowlog.VerdictReject, true
+	case "pass":
+		return flowlog.VerdictPass, true
+	case "log", "nflog":
+		return "", false
+	default:
+		// unknown actions are rendered as drop
+		return flowlog.VerdictDeny, true
+	}
+}
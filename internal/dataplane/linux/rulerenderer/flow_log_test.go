@@ -0,0 +1,81 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func TestFlowLogRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     Config
+		rule     *dto.ParsedRule
+		expected []string
+	}{
+		{
+			name: "flow logs disabled",
+			conf: Config{IPVersion: generictables.IPFamily4, NFLogGroup: 20},
+			rule: &dto.ParsedRule{Action: "allow", Protocol: "tcp"},
+			expected: []string{
+				"-p tcp -j ACCEPT",
+			},
+		},
+		{
+			name: "iptables allow",
+			conf: Config{IPVersion: generictables.IPFamily4, NFLogGroup: 20, FlowLogs: true},
+			rule: &dto.ParsedRule{Action: "allow", Protocol: "tcp"},
+			expected: []string{
+				`-p tcp -j NFLOG --nflog-group 20 --nflog-prefix "A|in|1|allow-ssh"`,
+				"-p tcp -j ACCEPT",
+			},
+		},
+		{
+			name: "nftables unknown action is logged as deny",
+			conf: Config{IPVersion: generictables.IPFamily6, NFTables: true, NFLogGroup: 5, FlowLogs: true},
+			rule: &dto.ParsedRule{Action: "unknown", Protocol: "udp"},
+			expected: []string{
+				`meta l4proto udp log prefix "D|in|1|allow-ssh" group 5`,
+				"meta l4proto udp drop",
+			},
+		},
+		{
+			name: "log rules are not flow logged",
+			conf: Config{IPVersion: generictables.IPFamily4, NFLogGroup: 20, FlowLogs: true},
+			rule: &dto.ParsedRule{Action: "nflog", Protocol: "tcp"},
+			expected: []string{
+				`-p tcp -j NFLOG --nflog-group 20 --nflog-prefix "L|in|1|allow-ssh"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(tt.conf, ipset.NewNameConvention())
+			origin := ruleOrigin{policyName: "allow-ssh", direction: directionIn, index: 1}
+			rules := r.ruleToTablesRules(tt.rule, tt.conf.IPVersion, origin)
+			rendered := make([]string, 0, len(rules))
+			for _, rule := range rules {
+				rendered = append(rendered, rule.Match.Render()+" "+rule.Action.ToParameter())
+			}
+			assert.Equal(t, tt.expected, rendered)
+		})
+	}
+}
+
+func TestDefaultActionRulesWithFlowLogs(t *testing.T) {
+	r := NewRenderer(Config{
+		IPVersion:       generictables.IPFamily4,
+		NFLogGroup:      20,
+		FlowLogs:        true,
+		RejectByDefault: true,
+	}, ipset.NewNameConvention())
+	rules := r.defaultActionRules(directionOut)
+	if assert.Len(t, rules, 2) {
+		assert.Equal(t, `-j NFLOG --nflog-group 20 --nflog-prefix "R|out|-1|"`, rules[0].Action.ToParameter())
+		assert.Equal(t, "-j REJECT", rules[1].Action.ToParameter())
+	}
+}
@@ -0,0 +1,202 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+)
+
+func TestForwardChains(t *testing.T) {
+	allowWeb := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"80"}}}
+	forwardInboundChain := iptables.GetCustomChainName(generictables.OurForwardInputChainPrefix+"p", "1")
+	forwardOutboundChain := iptables.GetCustomChainName(generictables.OurForwardOutputChainPrefix+"p", "1")
+	const (
+		established = "-m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT"
+		clearMark   = "-m mark --mark 0x100000/0x100000 -j MARK --set-xmark 0x0/0x100000"
+		notAllowed  = "-m mark ! --mark 0x100000/0x100000"
+		allowed     = "-m mark --mark 0x100000/0x100000"
+	)
+	tests := []struct {
+		name               string
+		forwardEnforcement bool
+		interfaceName      string
+		policy             *dto.ParsedGNP
+		// expected rules of chains, a missing chain is not rendered
+		expected map[string][]string
+	}{
+		{
+			name:   "forward enforcement disabled",
+			policy: &dto.ParsedGNP{UUID: "1", Name: "p", ForwardInboundRules: allowWeb},
+			expected: map[string][]string{
+				generictables.OurDefaultForwardChain: nil,
+				forwardInboundChain:                  nil,
+			},
+		},
+		{
+			name:               "no forward policy leaves forwarded packets alone",
+			forwardEnforcement: true,
+			policy:             &dto.ParsedGNP{UUID: "1", Name: "p", InboundRules: allowWeb},
+			expected: map[string][]string{
+				generictables.OurDefaultForwardChain: {},
+				forwardInboundChain:                  nil,
+			},
+		},
+		{
+			name:               "all interfaces",
+			forwardEnforcement: true,
+			policy:             &dto.ParsedGNP{UUID: "1", Name: "p", ForwardInboundRules: allowWeb},
+			expected: map[string][]string{
+				generictables.OurDefaultForwardChain: {
+					established,
+					clearMark,
+					notAllowed + " -j " + forwardInboundChain,
+					notAllowed + " -j DROP",
+					allowed + " -j ACCEPT",
+				},
+				forwardInboundChain: {
+					"-p tcp -m multiport --destination-ports 80 -j MARK --set-xmark 0x100000/0x100000",
+					"-p tcp -m multiport --destination-ports 80 -j RETURN",
+				},
+				forwardOutboundChain: nil,
+			},
+		},
+		{
+			name:               "typed policy on interface",
+			forwardEnforcement: true,
+			interfaceName:      "eth1",
+			policy:             &dto.ParsedGNP{UUID: "1", Name: "p", Types: []string{dto.PolicyTypeForward}, ForwardOutboundRules: allowWeb},
+			expected: map[string][]string{
+				generictables.OurDefaultForwardChain: {
+					established,
+					clearMark,
+					"--in-interface eth1 -j " + generictables.OurHostEndpointForwardInputChainPrefix + "eth1",
+					"--out-interface eth1 -j " + generictables.OurHostEndpointForwardOutputChainPrefix + "eth1",
+					allowed + " -j ACCEPT",
+				},
+				generictables.OurHostEndpointForwardInputChainPrefix + "eth1": {
+					notAllowed + " -j " + forwardInboundChain,
+					allowed + " -j RETURN",
+					" -j DROP",
+				},
+				generictables.OurHostEndpointForwardOutputChainPrefix + "eth1": {" -j " + forwardOutboundChain, " -j DROP"},
+				forwardInboundChain:  {" -j DROP"},
+				forwardOutboundChain: {"-p tcp -m multiport --destination-ports 80 -j ACCEPT"},
+			},
+		},
+		{
+			// a packet allowed by the forward inbound rules is returned to the forward outbound policies
+			name:               "forward inbound and outbound rules",
+			forwardEnforcement: true,
+			interfaceName:      "eth0",
+			policy:             &dto.ParsedGNP{UUID: "1", Name: "p", ForwardInboundRules: allowWeb, ForwardOutboundRules: allowWeb},
+			expected: map[string][]string{
+				generictables.OurDefaultForwardChain: {
+					established,
+					clearMark,
+					"--in-interface eth0 -j " + generictables.OurHostEndpointForwardInputChainPrefix + "eth0",
+					"--out-interface eth0 -j " + generictables.OurHostEndpointForwardOutputChainPrefix + "eth0",
+					allowed + " -j ACCEPT",
+				},
+				generictables.OurHostEndpointForwardInputChainPrefix + "eth0": {
+					notAllowed + " -j " + forwardInboundChain,
+					allowed + " -j RETURN",
+					" -j DROP",
+				},
+				generictables.OurHostEndpointForwardOutputChainPrefix + "eth0": {" -j " + forwardOutboundChain, " -j DROP"},
+				forwardInboundChain: {
+					"-p tcp -m multiport --destination-ports 80 -j MARK --set-xmark 0x100000/0x100000",
+					"-p tcp -m multiport --destination-ports 80 -j RETURN",
+				},
+				forwardOutboundChain: {"-p tcp -m multiport --destination-ports 80 -j ACCEPT"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{
+				IPVersion:          generictables.IPFamily4,
+				ForwardEnforcement: tt.forwardEnforcement,
+				ForwardMark:        0x100000,
+			}, ipset.NewNameConvention())
+			chains := r.HostEndpointPoliciesToChains([]*dto.HostEndpointPolicy{{
+				HEP: &dto.HostEndpoint{
+					Metadata: dto.HostEndpointMetadata{Name: "host"},
+					Spec:     dto.HostEndpointSpec{InterfaceName: tt.interfaceName},
+				},
+				ParsedGNPs: []*dto.ParsedGNP{tt.policy},
+			}}, generictables.IPFamily4)
+			chainRules := make(map[string][]string)
+			for _, chain := range chains {
+				rules := make([]string, 0, len(chain.Rules))
+				for _, rule := range chain.Rules {
+					rules = append(rules, rule.Match.Render()+" "+rule.Action.ToParameter())
+				}
+				chainRules[chain.Name] = rules
+			}
+			for chainName, expected := range tt.expected {
+				if expected == nil {
+					assert.NotContains(t, chainRules, chainName)
+					continue
+				}
+				assert.Equal(t, expected, chainRules[chainName], chainName)
+			}
+		})
+	}
+}
+
+func TestForwardChainsOfInterfaceAndAllInterfaces(t *testing.T) {
+	allowWeb := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"80"}}}
+	interfacePolicy := &dto.ParsedGNP{UUID: "1", Name: "p1", ForwardInboundRules: allowWeb, ForwardOutboundRules: allowWeb}
+	allInterfacesPolicy := &dto.ParsedGNP{UUID: "2", Name: "p2", ForwardInboundRules: allowWeb, ForwardOutboundRules: allowWeb}
+	forwardInboundChain := func(policy *dto.ParsedGNP) string {
+		return iptables.GetCustomChainName(generictables.OurForwardInputChainPrefix+policy.Name, policy.UUID)
+	}
+	forwardOutboundChain := func(policy *dto.ParsedGNP) string {
+		return iptables.GetCustomChainName(generictables.OurForwardOutputChainPrefix+policy.Name, policy.UUID)
+	}
+	const (
+		notAllowed = "-m mark ! --mark 0x100000/0x100000"
+		allowed    = "-m mark --mark 0x100000/0x100000"
+	)
+
+	r := NewRenderer(Config{
+		IPVersion:          generictables.IPFamily4,
+		ForwardEnforcement: true,
+		ForwardMark:        0x100000,
+	}, ipset.NewNameConvention())
+	chains := r.HostEndpointPoliciesToChains([]*dto.HostEndpointPolicy{
+		{
+			HEP: &dto.HostEndpoint{
+				Metadata: dto.HostEndpointMetadata{Name: "eth0"},
+				Spec:     dto.HostEndpointSpec{InterfaceName: "eth0"},
+			},
+			ParsedGNPs: []*dto.ParsedGNP{interfacePolicy},
+		},
+		{
+			HEP: &dto.HostEndpoint{
+				Metadata: dto.HostEndpointMetadata{Name: "all"},
+				Spec:     dto.HostEndpointSpec{InterfaceName: "*"},
+			},
+			ParsedGNPs: []*dto.ParsedGNP{allInterfacesPolicy},
+		},
+	}, generictables.IPFamily4)
+	chainRules := chainRulesOf(chains)
+
+	// the policies of all interfaces run after the policies of eth0, before the default action of eth0
+	assert.Equal(t, []string{
+		notAllowed + " -j " + forwardInboundChain(interfacePolicy),
+		notAllowed + " -j " + forwardInboundChain(allInterfacesPolicy),
+		allowed + " -j RETURN",
+		" -j DROP",
+	}, chainRules[generictables.OurHostEndpointForwardInputChainPrefix+"eth0"])
+	assert.Equal(t, []string{
+		" -j " + forwardOutboundChain(interfacePolicy),
+		" -j " + forwardOutboundChain(allInterfacesPolicy),
+		" -j DROP",
+	}, chainRules[generictables.OurHostEndpointForwardOutputChainPrefix+"eth0"])
+}
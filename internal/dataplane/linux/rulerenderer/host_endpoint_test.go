@@ -0,0 +1,142 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+)
+
+func TestHostEndpointInterfaceChains(t *testing.T) {
+	allowWeb := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"80"}}}
+	inboundPolicy := func(uuid string) *dto.ParsedGNP {
+		return &dto.ParsedGNP{UUID: uuid, Name: "p" + uuid, InboundRules: allowWeb}
+	}
+	outboundPolicy := func(uuid string) *dto.ParsedGNP {
+		return &dto.ParsedGNP{UUID: uuid, Name: "p" + uuid, OutboundRules: allowWeb}
+	}
+	bothPolicy := func(uuid string) *dto.ParsedGNP {
+		return &dto.ParsedGNP{UUID: uuid, Name: "p" + uuid, InboundRules: allowWeb, OutboundRules: allowWeb}
+	}
+	inboundChain := func(uuid string) string {
+		return iptables.GetCustomChainName(generictables.OurInputChainPrefix+"p"+uuid, uuid)
+	}
+	outboundChain := func(uuid string) string {
+		return iptables.GetCustomChainName(generictables.OurOutputChainPrefix+"p"+uuid, uuid)
+	}
+	hostEndpoint := func(name, interfaceName string, policies ...*dto.ParsedGNP) *dto.HostEndpointPolicy {
+		return &dto.HostEndpointPolicy{
+			HEP: &dto.HostEndpoint{
+				Metadata: dto.HostEndpointMetadata{Name: name},
+				Spec:     dto.HostEndpointSpec{InterfaceName: interfaceName},
+			},
+			ParsedGNPs: policies,
+		}
+	}
+	const established = "-m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT"
+
+	tests := []struct {
+		name                 string
+		hostEndpointPolicies []*dto.HostEndpointPolicy
+		// expected rules of chains, a missing chain is not rendered
+		expected map[string][]string
+		// expectedComments comments of the rules dispatching to the chains of interfaces
+		expectedComments map[string][]string
+	}{
+		{
+			// the policies of host endpoints of all interfaces run after the policies of the interface
+			name: "host endpoints with and without interface",
+			hostEndpointPolicies: []*dto.HostEndpointPolicy{
+				hostEndpoint("a", "eth0", bothPolicy("1")),
+				hostEndpoint("b", "eth0", inboundPolicy("2")),
+				// the policy shared with "a" is rendered once, jumped from both interfaces
+				hostEndpoint("c", "eth1", bothPolicy("1")),
+				hostEndpoint("d", "", inboundPolicy("3")),
+				hostEndpoint("e", "*", outboundPolicy("4")),
+			},
+			expected: map[string][]string{
+				generictables.OurHostEndpointInputChainPrefix + "eth0": {
+					" -j " + inboundChain("1"), " -j " + inboundChain("2"), " -j " + inboundChain("3"), " -j DROP",
+				},
+				generictables.OurHostEndpointOutputChainPrefix + "eth0": {" -j " + outboundChain("1"), " -j " + outboundChain("4"), " -j DROP"},
+				generictables.OurHostEndpointInputChainPrefix + "eth1":  {" -j " + inboundChain("1"), " -j " + inboundChain("3"), " -j DROP"},
+				generictables.OurHostEndpointOutputChainPrefix + "eth1": {" -j " + outboundChain("1"), " -j " + outboundChain("4"), " -j DROP"},
+				generictables.OurHostEndpointInputChainPrefix + "*":     nil,
+				generictables.OurDefaultInputChain: {
+					established,
+					"--in-interface eth0 -j " + generictables.OurHostEndpointInputChainPrefix + "eth0",
+					"--in-interface eth1 -j " + generictables.OurHostEndpointInputChainPrefix + "eth1",
+					" -j " + inboundChain("3"),
+					" -j DROP",
+				},
+				generictables.OurDefaultOutputChain: {
+					established,
+					"--out-interface eth0 -j " + generictables.OurHostEndpointOutputChainPrefix + "eth0",
+					"--out-interface eth1 -j " + generictables.OurHostEndpointOutputChainPrefix + "eth1",
+					" -j " + outboundChain("4"),
+					" -j DROP",
+				},
+			},
+			expectedComments: map[string][]string{
+				generictables.OurDefaultInputChain:  {"", "Host endpoint a,b", "Host endpoint c", "", ""},
+				generictables.OurDefaultOutputChain: {"", "Host endpoint a,b", "Host endpoint c", "", ""},
+			},
+		},
+		{
+			name: "host endpoints without interface",
+			hostEndpointPolicies: []*dto.HostEndpointPolicy{
+				hostEndpoint("a", "", bothPolicy("1")),
+				hostEndpoint("b", "", inboundPolicy("2")),
+			},
+			expected: map[string][]string{
+				generictables.OurHostEndpointInputChainPrefix + "eth0":  nil,
+				generictables.OurHostEndpointOutputChainPrefix + "eth0": nil,
+				generictables.OurDefaultInputChain:                      {established, " -j " + inboundChain("1"), " -j " + inboundChain("2"), " -j DROP"},
+				generictables.OurDefaultOutputChain:                     {established, " -j " + outboundChain("1"), " -j DROP"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: generictables.IPFamily4}, ipset.NewNameConvention())
+			chains := r.HostEndpointPoliciesToChains(tt.hostEndpointPolicies, generictables.IPFamily4)
+			chainRules := chainRulesOf(chains)
+			for chainName, expected := range tt.expected {
+				if expected == nil {
+					assert.NotContains(t, chainRules, chainName)
+					continue
+				}
+				assert.Equal(t, expected, chainRules[chainName], chainName)
+			}
+
+			// each policy is rendered once
+			chainNames := make(map[string]int)
+			for _, chain := range chains {
+				chainNames[chain.Name]++
+			}
+			for chainName, count := range chainNames {
+				assert.Equal(t, 1, count, chainName)
+			}
+
+			for _, chain := range chains {
+				expected, ok := tt.expectedComments[chain.Name]
+				if !ok {
+					continue
+				}
+				comments := make([]string, 0, len(chain.Rules))
+				for _, rule := range chain.Rules {
+					comment := ""
+					if len(rule.Comment) > 0 {
+						comment = rule.Comment[0]
+					}
+					comments = append(comments, comment)
+				}
+				assert.Equal(t, expected, comments, chain.Name)
+			}
+		})
+	}
+}
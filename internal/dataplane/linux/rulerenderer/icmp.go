@@ -0,0 +1,98 @@
+package rulerenderer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const (
+	protocolNumICMP   = 1
+	protocolNumICMPv6 = 58
+)
+
+func hasICMPMatch(rule *dto.ParsedRule) bool {
+	return rule.ICMPType != nil || rule.ICMPCode != nil || rule.NotICMPType != nil || rule.NotICMPCode != nil
+}
+
+// icmpMatch renders icmp type and code of rule. The protocol of rule decides the flavour: icmp types
+// are matched by ipv4 rules and icmpv6 types by ipv6 rules, nil is returned for the other family.
+func (r *DefaultRuleRenderer) icmpMatch(rule *dto.ParsedRule, ipVersion int) (generictables.MatchCriteria, error) {
+	icmpVersion := icmpVersionOfProtocol(rule.Protocol)
+	if icmpVersion == 0 || rule.IsProtocolNegative {
+		return nil, fmt.Errorf("icmp type requires protocol icmp or icmpv6, got %v", rule.Protocol)
+	}
+	if rule.ICMPCode != nil && rule.ICMPType == nil {
+		return nil, errors.New("icmp code requires icmp type")
+	}
+	if rule.NotICMPCode != nil && rule.NotICMPType == nil {
+		return nil, errors.New("not icmp code requires not icmp type")
+	}
+	for _, value := range []*int{rule.ICMPType, rule.ICMPCode, rule.NotICMPType, rule.NotICMPCode} {
+		if value != nil && (*value < 0 || *value > 255) {
+			return nil, fmt.Errorf("icmp type or code %d is out of range", *value)
+		}
+	}
+	if icmpVersion != ipVersion {
+		return nil, nil
+	}
+
+	match := r.NewMatch()
+	if icmpVersion == generictables.IPFamily6 {
+		if rule.ICMPType != nil {
+			if rule.ICMPCode != nil {
+				match = match.ICMPV6TypeAndCode(uint8(*rule.ICMPType), uint8(*rule.ICMPCode))
+			} else {
+				match = match.ICMPV6Type(uint8(*rule.ICMPType))
+			}
+		}
+		if rule.NotICMPType != nil {
+			if rule.NotICMPCode != nil {
+				match = match.NotICMPV6TypeAndCode(uint8(*rule.NotICMPType), uint8(*rule.NotICMPCode))
+			} else {
+				match = match.NotICMPV6Type(uint8(*rule.NotICMPType))
+			}
+		}
+		return match, nil
+	}
+
+	if rule.ICMPType != nil {
+		if rule.ICMPCode != nil {
+			match = match.ICMPV4TypeAndCode(uint8(*rule.ICMPType), uint8(*rule.ICMPCode))
+		} else {
+			match = match.ICMPV4Type(uint8(*rule.ICMPType))
+		}
+	}
+	if rule.NotICMPType != nil {
+		if rule.NotICMPCode != nil {
+			match = match.NotICMPV4TypeAndCode(uint8(*rule.NotICMPType), uint8(*rule.NotICMPCode))
+		} else {
+			match = match.NotICMPV4Type(uint8(*rule.NotICMPType))
+		}
+	}
+	return match, nil
+}
+
+// icmpVersionOfProtocol returns the ip family of icmp protocol, 0 when protocol is not icmp
+func icmpVersionOfProtocol(protocol interface{}) int {
+	switch p := protocol.(type) {
+	case string:
+		switch strings.ToLower(p) {
+		case dto.ProtocolICMP:
+			return generictables.IPFamily4
+		case dto.ProtocolICMPv6:
+			return generictables.IPFamily6
+		}
+	case float64:
+		switch p {
+		case protocolNumICMP:
+			return generictables.IPFamily4
+		case protocolNumICMPv6:
+			return generictables.IPFamily6
+		}
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func TestICMPMatch(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name      string
+		nftables  bool
+		ipVersion int
+		rule      *dto.ParsedRule
+		expected  string
+		skipped   bool
+		hasErr    bool
+	}{
+		{
+			name:      "iptables echo request",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Protocol: "icmp", ICMPType: intPtr(8)},
+			expected:  "-m icmp --icmp-type 8",
+		},
+		{
+			name:      "iptables type and code and not type",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Protocol: float64(1), ICMPType: intPtr(3), ICMPCode: intPtr(4), NotICMPType: intPtr(0)},
+			expected:  "-m icmp --icmp-type 3/4 -m icmp ! --icmp-type 0",
+		},
+		{
+			name:      "iptables neighbor solicitation",
+			ipVersion: generictables.IPFamily6,
+			rule:      &dto.ParsedRule{Protocol: "ICMPv6", ICMPType: intPtr(135)},
+			expected:  "-m icmp6 --icmpv6-type 135",
+		},
+		{
+			name:      "nftables not type and code",
+			nftables:  true,
+			ipVersion: generictables.IPFamily6,
+			rule:      &dto.ParsedRule{Protocol: "icmpv6", NotICMPType: intPtr(1), NotICMPCode: intPtr(3)},
+			expected:  "icmpv6 type . icmpv6 code != { 1 . 3 }",
+		},
+		{
+			name:      "icmp type of other family",
+			ipVersion: generictables.IPFamily6,
+			rule:      &dto.ParsedRule{Protocol: "icmp", ICMPType: intPtr(8)},
+			skipped:   true,
+		},
+		{
+			name:      "code without type",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Protocol: "icmp", ICMPCode: intPtr(0)},
+			hasErr:    true,
+		},
+		{
+			name:      "non icmp protocol",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Protocol: "tcp", ICMPType: intPtr(8)},
+			hasErr:    true,
+		},
+		{
+			name:      "out of range",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Protocol: "icmp", ICMPType: intPtr(256)},
+			hasErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: tt.ipVersion, NFTables: tt.nftables}, ipset.NewNameConvention())
+			match, err := r.icmpMatch(tt.rule, tt.ipVersion)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.skipped {
+				assert.Nil(t, match)
+				return
+			}
+			assert.Equal(t, tt.expected, match.Render())
+		})
+	}
+}
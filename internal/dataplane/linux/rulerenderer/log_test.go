@@ -0,0 +1,73 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func TestLogRule(t *testing.T) {
+	tests := []struct {
+		name              string
+		conf              Config
+		origin            ruleOrigin
+		expectedMatch     string
+		expectedAction    string
+		expectedTruncated bool
+	}{
+		{
+			name: "iptables",
+			conf: Config{
+				IPVersion:         generictables.IPFamily4,
+				LogPrefix:         "bfw:{direction}:{rule} {policy}",
+				LogLevel:          4,
+				LogRateLimit:      "10/minute",
+				LogRateLimitBurst: 5,
+			},
+			origin:         ruleOrigin{policyName: "allow-ssh", direction: directionIn, index: 2},
+			expectedMatch:  "-p tcp -m limit --limit 10/minute --limit-burst 5",
+			expectedAction: `-j LOG --log-prefix "bfw:in:2 allow-ssh " --log-level 4`,
+		},
+		{
+			name: "iptables truncated prefix without rate limit",
+			conf: Config{
+				IPVersion: generictables.IPFamily4,
+				LogPrefix: "bfw:{direction}:{rule} {policy}",
+				LogLevel:  5,
+			},
+			origin:            ruleOrigin{policyName: "allow-monitoring-from-office", direction: directionOut, index: 0},
+			expectedMatch:     "-p tcp",
+			expectedAction:    `-j LOG --log-prefix "bfw:out:0 allow-monitoring-f " --log-level 5`,
+			expectedTruncated: true,
+		},
+		{
+			name: "nftables",
+			conf: Config{
+				IPVersion:    generictables.IPFamily6,
+				NFTables:     true,
+				LogPrefix:    "{policy}/{rule}",
+				LogLevel:     6,
+				LogRateLimit: "1/second",
+			},
+			origin:         ruleOrigin{policyName: "allow-ssh", direction: directionIn, index: 0},
+			expectedMatch:  "meta l4proto tcp limit rate 1/second",
+			expectedAction: `log prefix "allow-ssh/0 " level info`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(tt.conf, ipset.NewNameConvention())
+			rules := r.ruleToTablesRules(&dto.ParsedRule{Action: "log", Protocol: "tcp"}, tt.conf.IPVersion, tt.origin)
+			if assert.Len(t, rules, 1) {
+				assert.Equal(t, tt.expectedMatch, rules[0].Match.Render())
+				assert.Equal(t, tt.expectedAction, rules[0].Action.ToParameter())
+			}
+			_, truncated := r.logPrefixWarned[tt.origin.policyName]
+			assert.Equal(t, tt.expectedTruncated, truncated)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package rulerenderer
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+type testPacket struct {
+	src     string
+	dst     string
+	srcPort int
+	dstPort int
+}
+
+// matchesPacket evaluates the iptables matches rendered by the renderer against packet
+func matchesPacket(t *testing.T, match string, sets map[string]map[string]struct{}, packet testPacket) bool {
+	inNet := func(ip, cidr string) bool {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return ip == cidr
+		}
+		return ipnet.Contains(net.ParseIP(ip))
+	}
+	inPorts := func(port int, ports string) bool {
+		for _, p := range strings.Split(ports, ",") {
+			from, to, isRange := strings.Cut(p, ":")
+			if !isRange {
+				to = from
+			}
+			fromPort, _ := strconv.Atoi(from)
+			toPort, _ := strconv.Atoi(to)
+			if port >= fromPort && port <= toPort {
+				return true
+			}
+		}
+		return false
+	}
+	inSet := func(ip, name string) bool {
+		for member := range sets[name] {
+			if inNet(ip, member) {
+				return true
+			}
+		}
+		return false
+	}
+
+	tokens := strings.Fields(match)
+	negative := false
+	for i := 0; i < len(tokens); i++ {
+		var matched bool
+		switch tokens[i] {
+		case "!":
+			negative = true
+			continue
+		case "-m", "-p":
+			i++
+			continue
+		case "--source":
+			i++
+			matched = inNet(packet.src, tokens[i])
+		case "--destination":
+			i++
+			matched = inNet(packet.dst, tokens[i])
+		case "--source-ports":
+			i++
+			matched = inPorts(packet.srcPort, tokens[i])
+		case "--destination-ports":
+			i++
+			matched = inPorts(packet.dstPort, tokens[i])
+		case "--match-set":
+			i += 2
+			if tokens[i] == "src" {
+				matched = inSet(packet.src, tokens[i-1])
+			} else {
+				matched = inSet(packet.dst, tokens[i-1])
+			}
+		default:
+			t.Fatalf("unsupported match %q in %q", tokens[i], match)
+		}
+		if matched == negative {
+			return false
+		}
+		negative = false
+	}
+	return true
+}
+
+func TestNegationSemantics(t *testing.T) {
+	manyPorts := ports(20)
+	tests := []struct {
+		name    string
+		rule    *dto.ParsedRule
+		packets map[testPacket]bool
+	}{
+		{
+			name: "nets",
+			rule: &dto.ParsedRule{SrcNets: []string{"10.0.0.0/24", "10.1.0.0/24"}},
+			packets: map[testPacket]bool{
+				{src: "10.0.0.1"}: true,
+				{src: "10.1.0.1"}: true,
+				{src: "10.2.0.1"}: false,
+			},
+		},
+		{
+			name: "negated nets",
+			rule: &dto.ParsedRule{SrcNets: []string{"10.0.0.0/24", "10.1.0.0/24"}, IsSrcNetNegative: true},
+			packets: map[testPacket]bool{
+				{src: "10.0.0.1"}: false,
+				{src: "10.1.0.1"}: false,
+				{src: "10.2.0.1"}: true,
+			},
+		},
+		{
+			name: "negated destination nets and ports",
+			rule: &dto.ParsedRule{
+				Protocol:          "tcp",
+				DstNets:           []string{"10.0.0.0/24", "10.1.0.0/24"},
+				IsDstNetNegative:  true,
+				DstPorts:          []string{"22", "80"},
+				IsDstPortNegative: true,
+			},
+			packets: map[testPacket]bool{
+				{dst: "10.0.0.1", dstPort: 443}: false,
+				{dst: "10.2.0.1", dstPort: 22}:  false,
+				{dst: "10.2.0.1", dstPort: 80}:  false,
+				{dst: "10.2.0.1", dstPort: 443}: true,
+			},
+		},
+		{
+			name: "negated ports split in groups",
+			rule: &dto.ParsedRule{Protocol: "tcp", DstPorts: manyPorts, IsDstPortNegative: true},
+			packets: map[testPacket]bool{
+				{dstPort: 8000}: false,
+				{dstPort: 8019}: false,
+				{dstPort: 443}:  true,
+			},
+		},
+		{
+			name: "ports split in groups",
+			rule: &dto.ParsedRule{Protocol: "tcp", DstPorts: manyPorts},
+			packets: map[testPacket]bool{
+				{dstPort: 8000}: true,
+				{dstPort: 8019}: true,
+				{dstPort: 443}:  false,
+			},
+		},
+		{
+			name: "negated source port ranges",
+			rule: &dto.ParsedRule{Protocol: "tcp", SrcPorts: []string{"1000:2000", "3000"}, IsSrcPortNegative: true},
+			packets: map[testPacket]bool{
+				{srcPort: 1500}: false,
+				{srcPort: 3000}: false,
+				{srcPort: 2500}: true,
+			},
+		},
+		{
+			name: "negated sets",
+			rule: &dto.ParsedRule{SrcGNSUUIDs: []string{"gns-a", "gns-b"}, IsSrcSetNegative: true},
+			packets: map[testPacket]bool{
+				{src: "192.168.0.1"}: false,
+				{src: "192.168.1.1"}: false,
+				{src: "192.168.2.1"}: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameConvention := ipset.NewNameConvention()
+			sets := map[string]map[string]struct{}{
+				nameConvention.SetMainNameOfSet("gns-a", generictables.IPFamily4, "gns", "a"): {"192.168.0.0/24": {}},
+				nameConvention.SetMainNameOfSet("gns-b", generictables.IPFamily4, "gns", "b"): {"192.168.1.0/24": {}},
+			}
+			r := NewRenderer(Config{IPVersion: generictables.IPFamily4}, nameConvention)
+			tt.rule.Action = "allow"
+			for name, members := range r.HostEndpointPoliciesToNetSets([]*dto.HostEndpointPolicy{{
+				ParsedGNPs: []*dto.ParsedGNP{{Name: "p", InboundRules: []*dto.ParsedRule{tt.rule}}},
+			}}, generictables.IPFamily4) {
+				sets[name] = members
+			}
+
+			rules := r.ruleToTablesRules(tt.rule, generictables.IPFamily4, ruleOrigin{})
+			for packet, expected := range tt.packets {
+				matched := slices.ContainsFunc(rules, func(rule generictables.Rule) bool {
+					return matchesPacket(t, rule.Match.Render(), sets, packet)
+				})
+				assert.Equal(t, expected, matched, "packet %+v, rules %v", packet, rules)
+			}
+		})
+	}
+}
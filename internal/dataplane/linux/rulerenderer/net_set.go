@@ -0,0 +1,177 @@
+package rulerenderer
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/net"
+)
+
+// netSet is an agent managed set replacing the literal nets of a rule
+type netSet struct {
+	name    string
+	members []string
+}
+
+// ruleNetSets sets of a rule, nil when the literal nets are rendered in rules
+type ruleNetSets struct {
+	src *netSet
+	dst *netSet
+	// dstNetPort replaces both the destination nets and ports of rule
+	dstNetPort *netSet
+}
+
+// HostEndpointPoliciesToNetSets returns the agent managed sets referenced by the rules rendered from
+// hostEndpointPolicies, indexed by set name
+func (r *DefaultRuleRenderer) HostEndpointPoliciesToNetSets(hostEndpointPolicies []*dto.HostEndpointPolicy,
+	ipVersion int) map[string]map[string]struct{} {
+	sets := make(map[string]map[string]struct{})
+	addSet := func(set *netSet) {
+		if set == nil {
+			return
+		}
+		members := make(map[string]struct{}, len(set.members))
+		for _, member := range set.members {
+			members[member] = struct{}{}
+		}
+		sets[set.name] = members
+	}
+	for _, hostEndpointPolicy := range hostEndpointPolicies {
+		for _, policy := range hostEndpointPolicy.ParsedGNPs {
+			var rules []*dto.ParsedRule
+			appliesToInbound, appliesToOutbound, appliesToForward := policyDirections(policy)
+			if appliesToInbound {
+				rules = append(rules, policy.InboundRules...)
+			}
+			if appliesToOutbound {
+				rules = append(rules, policy.OutboundRules...)
+			}
+			if appliesToForward && r.forwardEnforcement {
+				rules = append(rules, policy.ForwardInboundRules...)
+				rules = append(rules, policy.ForwardOutboundRules...)
+			}
+			for _, rule := range rules {
+				if rule.IPVersion != nil && *rule.IPVersion != ipVersion {
+					continue
+				}
+				ruleSets := r.netSetsOfRule(rule, ipVersion)
+				addSet(ruleSets.src)
+				addSet(ruleSets.dst)
+				addSet(ruleSets.dstNetPort)
+			}
+		}
+	}
+	return sets
+}
+
+// netSetsOfRule decides which literal nets of rule are rendered as sets. Nets above the threshold and
+// negated lists of nets are matched by a single set instead of one rule per net. When the destination ports of rule need many
+// multiport matches, destination nets and ports are merged into a hash:net,port set.
+func (r *DefaultRuleRenderer) netSetsOfRule(rule *dto.ParsedRule, ipVersion int) ruleNetSets {
+	var sets ruleNetSets
+	if r.needNetSet(rule.SrcNets, rule.IsSrcNetNegative) {
+		if members := netsOfFamily(rule.SrcNets, ipVersion); len(members) > 0 {
+			sets.src = &netSet{name: ipset.NetSetName(ipVersion, members), members: members}
+		}
+	}
+	if r.needNetSet(rule.DstNets, rule.IsDstNetNegative) {
+		members := netsOfFamily(rule.DstNets, ipVersion)
+		if len(members) == 0 {
+			return sets
+		}
+		if protocol, ok := netPortProtocol(rule); ok {
+			var netPortMembers []string
+			for _, member := range removeCoveredNets(members) {
+				for _, port := range rule.DstPorts {
+					netPortMembers = append(netPortMembers, ipset.NetPortMember(member, protocol, port))
+				}
+			}
+			sets.dstNetPort = &netSet{name: ipset.NetPortSetName(ipVersion, netPortMembers), members: netPortMembers}
+		} else {
+			sets.dst = &netSet{name: ipset.NetSetName(ipVersion, members), members: members}
+		}
+	}
+	return sets
+}
+
+// needNetSet returns whether nets are rendered as a set. A packet must not be in any net of a negated
+// list, which a single rule can only express with a negated set match.
+func (r *DefaultRuleRenderer) needNetSet(nets []string, negative bool) bool {
+	if negative && len(nets) > 1 {
+		return true
+	}
+	return r.netSetThreshold > 0 && len(nets) > r.netSetThreshold
+}
+
+// netPortProtocol returns the protocol of hash:net,port members, false when destination nets and ports
+// of rule can not be merged or merging does not save rules
+func netPortProtocol(rule *dto.ParsedRule) (string, bool) {
+	if rule.IsDstNetNegative || rule.IsDstPortNegative || rule.IsProtocolNegative || len(splitPorts(rule.DstPorts)) < 2 {
+		return "", false
+	}
+	protocol, ok := rule.Protocol.(string)
+	if !ok {
+		return "", false
+	}
+	protocol = strings.ToLower(protocol)
+	if !slices.Contains([]string{dto.ProtocolTCP, dto.ProtocolUDP, dto.ProtocolSCTP, dto.ProtocolUDPLite}, protocol) {
+		return "", false
+	}
+	// ipset expands port ranges to single members, they would never match the members from datastore
+	for _, port := range rule.DstPorts {
+		if strings.Contains(port, ":") {
+			return "", false
+		}
+	}
+	return protocol, true
+}
+
+// netsOfFamily returns the normalized nets of ipVersion, sorted and without duplicates
+func netsOfFamily(nets []string, ipVersion int) []string {
+	var members []string
+	for _, n := range nets {
+		_, ipnet, err := net.ParseCIDROrIP(n)
+		if err != nil || ipnet.Version() != ipVersion {
+			continue
+		}
+		members = append(members, ipnet.String())
+	}
+	slices.Sort(members)
+	return slices.Compact(members)
+}
+
+// isNetOfFamily returns whether n is a valid net or ip of ipVersion
+func isNetOfFamily(n string, ipVersion int) bool {
+	_, ipnet, err := net.ParseCIDROrIP(n)
+	return err == nil && ipnet.Version() == ipVersion
+}
+
+// removeCoveredNets removes the nets contained in another net, members of interval sets with
+// concatenations can not overlap
+func removeCoveredNets(nets []string) []string {
+	var parsed []*net.IPNet
+	for _, n := range nets {
+		if _, ipnet, err := net.ParseCIDROrIP(n); err == nil {
+			parsed = append(parsed, ipnet)
+		}
+	}
+	slices.SortStableFunc(parsed, func(a, b *net.IPNet) int {
+		aOnes, _ := a.Mask.Size()
+		bOnes, _ := b.Mask.Size()
+		return aOnes - bOnes
+	})
+	var kept []*net.IPNet
+	for _, ipnet := range parsed {
+		if !slices.ContainsFunc(kept, func(k *net.IPNet) bool { return k.Contains(ipnet.IP) }) {
+			kept = append(kept, ipnet)
+		}
+	}
+	result := make([]string, 0, len(kept))
+	for _, ipnet := range kept {
+		result = append(result, ipnet.String())
+	}
+	slices.Sort(result)
+	return result
+}
@@ -0,0 +1,131 @@
+package rulerenderer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func nets(prefix string, count int) []string {
+	var result []string
+	for i := 0; i < count; i++ {
+		result = append(result, fmt.Sprintf("%s.%d.0/24", prefix, i))
+	}
+	return result
+}
+
+func ports(count int) []string {
+	var result []string
+	for i := 0; i < count; i++ {
+		result = append(result, fmt.Sprint(8000+i))
+	}
+	return result
+}
+
+func TestNetSets(t *testing.T) {
+	tests := []struct {
+		name          string
+		rule          *dto.ParsedRule
+		expectedRules int
+		expectedSets  []string
+		// expectedRender rendered rules, not checked when nil
+		expectedRender []string
+	}{
+		{
+			name:          "below threshold",
+			rule:          &dto.ParsedRule{Action: "allow", SrcNets: nets("10.0", 4), DstNets: nets("10.1", 4)},
+			expectedRules: 16,
+		},
+		{
+			name:          "large nets on both sides",
+			rule:          &dto.ParsedRule{Action: "allow", SrcNets: nets("10.0", 200), DstNets: nets("10.1", 200)},
+			expectedRules: 1,
+			expectedSets: []string{
+				ipset.NetSetName(generictables.IPFamily4, nets("10.0", 200)),
+				ipset.NetSetName(generictables.IPFamily4, nets("10.1", 200)),
+			},
+		},
+		{
+			name: "large destination nets and many ports",
+			rule: &dto.ParsedRule{
+				Action:   "allow",
+				Protocol: "tcp",
+				SrcNets:  nets("10.0", 2),
+				DstNets:  nets("10.1", 20),
+				DstPorts: ports(20),
+			},
+			expectedRules: 2,
+			expectedSets:  []string{"netport"},
+		},
+		{
+			name: "port ranges are not merged",
+			rule: &dto.ParsedRule{
+				Action:   "allow",
+				Protocol: "tcp",
+				DstNets:  nets("10.1", 20),
+				DstPorts: append(ports(20), "9000:9100"),
+			},
+			expectedRules: 2,
+			expectedSets:  []string{ipset.NetSetName(generictables.IPFamily4, nets("10.1", 20))},
+		},
+		{
+			name: "negated nets of the other family",
+			rule: &dto.ParsedRule{
+				Action:           "allow",
+				SrcNets:          []string{"2001:db8::/32", "2001:db9::/32"},
+				IsSrcNetNegative: true,
+				DstNets:          []string{"2001:dba::/32"},
+				IsDstNetNegative: true,
+			},
+			expectedRules:  1,
+			expectedRender: []string{" -j ACCEPT"},
+		},
+		{
+			name: "negated nets of both families",
+			rule: &dto.ParsedRule{
+				Action:           "allow",
+				SrcNets:          []string{"10.0.0.0/8", "2001:db8::/32"},
+				IsSrcNetNegative: true,
+			},
+			expectedRules:  1,
+			expectedSets:   []string{ipset.NetSetName(generictables.IPFamily4, []string{"10.0.0.0/8"})},
+			expectedRender: []string{"-m set ! --match-set " + ipset.NetSetName(generictables.IPFamily4, []string{"10.0.0.0/8"}) + " src -j ACCEPT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: generictables.IPFamily4, NetSetThreshold: 8}, ipset.NewNameConvention())
+			rules := r.ruleToTablesRules(tt.rule, generictables.IPFamily4, ruleOrigin{})
+			assert.Len(t, rules, tt.expectedRules)
+			if tt.expectedRender != nil {
+				var rendered []string
+				for _, rule := range rules {
+					rendered = append(rendered, rule.Match.Render()+" "+rule.Action.ToParameter())
+				}
+				assert.Equal(t, tt.expectedRender, rendered)
+			}
+
+			sets := r.HostEndpointPoliciesToNetSets([]*dto.HostEndpointPolicy{{
+				ParsedGNPs: []*dto.ParsedGNP{{Name: "p", InboundRules: []*dto.ParsedRule{tt.rule}}},
+			}}, generictables.IPFamily4)
+			assert.Len(t, sets, len(tt.expectedSets))
+			for _, name := range tt.expectedSets {
+				if name == "netport" {
+					for setName, members := range sets {
+						assert.Contains(t, setName, "netportv4")
+						assert.Len(t, members, 20*20)
+						assert.Contains(t, members, "10.1.0.0/24,tcp:8000")
+						assert.Contains(t, rules[0].Match.Render(), "--match-set "+setName+" dst,dst")
+					}
+					continue
+				}
+				assert.Contains(t, sets, name)
+			}
+		})
+	}
+}
@@ -1,69 +1,165 @@
 package rulerenderer
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/internal/flowlog"
 	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 	"github.com/bamboo-firewall/agent/pkg/iptables"
 )
 
-func (r *DefaultRuleRenderer) PoliciesToIptablesChains(policies []*dto.ParsedGNP, ipVersion int, apiServerIPV4 string) []*generictables.Chain {
+const (
+	// directionIn and directionOut tag the log of inbound and outbound rules
+	directionIn  = "in"
+	directionOut = "out"
+	// directionForwardIn and directionForwardOut tag the log of forward inbound and forward outbound rules
+	directionForwardIn  = "fwd-in"
+	directionForwardOut = "fwd-out"
+)
+
+// policyChains names of the chains rendered from a policy, empty when the policy has no rule for that direction
+type policyChains struct {
+	inbound         string
+	outbound        string
+	forwardInbound  string
+	forwardOutbound string
+}
+
+// ruleOrigin locates a rule in policies, it tags the log of rule
+type ruleOrigin struct {
+	policyName string
+	direction  string
+	index      int
+}
+
+// hostEndpointJumps jumps to the policies of host endpoints bound to the same interface
+type hostEndpointJumps struct {
+	names           []string
+	inbound         []generictables.Rule
+	outbound        []generictables.Rule
+	forwardInbound  []generictables.Rule
+	forwardOutbound []generictables.Rule
+}
+
+func (r *DefaultRuleRenderer) HostEndpointPoliciesToChains(hostEndpointPolicies []*dto.HostEndpointPolicy, ipVersion int) []*generictables.Chain {
 	// For each policy
 	// our default table(contains default rules and jump to each policy)
 	var chains []*generictables.Chain
-	rulesJumpToOurInputChain := make([]generictables.Rule, 0)
-	rulesJumpToOurOutputChain := make([]generictables.Rule, 0)
-	for i, policy := range policies {
-		if len(policy.InboundRules) > 0 {
-			rules := r.rulesToTablesRules(policy.InboundRules, ipVersion)
-			if len(rules) > 0 {
-				chainName := iptables.GetMaxCustomChainName(fmt.Sprintf("%s%d-%s", generictables.OurInputChainPrefix, i, policy.Name))
-				inbound := generictables.Chain{
-					Name:  chainName,
-					Rules: rules,
-				}
-				chains = append(chains, &inbound)
-				rulesJumpToOurInputChain = append(rulesJumpToOurInputChain, generictables.Rule{
-					Match:   r.NewMatch(),
-					Action:  r.Jump(chainName),
-					Comment: nil,
-				})
+	// a policy selected by many host endpoints is rendered once
+	renderedPolicies := make(map[string]policyChains)
+	// host endpoints without interface apply to all interfaces
+	allInterfaces := new(hostEndpointJumps)
+	interfaceToJumps := make(map[string]*hostEndpointJumps)
+	var interfaces []string
+	for _, hostEndpointPolicy := range hostEndpointPolicies {
+		if hostEndpointPolicy.HEP == nil {
+			continue
+		}
+		jumps := allInterfaces
+		if interfaceName := hostEndpointPolicy.HEP.Spec.InterfaceName; interfaceName != "" && interfaceName != "*" {
+			if _, ok := interfaceToJumps[interfaceName]; !ok {
+				interfaceToJumps[interfaceName] = new(hostEndpointJumps)
+				interfaces = append(interfaces, interfaceName)
 			}
+			jumps = interfaceToJumps[interfaceName]
 		}
+		jumps.names = append(jumps.names, hostEndpointPolicy.HEP.Metadata.Name)
 
-		if len(policy.OutboundRules) > 0 {
-			rules := r.rulesToTablesRules(policy.OutboundRules, ipVersion)
-			if len(rules) > 0 {
-				chainName := iptables.GetMaxCustomChainName(fmt.Sprintf("%s%d-%s", generictables.OurOutputChainPrefix, i, policy.Name))
-				outbound := generictables.Chain{
-					Name:  chainName,
-					Rules: rules,
-				}
-				chains = append(chains, &outbound)
-				rulesJumpToOurOutputChain = append(rulesJumpToOurOutputChain, generictables.Rule{
-					Match:   r.NewMatch(),
-					Action:  r.Jump(chainName),
-					Comment: nil,
-				})
+		for _, policy := range sortPolicies(hostEndpointPolicy.ParsedGNPs) {
+			rendered, ok := renderedPolicies[policy.UUID]
+			if !ok {
+				var policyChainsOfPolicy []*generictables.Chain
+				rendered, policyChainsOfPolicy = r.policyToChains(policy, ipVersion)
+				renderedPolicies[policy.UUID] = rendered
+				chains = append(chains, policyChainsOfPolicy...)
 			}
+			jumps.inbound = append(jumps.inbound, r.jumpRules(rendered.inbound)...)
+			jumps.outbound = append(jumps.outbound, r.jumpRules(rendered.outbound)...)
+			jumps.forwardInbound = append(jumps.forwardInbound, r.forwardInboundJumpRules(rendered.forwardInbound)...)
+			jumps.forwardOutbound = append(jumps.forwardOutbound, r.jumpRules(rendered.forwardOutbound)...)
+		}
+	}
+
+	// each interface has its own chains, dispatched from our default chains by interface. The policies of host
+	// endpoints of the interface run first, then the policies of host endpoints of all interfaces, so both apply
+	// before the default action
+	rulesJumpToOurInputChain := make([]generictables.Rule, 0)
+	rulesJumpToOurOutputChain := make([]generictables.Rule, 0)
+	rulesJumpToOurForwardInputChain := make([]generictables.Rule, 0)
+	rulesJumpToOurForwardOutputChain := make([]generictables.Rule, 0)
+	for _, interfaceName := range interfaces {
+		jumps := interfaceToJumps[interfaceName]
+		comment := []string{fmt.Sprintf("Host endpoint %s", strings.Join(jumps.names, ","))}
+		inputChainName := generictables.OurHostEndpointInputChainPrefix + interfaceName
+		outputChainName := generictables.OurHostEndpointOutputChainPrefix + interfaceName
+		chains = append(chains,
+			&generictables.Chain{
+				Name:  inputChainName,
+				Rules: slices.Concat(jumps.inbound, allInterfaces.inbound, r.defaultActionRules(directionIn)),
+			},
+			&generictables.Chain{
+				Name:  outputChainName,
+				Rules: slices.Concat(jumps.outbound, allInterfaces.outbound, r.defaultActionRules(directionOut)),
+			},
+		)
+		rulesJumpToOurInputChain = append(rulesJumpToOurInputChain, generictables.Rule{
+			Match:   r.NewMatch().InInterface(interfaceName),
+			Action:  r.Jump(inputChainName),
+			Comment: comment,
+		})
+		rulesJumpToOurOutputChain = append(rulesJumpToOurOutputChain, generictables.Rule{
+			Match:   r.NewMatch().OutInterface(interfaceName),
+			Action:  r.Jump(outputChainName),
+			Comment: comment,
+		})
+
+		// forwarded packets of interfaces without forward policies are left to the other rules of FORWARD
+		if len(jumps.forwardInbound) > 0 {
+			forwardInputChainName := generictables.OurHostEndpointForwardInputChainPrefix + interfaceName
+			chains = append(chains, &generictables.Chain{
+				Name: forwardInputChainName,
+				Rules: slices.Concat(jumps.forwardInbound, allInterfaces.forwardInbound, r.returnForwardInboundAllowedRules(),
+					r.defaultActionRules(directionForwardIn)),
+			})
+			rulesJumpToOurForwardInputChain = append(rulesJumpToOurForwardInputChain, generictables.Rule{
+				Match:   r.NewMatch().InInterface(interfaceName),
+				Action:  r.Jump(forwardInputChainName),
+				Comment: comment,
+			})
+		}
+		if len(jumps.forwardOutbound) > 0 {
+			forwardOutputChainName := generictables.OurHostEndpointForwardOutputChainPrefix + interfaceName
+			chains = append(chains, &generictables.Chain{
+				Name:  forwardOutputChainName,
+				Rules: slices.Concat(jumps.forwardOutbound, allInterfaces.forwardOutbound, r.defaultActionRules(directionForwardOut)),
+			})
+			rulesJumpToOurForwardOutputChain = append(rulesJumpToOurForwardOutputChain, generictables.Rule{
+				Match:   r.NewMatch().OutInterface(interfaceName),
+				Action:  r.Jump(forwardOutputChainName),
+				Comment: comment,
+			})
 		}
 	}
+	rulesJumpToOurInputChain = append(rulesJumpToOurInputChain, allInterfaces.inbound...)
+	rulesJumpToOurOutputChain = append(rulesJumpToOurOutputChain, allInterfaces.outbound...)
+
 	ourDefaultInputRules := make([]generictables.Rule, 0)
 	ourDefaultInputRules = append(ourDefaultInputRules, generictables.Rule{
 		Match:   r.NewMatch().ConntrackState("ESTABLISHED,RELATED"),
 		Action:  r.Allow(),
 		Comment: nil,
 	})
+	ourDefaultInputRules = append(ourDefaultInputRules, r.icmpv6EssentialRules(ipVersion)...)
+	ourDefaultInputRules = append(ourDefaultInputRules, r.failsafeRules(r.failsafeInboundHostPorts, "inbound")...)
 	ourDefaultInputRules = append(ourDefaultInputRules, rulesJumpToOurInputChain...)
-	ourDefaultInputRules = append(ourDefaultInputRules, generictables.Rule{
-		Match:   r.NewMatch(),
-		Action:  r.Drop(),
-		Comment: nil,
-	})
+	ourDefaultInputRules = append(ourDefaultInputRules, r.defaultActionRules(directionIn)...)
 	ourDefaultOutputRules := make([]generictables.Rule, 0)
 	ourDefaultOutputRules = append(ourDefaultOutputRules,
 		generictables.Rule{
@@ -72,20 +168,19 @@ func (r *DefaultRuleRenderer) PoliciesToIptablesChains(policies []*dto.ParsedGNP
 			Comment: nil,
 		},
 	)
-	if ipVersion == generictables.IPFamily4 {
+	ourDefaultOutputRules = append(ourDefaultOutputRules, r.icmpv6EssentialRules(ipVersion)...)
+	// add rule allow to api-server
+	apiServerPorts := []string{strconv.Itoa(int(r.apiServerPort))}
+	for _, apiServerIP := range r.apiServerIPs {
 		ourDefaultOutputRules = append(ourDefaultOutputRules, generictables.Rule{
-			Match:   r.NewMatch().Protocol("tcp").ConntrackState("NEW").DestNet(apiServerIPV4),
+			Match:   r.NewMatch().Protocol("tcp").ConntrackState("NEW").DestNet(apiServerIP).DestPorts(apiServerPorts),
 			Action:  r.Allow(),
 			Comment: nil,
 		})
 	}
-	// add rule allow to api-server
+	ourDefaultOutputRules = append(ourDefaultOutputRules, r.failsafeRules(r.failsafeOutboundHostPorts, "outbound")...)
 	ourDefaultOutputRules = append(ourDefaultOutputRules, rulesJumpToOurOutputChain...)
-	ourDefaultOutputRules = append(ourDefaultOutputRules, generictables.Rule{
-		Match:   r.NewMatch(),
-		Action:  r.Drop(),
-		Comment: nil,
-	})
+	ourDefaultOutputRules = append(ourDefaultOutputRules, r.defaultActionRules(directionOut)...)
 	chains = append(
 		chains,
 		&generictables.Chain{
@@ -97,13 +192,236 @@ func (r *DefaultRuleRenderer) PoliciesToIptablesChains(policies []*dto.ParsedGNP
 			Rules: ourDefaultOutputRules,
 		},
 	)
+	if r.forwardEnforcement {
+		chains = append(chains, &generictables.Chain{
+			Name:  generictables.OurDefaultForwardChain,
+			Rules: r.ourDefaultForwardRules(rulesJumpToOurForwardInputChain, rulesJumpToOurForwardOutputChain, allInterfaces),
+		})
+	}
 	return chains
 }
 
-func (r *DefaultRuleRenderer) rulesToTablesRules(rules []*dto.ParsedRule, ipVersion int, chainComments ...string) []generictables.Rule {
+// ourDefaultForwardRules dispatch forwarded packets to the forward inbound policies, then to the forward
+// outbound policies. A forward inbound policy allowing a packet marks it and returns, so a routed packet must
+// pass the policies of both directions. Each direction only denies by default when a forward policy of that
+// direction applies to the interface of the packet, packets allowed by forward inbound policies without forward
+// outbound policies are accepted and other packets are left to the other rules of FORWARD.
+func (r *DefaultRuleRenderer) ourDefaultForwardRules(rulesJumpToOurForwardInputChain,
+	rulesJumpToOurForwardOutputChain []generictables.Rule, allInterfaces *hostEndpointJumps) []generictables.Rule {
+	if len(rulesJumpToOurForwardInputChain) == 0 && len(rulesJumpToOurForwardOutputChain) == 0 &&
+		len(allInterfaces.forwardInbound) == 0 && len(allInterfaces.forwardOutbound) == 0 {
+		return []generictables.Rule{}
+	}
+	rules := []generictables.Rule{
+		{
+			Match:   r.NewMatch().ConntrackState("ESTABLISHED,RELATED"),
+			Action:  r.Allow(),
+			Comment: nil,
+		},
+		{
+			// clear the forward mark bit left by another program, the other bits of mark are kept
+			Match:   r.NewMatch().MarkMatchesWithMask(r.forwardMark, r.forwardMark),
+			Action:  r.SetMaskedMark(0, r.forwardMark),
+			Comment: nil,
+		},
+	}
+	rules = append(rules, rulesJumpToOurForwardInputChain...)
+	if len(allInterfaces.forwardInbound) > 0 {
+		rules = append(rules, allInterfaces.forwardInbound...)
+		rules = append(rules, r.matchDefaultActionRules(
+			r.NewMatch().NotMarkMatchesWithMask(r.forwardMark, r.forwardMark), directionForwardIn)...)
+	}
+	rules = append(rules, rulesJumpToOurForwardOutputChain...)
+	if len(allInterfaces.forwardOutbound) > 0 {
+		rules = append(rules, allInterfaces.forwardOutbound...)
+		rules = append(rules, r.defaultActionRules(directionForwardOut)...)
+	}
+	return append(rules, generictables.Rule{
+		Match:   r.NewMatch().MarkMatchesWithMask(r.forwardMark, r.forwardMark),
+		Action:  r.Allow(),
+		Comment: nil,
+	})
+}
+
+// forwardInboundJumpRules jumps to the forward inbound chain of a policy unless a previous policy allowed the
+// packet, none when the policy did not render that chain
+func (r *DefaultRuleRenderer) forwardInboundJumpRules(chainName string) []generictables.Rule {
+	if chainName == "" {
+		return nil
+	}
+	return []generictables.Rule{
+		{
+			Match:   r.NewMatch().NotMarkMatchesWithMask(r.forwardMark, r.forwardMark),
+			Action:  r.Jump(chainName),
+			Comment: nil,
+		},
+	}
+}
+
+// returnForwardInboundAllowedRules return the packets allowed by forward inbound policies to the forward
+// outbound policies
+func (r *DefaultRuleRenderer) returnForwardInboundAllowedRules() []generictables.Rule {
+	return []generictables.Rule{
+		{
+			Match:   r.NewMatch().MarkMatchesWithMask(r.forwardMark, r.forwardMark),
+			Action:  r.Return(),
+			Comment: nil,
+		},
+	}
+}
+
+// jumpRules jumps to the chain of a policy, none when the policy did not render that chain
+func (r *DefaultRuleRenderer) jumpRules(chainName string) []generictables.Rule {
+	if chainName == "" {
+		return nil
+	}
+	return []generictables.Rule{
+		{
+			Match:   r.NewMatch(),
+			Action:  r.Jump(chainName),
+			Comment: nil,
+		},
+	}
+}
+
+// sortPolicies returns policies in evaluation order: by order then by name, so that the order of jumps
+// does not depend on the order of api-server response
+func sortPolicies(policies []*dto.ParsedGNP) []*dto.ParsedGNP {
+	sorted := slices.Clone(policies)
+	slices.SortStableFunc(sorted, func(a, b *dto.ParsedGNP) int {
+		switch {
+		case a.Order == nil && b.Order != nil:
+			return 1
+		case a.Order != nil && b.Order == nil:
+			return -1
+		case a.Order != nil && b.Order != nil && *a.Order != *b.Order:
+			return cmp.Compare(*a.Order, *b.Order)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return sorted
+}
+
+// policyToChains renders the inbound, outbound and forward chains of policy. Chain names only depend on the
+// policy, so inserting another policy does not rename them.
+func (r *DefaultRuleRenderer) policyToChains(policy *dto.ParsedGNP, ipVersion int) (policyChains, []*generictables.Chain) {
+	var (
+		rendered policyChains
+		chains   []*generictables.Chain
+	)
+	renderChain := func(rules []*dto.ParsedRule, chainPrefix, direction string) string {
+		tablesRules := r.rulesToTablesRules(rules, ipVersion, policy.Name, direction)
+		tablesRules = append(tablesRules, r.endOfChainRules(policy, rules, ipVersion, direction)...)
+		if len(tablesRules) == 0 {
+			return ""
+		}
+		chainName := iptables.GetCustomChainName(chainPrefix+policy.Name, policy.UUID)
+		chains = append(chains, &generictables.Chain{
+			Name:  chainName,
+			Rules: tablesRules,
+		})
+		return chainName
+	}
+
+	appliesToInbound, appliesToOutbound, appliesToForward := policyDirections(policy)
+	if appliesToInbound {
+		rendered.inbound = renderChain(policy.InboundRules, generictables.OurInputChainPrefix, directionIn)
+	}
+	if appliesToOutbound {
+		rendered.outbound = renderChain(policy.OutboundRules, generictables.OurOutputChainPrefix, directionOut)
+	}
+	if appliesToForward && r.forwardEnforcement {
+		rendered.forwardInbound = renderChain(policy.ForwardInboundRules, generictables.OurForwardInputChainPrefix,
+			directionForwardIn)
+		rendered.forwardOutbound = renderChain(policy.ForwardOutboundRules, generictables.OurForwardOutputChainPrefix,
+			directionForwardOut)
+	}
+	return rendered, chains
+}
+
+// policyDirections returns whether policy applies to inbound, outbound and forwarded packets. A typed policy
+// leaves the other directions alone. A policy without types applies to the directions it has rules for, and to
+// inbound and outbound when it sets an end of chain action, forward enforcement being opt-in.
+func policyDirections(policy *dto.ParsedGNP) (inbound bool, outbound bool, forward bool) {
+	if len(policy.Types) == 0 {
+		hasEndOfChainAction := policy.EndOfChainAction != ""
+		return hasEndOfChainAction || len(policy.InboundRules) > 0, hasEndOfChainAction || len(policy.OutboundRules) > 0,
+			len(policy.ForwardInboundRules) > 0 || len(policy.ForwardOutboundRules) > 0
+	}
+	for _, policyType := range policy.Types {
+		switch strings.ToLower(policyType) {
+		case dto.PolicyTypeIngress:
+			inbound = true
+		case dto.PolicyTypeEgress:
+			outbound = true
+		case dto.PolicyTypeForward:
+			forward = true
+		default:
+			slog.Warn("unsupported policy type", "policy", policy.Name, "type", policyType)
+		}
+	}
+	return inbound, outbound, forward
+}
+
+// endOfChainRules decide packets not matched by any rule of policy. A typed policy without rules denies
+// the direction by default, other policies fall through to the next policy unless they set an action.
+func (r *DefaultRuleRenderer) endOfChainRules(policy *dto.ParsedGNP, rules []*dto.ParsedRule, ipVersion int,
+	direction string) []generictables.Rule {
+	action := strings.ToLower(policy.EndOfChainAction)
+	switch action {
+	case "":
+		if len(policy.Types) == 0 || len(rules) > 0 {
+			return nil
+		}
+		action = dto.EndOfChainActionDeny
+	case dto.EndOfChainActionPass, dto.EndOfChainActionDeny:
+	default:
+		slog.Warn("unsupported end of chain action", "policy", policy.Name, "action", policy.EndOfChainAction)
+		return nil
+	}
+	// a denied packet is rejected like the default action of our chains
+	if action == dto.EndOfChainActionDeny && r.rejectByDefault {
+		action = "reject"
+	}
+	origin := ruleOrigin{policyName: policy.Name, direction: direction, index: len(rules)}
+	return r.ruleToTablesRules(&dto.ParsedRule{Action: action}, ipVersion, origin)
+}
+
+// failsafeRules allow the fail-safe ports ahead of policies, so a wrong policy can not block them
+func (r *DefaultRuleRenderer) failsafeRules(protoPorts []config.ProtoPort, direction string) []generictables.Rule {
+	rules := make([]generictables.Rule, 0, len(protoPorts))
+	for _, protoPort := range protoPorts {
+		rules = append(rules, generictables.Rule{
+			Match:   r.NewMatch().Protocol(protoPort.Protocol).DestPorts([]string{strconv.Itoa(int(protoPort.Port))}),
+			Action:  r.Allow(),
+			Comment: []string{fmt.Sprintf("Fail-safe %s %s", direction, protoPort)},
+		})
+	}
+	return rules
+}
+
+// icmpv6EssentialRules allow the icmpv6 types ipv6 can not work without, whatever the policies are
+func (r *DefaultRuleRenderer) icmpv6EssentialRules(ipVersion int) []generictables.Rule {
+	if ipVersion != generictables.IPFamily6 {
+		return nil
+	}
+	rules := make([]generictables.Rule, 0, len(r.icmpv6EssentialTypes))
+	for _, icmpType := range r.icmpv6EssentialTypes {
+		rules = append(rules, generictables.Rule{
+			Match:   r.NewMatch().Protocol(dto.ProtocolICMPv6).ICMPV6Type(icmpType),
+			Action:  r.Allow(),
+			Comment: []string{fmt.Sprintf("ICMPv6 essential type %d", icmpType)},
+		})
+	}
+	return rules
+}
+
+func (r *DefaultRuleRenderer) rulesToTablesRules(rules []*dto.ParsedRule, ipVersion int, policyName, direction string,
+	chainComments ...string) []generictables.Rule {
 	var iptablesRules []generictables.Rule
-	for _, rule := range rules {
-		iptablesRules = append(iptablesRules, r.ruleToTablesRules(rule, ipVersion)...)
+	for i, rule := range rules {
+		origin := ruleOrigin{policyName: policyName, direction: direction, index: i}
+		iptablesRules = append(iptablesRules, r.ruleToTablesRules(rule, ipVersion, origin)...)
 	}
 
 	if len(chainComments) > 0 {
@@ -115,7 +433,7 @@ func (r *DefaultRuleRenderer) rulesToTablesRules(rules []*dto.ParsedRule, ipVers
 	return iptablesRules
 }
 
-func (r *DefaultRuleRenderer) ruleToTablesRules(rule *dto.ParsedRule, ipVersion int) []generictables.Rule {
+func (r *DefaultRuleRenderer) ruleToTablesRules(rule *dto.ParsedRule, ipVersion int, origin ruleOrigin) []generictables.Rule {
 	if rule.IPVersion != nil && *rule.IPVersion != ipVersion {
 		return nil
 	}
@@ -132,157 +450,164 @@ func (r *DefaultRuleRenderer) ruleToTablesRules(rule *dto.ParsedRule, ipVersion
 			slog.Warn("malformed protocol", "protocol", rule.Protocol)
 		}
 	}
-
-	var (
-		srcPorts [][]string
-		dstPorts [][]string
-	)
-	if len(rule.SrcPorts) > 0 {
-		srcPorts = splitPorts(rule.SrcPorts)
-	}
-	if len(rule.DstPorts) > 0 {
-		dstPorts = splitPorts(rule.DstPorts)
-	}
-	var matchPorts []generictables.MatchCriteria
-	if len(srcPorts) > 0 || len(dstPorts) > 0 {
-		if len(srcPorts) > 0 && len(dstPorts) > 0 {
-			for _, srcPort := range srcPorts {
-				matchPort := r.NewMatch()
-				if rule.IsSrcPortNegative {
-					matchPort = matchPort.NotSourcePorts(srcPort)
-				} else {
-					matchPort = matchPort.SourcePorts(srcPort)
-				}
-				for _, dstPort := range dstPorts {
-					matchPort2 := matchPort.Copy()
-					if rule.IsDstPortNegative {
-						matchPort2 = matchPort2.NotDestPorts(dstPort)
-					} else {
-						matchPort2 = matchPort2.DestPorts(dstPort)
-					}
-					matchPorts = append(matchPorts, matchPort2)
-				}
-			}
-		} else if len(dstPorts) > 0 {
-			for _, dstPort := range dstPorts {
-				matchPort := r.NewMatch()
-				if rule.IsDstPortNegative {
-					matchPort = matchPort.NotDestPorts(dstPort)
-				} else {
-					matchPort = matchPort.DestPorts(dstPort)
-				}
-				matchPorts = append(matchPorts, matchPort)
-			}
-		} else {
-			for _, srcPort := range srcPorts {
-				matchPort := r.NewMatch()
-				if rule.IsSrcPortNegative {
-					matchPort = matchPort.NotSourcePorts(srcPort)
-				} else {
-					matchPort = matchPort.SourcePorts(srcPort)
-				}
-				matchPorts = append(matchPorts, matchPort)
-			}
+	if hasICMPMatch(rule) {
+		icmpMatch, err := r.icmpMatch(rule, ipVersion)
+		if err != nil {
+			slog.Warn("malformed icmp match", "err", err)
+			return nil
+		}
+		if icmpMatch == nil {
+			// icmp types of the other family
+			return nil
 		}
+		mainMatch = mainMatch.Merge(icmpMatch)
+	}
+	if strings.EqualFold(rule.Action, "log") && r.logRateLimit != "" {
+		mainMatch = mainMatch.Limit(r.logRateLimit, r.logRateLimitBurst)
 	}
 
-	var (
-		srcNets []string
-		dstNets []string
-	)
-	if len(rule.SrcNets) > 0 {
-		srcNets = rule.SrcNets
-	}
-	if len(rule.DstNets) > 0 {
-		dstNets = rule.DstNets
-	}
-
-	var matchNets []generictables.MatchCriteria
-	if len(srcNets) > 0 || len(dstNets) > 0 {
-		if len(srcNets) > 0 && len(dstNets) > 0 {
-			for _, srcNet := range srcNets {
-				matchNet := r.NewMatch()
-				if rule.IsSrcNetNegative {
-					matchNet = matchNet.NotSourceNet(srcNet)
-				} else {
-					matchNet = matchNet.SourceNet(srcNet)
-				}
-				for _, dstNet := range dstNets {
-					matchNet2 := matchNet.Copy()
-					if rule.IsDstNetNegative {
-						matchNet2 = matchNet2.NotDestNet(dstNet)
-					} else {
-						matchNet2 = matchNet2.DestNet(dstNet)
-					}
-					matchNets = append(matchNets, matchNet2)
-				}
-			}
-		} else if len(srcNets) == 0 {
-			for _, dstNet := range dstNets {
-				matchNet := r.NewMatch()
-				if rule.IsDstNetNegative {
-					matchNet = matchNet.NotDestNet(dstNet)
-				} else {
-					matchNet = matchNet.DestNet(dstNet)
-				}
-				matchNets = append(matchNets, matchNet)
-			}
-		} else {
-			for _, srcNet := range srcNets {
-				matchNet := r.NewMatch()
-				if rule.IsSrcNetNegative {
-					matchNet = matchNet.NotSourceNet(srcNet)
-				} else {
-					matchNet = matchNet.SourceNet(srcNet)
-				}
-				matchNets = append(matchNets, matchNet)
-			}
-		}
+	netSets := r.netSetsOfRule(rule, ipVersion)
+
+	srcPortMatches := r.portMatches(rule.SrcPorts, rule.IsSrcPortNegative, true)
+	var dstPortMatches []generictables.MatchCriteria
+	if netSets.dstNetPort == nil {
+		dstPortMatches = r.portMatches(rule.DstPorts, rule.IsDstPortNegative, false)
 	}
 
-	// use sets for each match
-	var (
-		srcIPSets []string
-		dstIPSets []string
-	)
-	if len(rule.SrcHEPUUIDs) > 0 || len(rule.SrcGNSUUIDs) > 0 {
-		srcIPSets = r.getIPSetsByUUIDs(append(rule.SrcHEPUUIDs, rule.SrcGNSUUIDs...))
-	}
-	if len(rule.DstHEPUUIDs) > 0 || len(rule.DstGNSUUIDs) > 0 {
-		dstIPSets = r.getIPSetsByUUIDs(append(rule.DstHEPUUIDs, rule.DstGNSUUIDs...))
-	}
-	var matchSets []generictables.MatchCriteria
-	if len(srcIPSets) > 0 || len(dstIPSets) > 0 {
-		if len(srcIPSets) > 0 && len(dstIPSets) > 0 {
-			for _, srcIP := range srcIPSets {
-				matchSet := r.NewMatch().SourceIPSet(srcIP)
-				for _, dstIP := range dstIPSets {
-					matchSets = append(matchSets, matchSet.Copy().DestIPSet(dstIP))
-				}
-			}
-		} else if len(dstIPSets) > 0 {
-			for _, dstIP := range dstIPSets {
-				matchSets = append(matchSets, r.NewMatch().DestIPSet(dstIP))
-			}
-		} else {
-			for _, srcIP := range srcIPSets {
-				matchSets = append(matchSets, r.NewMatch().SourceIPSet(srcIP))
-			}
-		}
+	srcNetMatches := r.netMatches(rule.SrcNets, rule.IsSrcNetNegative, netSets.src, true, ipVersion)
+	dstNetMatches := r.netMatches(rule.DstNets, rule.IsDstNetNegative, netSets.dst, false, ipVersion)
+	if netSets.dstNetPort != nil {
+		dstNetMatches = []generictables.MatchCriteria{r.NewMatch().DestNetPortIPSet(netSets.dstNetPort.name)}
 	}
 
-	matches := r.cartesianMatches(matchPorts, matchNets, matchSets)
+	// use sets for each match
+	srcSetMatches := r.setMatches(r.getIPSetsByUUIDs(slices.Concat(rule.SrcHEPUUIDs, rule.SrcGNSUUIDs)),
+		rule.IsSrcSetNegative, true)
+	dstSetMatches := r.setMatches(r.getIPSetsByUUIDs(slices.Concat(rule.DstHEPUUIDs, rule.DstGNSUUIDs)),
+		rule.IsDstSetNegative, false)
+
+	// each list of matches is a disjunction, a rule is rendered for every combination of them
+	matches := r.cartesianMatches(srcPortMatches, dstPortMatches, srcNetMatches, dstNetMatches, srcSetMatches, dstSetMatches)
 	rules := make([]generictables.Rule, 0)
+	verdict, isVerdict := verdictOfAction(rule.Action)
 	for _, match := range matches {
+		if r.flowLogs && isVerdict {
+			rules = append(rules, r.flowLogRule(mainMatch.Merge(match), verdict, origin))
+		}
+		if origin.direction == directionForwardIn && strings.EqualFold(rule.Action, "allow") {
+			// the forward outbound policies decide the packet allowed by forward inbound policies
+			rules = append(rules,
+				generictables.Rule{
+					Match:  mainMatch.Merge(match),
+					Action: r.SetMaskedMark(r.forwardMark, r.forwardMark),
+				},
+				generictables.Rule{
+					Match:  mainMatch.Merge(match),
+					Action: r.Return(),
+				},
+			)
+			continue
+		}
 		rules = append(rules, generictables.Rule{
 			Match:  mainMatch.Merge(match),
-			Action: r.renderRuleAction(rule.Action),
+			Action: r.renderRuleAction(rule, ipVersion, origin),
 		})
 	}
 
 	return rules
 }
 
+// portMatches returns one match per group of ports. A packet must not match any group of negated ports,
+// so they are all chained in a single match.
+func (r *DefaultRuleRenderer) portMatches(ports []string, negative bool, source bool) []generictables.MatchCriteria {
+	if len(ports) == 0 {
+		return nil
+	}
+	var matches []generictables.MatchCriteria
+	negatedMatch := r.NewMatch()
+	for _, group := range splitPorts(ports) {
+		switch {
+		case source && negative:
+			negatedMatch = negatedMatch.NotSourcePorts(group)
+		case negative:
+			negatedMatch = negatedMatch.NotDestPorts(group)
+		case source:
+			matches = append(matches, r.NewMatch().SourcePorts(group))
+		default:
+			matches = append(matches, r.NewMatch().DestPorts(group))
+		}
+	}
+	if negative {
+		return []generictables.MatchCriteria{negatedMatch}
+	}
+	return matches
+}
+
+// setMatches returns one match per set, or a single match chaining the negated sets
+func (r *DefaultRuleRenderer) setMatches(sets []string, negative bool, source bool) []generictables.MatchCriteria {
+	if len(sets) == 0 {
+		return nil
+	}
+	var matches []generictables.MatchCriteria
+	negatedMatch := r.NewMatch()
+	for _, set := range sets {
+		switch {
+		case source && negative:
+			negatedMatch = negatedMatch.NotSourceIPSet(set)
+		case negative:
+			negatedMatch = negatedMatch.NotDestIPSet(set)
+		case source:
+			matches = append(matches, r.NewMatch().SourceIPSet(set))
+		default:
+			matches = append(matches, r.NewMatch().DestIPSet(set))
+		}
+	}
+	if negative {
+		return []generictables.MatchCriteria{negatedMatch}
+	}
+	return matches
+}
+
+// netMatches returns one match per literal net, or a single match of set when nets are rendered as a set.
+// Negated lists of several nets are always rendered as a set, see netSetsOfRule. Negated nets of the other
+// family never match packets of ipVersion, when all of them are of the other family no match is returned.
+func (r *DefaultRuleRenderer) netMatches(nets []string, negative bool, set *netSet, source bool,
+	ipVersion int) []generictables.MatchCriteria {
+	if set != nil {
+		match := r.NewMatch()
+		switch {
+		case source && negative:
+			match = match.NotSourceIPSet(set.name)
+		case source:
+			match = match.SourceIPSet(set.name)
+		case negative:
+			match = match.NotDestIPSet(set.name)
+		default:
+			match = match.DestIPSet(set.name)
+		}
+		return []generictables.MatchCriteria{match}
+	}
+	if negative {
+		nets = slices.DeleteFunc(slices.Clone(nets), func(n string) bool { return !isNetOfFamily(n, ipVersion) })
+	}
+	var matches []generictables.MatchCriteria
+	for _, n := range nets {
+		match := r.NewMatch()
+		switch {
+		case source && negative:
+			match = match.NotSourceNet(n)
+		case source:
+			match = match.SourceNet(n)
+		case negative:
+			match = match.NotDestNet(n)
+		default:
+			match = match.DestNet(n)
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
 func (r *DefaultRuleRenderer) getIPSetsByUUIDs(uuids []string) []string {
 	var ipSets []string
 	for _, srcUUID := range uuids {
@@ -366,14 +691,18 @@ func splitPorts(ports []string) [][]string {
 	return splits
 }
 
-func (r *DefaultRuleRenderer) renderRuleAction(action string) generictables.Action {
-	switch strings.ToLower(action) {
+func (r *DefaultRuleRenderer) renderRuleAction(rule *dto.ParsedRule, ipVersion int, origin ruleOrigin) generictables.Action {
+	switch strings.ToLower(rule.Action) {
 	case "allow":
 		return r.Allow()
 	case "deny":
 		return r.Drop()
+	case "reject":
+		return r.rejectAction(rule, ipVersion)
 	case "log":
-		return r.Log(r.logPrefix)
+		return r.Log(r.renderLogPrefix(origin), r.logLevel)
+	case "nflog":
+		return r.NFLog(r.nflogGroup, flowLogPrefix(flowlog.VerdictLog, origin))
 	case "pass":
 		return r.Return()
 	default:
@@ -381,10 +710,29 @@ func (r *DefaultRuleRenderer) renderRuleAction(action string) generictables.Acti
 	}
 }
 
+// renderLogPrefix fills the log prefix template with the origin of rule. The backend truncates a prefix
+// longer than its limit, which is warned once per policy
+func (r *DefaultRuleRenderer) renderLogPrefix(origin ruleOrigin) string {
+	prefix := strings.NewReplacer(
+		config.LogPrefixPolicy, origin.policyName,
+		config.LogPrefixRule, strconv.Itoa(origin.index),
+		config.LogPrefixDirection, origin.direction,
+	).Replace(r.logPrefix)
+	// the backend keeps one character for the space separating prefix from the logged packet
+	if len(strings.TrimRight(prefix, " ")) > r.logPrefixMaxLen-1 {
+		if _, ok := r.logPrefixWarned[origin.policyName]; !ok {
+			r.logPrefixWarned[origin.policyName] = struct{}{}
+			slog.Warn("log prefix is truncated, shorten LOG_PREFIX or the policy name", "policy", origin.policyName,
+				"prefix", prefix, "maxLength", r.logPrefixMaxLen-1)
+		}
+	}
+	return prefix
+}
+
 func checkProtocol(protocol interface{}) bool {
 	switch protocol.(type) {
 	case string:
-		return slices.Contains([]string{dto.ProtocolTCP, dto.ProtocolUDP, dto.ProtocolICMP, dto.ProtocolSCTP, dto.ProtocolUDPLite}, strings.ToLower(protocol.(string)))
+		return slices.Contains([]string{dto.ProtocolTCP, dto.ProtocolUDP, dto.ProtocolICMP, dto.ProtocolICMPv6, dto.ProtocolSCTP, dto.ProtocolUDPLite}, strings.ToLower(protocol.(string)))
 	case float64:
 		protocolNum := uint8(protocol.(float64))
 		return protocolNum != 0
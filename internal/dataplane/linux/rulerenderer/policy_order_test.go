@@ -0,0 +1,74 @@
+package rulerenderer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+)
+
+func TestPolicyOrder(t *testing.T) {
+	order := func(o float64) *float64 { return &o }
+	allow := []*dto.ParsedRule{{Action: "allow"}}
+	tests := []struct {
+		name     string
+		policies []*dto.ParsedGNP
+		// expected uuids of policies in jump order
+		expected []string
+	}{
+		{
+			name: "by order then name",
+			policies: []*dto.ParsedGNP{
+				{UUID: "1", Name: "no-order", InboundRules: allow},
+				{UUID: "2", Name: "b", Order: order(10), InboundRules: allow},
+				{UUID: "3", Name: "a", Order: order(10), InboundRules: allow},
+				{UUID: "4", Name: "first", Order: order(1.5), InboundRules: allow},
+			},
+			expected: []string{"4", "3", "2", "1"},
+		},
+		{
+			name: "inserted policy does not rename others",
+			policies: []*dto.ParsedGNP{
+				{UUID: "3", Name: "a", Order: order(10), InboundRules: allow},
+				{UUID: "5", Name: "inserted", Order: order(0), InboundRules: allow},
+				{UUID: "4", Name: "first", Order: order(1.5), InboundRules: allow},
+			},
+			expected: []string{"5", "4", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: generictables.IPFamily4}, ipset.NewNameConvention())
+			chains := r.HostEndpointPoliciesToChains([]*dto.HostEndpointPolicy{{
+				HEP:        &dto.HostEndpoint{Metadata: dto.HostEndpointMetadata{Name: "host"}},
+				ParsedGNPs: tt.policies,
+			}}, generictables.IPFamily4)
+			uuidToName := make(map[string]string)
+			for _, policy := range tt.policies {
+				uuidToName[policy.UUID] = policy.Name
+			}
+			var expectedJumps []string
+			for _, uuid := range tt.expected {
+				expectedJumps = append(expectedJumps,
+					"-j "+iptables.GetCustomChainName(generictables.OurInputChainPrefix+uuidToName[uuid], uuid))
+			}
+			var jumps []string
+			for _, chain := range chains {
+				if chain.Name != generictables.OurDefaultInputChain {
+					continue
+				}
+				for _, rule := range chain.Rules {
+					if parameter := rule.Action.ToParameter(); strings.HasPrefix(parameter, "-j "+generictables.OurInputChainPrefix) {
+						jumps = append(jumps, parameter)
+					}
+				}
+			}
+			assert.Equal(t, expectedJumps, jumps)
+		})
+	}
+}
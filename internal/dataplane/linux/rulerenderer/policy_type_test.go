@@ -0,0 +1,93 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func TestPolicyTypes(t *testing.T) {
+	allowSSH := []*dto.ParsedRule{{Action: "allow", Protocol: "tcp", DstPorts: []string{"22"}}}
+	tests := []struct {
+		name             string
+		policy           *dto.ParsedGNP
+		rejectByDefault  bool
+		expectedInbound  []string
+		expectedOutbound []string
+	}{
+		{
+			name:             "untyped policy renders directions with rules",
+			policy:           &dto.ParsedGNP{Name: "p", InboundRules: allowSSH},
+			expectedInbound:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT"},
+			expectedOutbound: nil,
+		},
+		{
+			name:             "ingress typed policy without rules denies",
+			policy:           &dto.ParsedGNP{Name: "p", Types: []string{dto.PolicyTypeIngress}},
+			expectedInbound:  []string{" -j DROP"},
+			expectedOutbound: nil,
+		},
+		{
+			name:             "ingress typed policy leaves egress alone",
+			policy:           &dto.ParsedGNP{Name: "p", Types: []string{dto.PolicyTypeIngress}, InboundRules: allowSSH, OutboundRules: allowSSH},
+			expectedInbound:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT"},
+			expectedOutbound: nil,
+		},
+		{
+			name: "end of chain action",
+			policy: &dto.ParsedGNP{
+				Name:             "p",
+				Types:            []string{dto.PolicyTypeIngress, dto.PolicyTypeEgress},
+				InboundRules:     allowSSH,
+				EndOfChainAction: dto.EndOfChainActionPass,
+			},
+			expectedInbound:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT", " -j RETURN"},
+			expectedOutbound: []string{" -j RETURN"},
+		},
+		{
+			name:             "untyped policy with end of chain deny",
+			policy:           &dto.ParsedGNP{Name: "p", InboundRules: allowSSH, EndOfChainAction: dto.EndOfChainActionDeny},
+			expectedInbound:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT", " -j DROP"},
+			expectedOutbound: []string{" -j DROP"},
+		},
+		{
+			name:             "untyped policy without rules with end of chain deny",
+			policy:           &dto.ParsedGNP{Name: "p", EndOfChainAction: dto.EndOfChainActionDeny},
+			expectedInbound:  []string{" -j DROP"},
+			expectedOutbound: []string{" -j DROP"},
+		},
+		{
+			name:             "end of chain deny rejects by default",
+			policy:           &dto.ParsedGNP{Name: "p", InboundRules: allowSSH, EndOfChainAction: dto.EndOfChainActionDeny},
+			rejectByDefault:  true,
+			expectedInbound:  []string{"-p tcp -m multiport --destination-ports 22 -j ACCEPT", " -j REJECT"},
+			expectedOutbound: []string{" -j REJECT"},
+		},
+		{
+			name:             "ingress typed policy without rules rejects by default",
+			policy:           &dto.ParsedGNP{Name: "p", Types: []string{dto.PolicyTypeIngress}},
+			rejectByDefault:  true,
+			expectedInbound:  []string{" -j REJECT"},
+			expectedOutbound: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: generictables.IPFamily4, RejectByDefault: tt.rejectByDefault}, ipset.NewNameConvention())
+			rendered, chains := r.policyToChains(tt.policy, generictables.IPFamily4)
+			chainRules := make(map[string][]string)
+			for _, chain := range chains {
+				for _, rule := range chain.Rules {
+					chainRules[chain.Name] = append(chainRules[chain.Name], rule.Match.Render()+" "+rule.Action.ToParameter())
+				}
+			}
+			assert.Equal(t, tt.expectedInbound, chainRules[rendered.inbound])
+			assert.Equal(t, tt.expectedOutbound, chainRules[rendered.outbound])
+			assert.Equal(t, tt.expectedOutbound == nil, rendered.outbound == "")
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package rulerenderer
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/internal/flowlog"
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+// rejectWithOfIPTables reject-with of iptables by ip family
+var rejectWithOfIPTables = map[string]map[int]string{
+	dto.RejectWithTCPReset: {
+		generictables.IPFamily4: "tcp-reset",
+		generictables.IPFamily6: "tcp-reset",
+	},
+	dto.RejectWithPortUnreachable: {
+		generictables.IPFamily4: "icmp-port-unreachable",
+		generictables.IPFamily6: "icmp6-port-unreachable",
+	},
+	dto.RejectWithHostUnreachable: {
+		generictables.IPFamily4: "icmp-host-unreachable",
+		generictables.IPFamily6: "icmp6-addr-unreachable",
+	},
+	dto.RejectWithNetUnreachable: {
+		generictables.IPFamily4: "icmp-net-unreachable",
+		generictables.IPFamily6: "icmp6-no-route",
+	},
+	dto.RejectWithAdminProhibited: {
+		generictables.IPFamily4: "icmp-admin-prohibited",
+		generictables.IPFamily6: "icmp6-adm-prohibited",
+	},
+}
+
+// rejectWithOfNFTables reject with of nftables, icmpx types are translated to the icmp type of packet family
+var rejectWithOfNFTables = map[string]string{
+	dto.RejectWithTCPReset:        "tcp reset",
+	dto.RejectWithPortUnreachable: "icmpx type port-unreachable",
+	dto.RejectWithHostUnreachable: "icmpx type host-unreachable",
+	dto.RejectWithNetUnreachable:  "icmpx type no-route",
+	dto.RejectWithAdminProhibited: "icmpx type admin-prohibited",
+}
+
+// defaultActionRules are the last rules of our chains, for packets not allowed by any policy
+func (r *DefaultRuleRenderer) defaultActionRules(direction string) []generictables.Rule {
+	return r.matchDefaultActionRules(r.NewMatch(), direction)
+}
+
+// matchDefaultActionRules are the default action rules restricted to the packets of match
+func (r *DefaultRuleRenderer) matchDefaultActionRules(match generictables.MatchCriteria,
+	direction string) []generictables.Rule {
+	action, verdict := r.Drop(), flowlog.VerdictDeny
+	if r.rejectByDefault {
+		action, verdict = r.Reject(""), flowlog.VerdictReject
+	}
+	var rules []generictables.Rule
+	if r.flowLogs {
+		rules = append(rules, r.flowLogRule(match, verdict, ruleOrigin{direction: direction, index: -1}))
+	}
+	return append(rules, generictables.Rule{Match: match, Action: action})
+}
+
+// rejectAction renders reject-with of rule for the backend. A tcp reset is only valid for tcp packets, so
+// rules that do not match tcp only are rejected with the default icmp error instead.
+func (r *DefaultRuleRenderer) rejectAction(rule *dto.ParsedRule, ipVersion int) generictables.Action {
+	with := strings.ToLower(rule.RejectWith)
+	if with == "" {
+		return r.Reject("")
+	}
+	if with == dto.RejectWithTCPReset && !isTCPRule(rule) {
+		slog.Warn("tcp reset of non tcp rule, reject with default icmp error", "protocol", rule.Protocol)
+		return r.Reject("")
+	}
+	if r.nftables {
+		if rejectWith, ok := rejectWithOfNFTables[with]; ok {
+			return r.Reject(rejectWith)
+		}
+	} else if rejectWith, ok := rejectWithOfIPTables[with][ipVersion]; ok {
+		return r.Reject(rejectWith)
+	}
+	slog.Warn("unsupported reject with, reject with default icmp error", "rejectWith", rule.RejectWith)
+	return r.Reject("")
+}
+
+func isTCPRule(rule *dto.ParsedRule) bool {
+	if rule.IsProtocolNegative {
+		return false
+	}
+	switch protocol := rule.Protocol.(type) {
+	case string:
+		return strings.ToLower(protocol) == dto.ProtocolTCP
+	case float64:
+		return protocol == 6
+	default:
+		return false
+	}
+}
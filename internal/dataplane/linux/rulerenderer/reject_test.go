@@ -0,0 +1,66 @@
+package rulerenderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+)
+
+func TestRejectAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		nftables  bool
+		ipVersion int
+		rule      *dto.ParsedRule
+		expected  string
+	}{
+		{
+			name:      "default",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Action: "reject"},
+			expected:  "-j REJECT",
+		},
+		{
+			name:      "iptables ipv6 admin prohibited",
+			ipVersion: generictables.IPFamily6,
+			rule:      &dto.ParsedRule{Action: "reject", RejectWith: dto.RejectWithAdminProhibited},
+			expected:  "-j REJECT --reject-with icmp6-adm-prohibited",
+		},
+		{
+			name:      "iptables tcp reset",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Action: "reject", RejectWith: dto.RejectWithTCPReset, Protocol: "TCP"},
+			expected:  "-j REJECT --reject-with tcp-reset",
+		},
+		{
+			name:      "tcp reset of non tcp rule",
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Action: "reject", RejectWith: dto.RejectWithTCPReset, Protocol: "udp"},
+			expected:  "-j REJECT",
+		},
+		{
+			name:      "nftables port unreachable",
+			nftables:  true,
+			ipVersion: generictables.IPFamily6,
+			rule:      &dto.ParsedRule{Action: "reject", RejectWith: dto.RejectWithPortUnreachable},
+			expected:  "reject with icmpx type port-unreachable",
+		},
+		{
+			name:      "unsupported",
+			nftables:  true,
+			ipVersion: generictables.IPFamily4,
+			rule:      &dto.ParsedRule{Action: "reject", RejectWith: "echo-reply"},
+			expected:  "reject",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(Config{IPVersion: tt.ipVersion, NFTables: tt.nftables}, ipset.NewNameConvention())
+			assert.Equal(t, tt.expected, r.renderRuleAction(tt.rule, tt.ipVersion, ruleOrigin{}).ToParameter())
+		})
+	}
+}
@@ -1,28 +1,125 @@
 package rulerenderer
 
 import (
+	"github.com/bamboo-firewall/agent/config"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 	"github.com/bamboo-firewall/agent/pkg/ipset"
 	"github.com/bamboo-firewall/agent/pkg/iptables"
+	"github.com/bamboo-firewall/agent/pkg/nftables"
 )
 
+type Config struct {
+	// IPVersion ip family of the rendered rules
+	IPVersion int
+	// NFTables render rules for the nftables backend instead of iptables
+	NFTables bool
+
+	// LogPrefix template of the prefix of log rules, see config.LogPrefixPolicy
+	LogPrefix string
+	// LogLevel syslog level of log rules
+	LogLevel uint8
+	// LogRateLimit rate of logged packets per rule, e.g. 10/minute, empty logs every packet
+	LogRateLimit      string
+	LogRateLimitBurst int
+
+	// RejectByDefault rejects packets not allowed by any policy instead of dropping them
+	RejectByDefault bool
+
+	// APIServerIPs and APIServerPort allow agent call to api-server
+	APIServerIPs  []string
+	APIServerPort uint16
+
+	// FailsafeInboundHostPorts and FailsafeOutboundHostPorts are allowed before any policy
+	FailsafeInboundHostPorts  []config.ProtoPort
+	FailsafeOutboundHostPorts []config.ProtoPort
+
+	// ICMPv6EssentialTypes are allowed before any policy in ipv6 chains, e.g. neighbor discovery
+	ICMPv6EssentialTypes []uint8
+
+	// NFLogGroup netlink group of nflog rules
+	NFLogGroup uint16
+	// FlowLogs sends packets matched by policy rules and default actions to NFLogGroup, tagged with
+	// their verdict
+	FlowLogs bool
+
+	// NetSetThreshold rules with more literal nets are rendered with agent managed sets, 0 disables sets
+	NetSetThreshold int
+
+	// ForwardEnforcement renders the forward rules of policies in our forward chain
+	ForwardEnforcement bool
+	// ForwardMark packet mark bit of forwarded packets allowed by forward inbound policies, forward outbound
+	// policies decide them next
+	ForwardMark uint32
+}
+
 type DefaultRuleRenderer struct {
 	generictables.ActionFactory
 
-	logPrefix string
+	nftables bool
+
+	logPrefix         string
+	logPrefixMaxLen   int
+	logLevel          uint8
+	logRateLimit      string
+	logRateLimitBurst int
+	rejectByDefault   bool
+
+	nflogGroup uint16
+	flowLogs   bool
+
+	netSetThreshold int
+
+	forwardEnforcement bool
+	forwardMark        uint32
+
+	apiServerIPs  []string
+	apiServerPort uint16
+
+	failsafeInboundHostPorts  []config.ProtoPort
+	failsafeOutboundHostPorts []config.ProtoPort
+
+	icmpv6EssentialTypes []uint8
+
+	// logPrefixWarned policies already warned about a log prefix longer than logPrefixMaxLen
+	logPrefixWarned map[string]struct{}
 
 	NewMatch            func() generictables.MatchCriteria
 	ipsetNameConvention *ipset.NameConvention
 }
 
-func NewRenderer(logPrefix string, ipsetNameConvention *ipset.NameConvention) *DefaultRuleRenderer {
-	return &DefaultRuleRenderer{
-		logPrefix:     logPrefix,
-		ActionFactory: iptables.NewAction(),
-		// ToDo: check config is iptables or nftables
-		NewMatch: func() generictables.MatchCriteria {
+func NewRenderer(conf Config, ipsetNameConvention *ipset.NameConvention) *DefaultRuleRenderer {
+	r := &DefaultRuleRenderer{
+		nftables:                  conf.NFTables,
+		logPrefix:                 conf.LogPrefix,
+		logLevel:                  conf.LogLevel,
+		logRateLimit:              conf.LogRateLimit,
+		logRateLimitBurst:         conf.LogRateLimitBurst,
+		rejectByDefault:           conf.RejectByDefault,
+		nflogGroup:                conf.NFLogGroup,
+		flowLogs:                  conf.FlowLogs,
+		netSetThreshold:           conf.NetSetThreshold,
+		forwardEnforcement:        conf.ForwardEnforcement,
+		forwardMark:               conf.ForwardMark,
+		apiServerIPs:              conf.APIServerIPs,
+		apiServerPort:             conf.APIServerPort,
+		failsafeInboundHostPorts:  conf.FailsafeInboundHostPorts,
+		failsafeOutboundHostPorts: conf.FailsafeOutboundHostPorts,
+		icmpv6EssentialTypes:      conf.ICMPv6EssentialTypes,
+		ipsetNameConvention:       ipsetNameConvention,
+		logPrefixWarned:           make(map[string]struct{}),
+	}
+	if conf.NFTables {
+		r.ActionFactory = nftables.NewAction()
+		r.logPrefixMaxLen = nftables.MaxLogPrefixLength
+		r.NewMatch = func() generictables.MatchCriteria {
+			return nftables.NewMatch(conf.IPVersion)
+		}
+	} else {
+		r.ActionFactory = iptables.NewAction()
+		r.logPrefixMaxLen = iptables.MaxLogPrefixLength
+		r.NewMatch = func() generictables.MatchCriteria {
 			return iptables.NewMatch()
-		},
-		ipsetNameConvention: ipsetNameConvention,
+		}
 	}
+	return r
 }
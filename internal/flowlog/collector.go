@@ -0,0 +1,126 @@
+package flowlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// OutputStdout writes flow records to stdout instead of a file
+const OutputStdout = "stdout"
+
+// Record is a flow record, written as one json document per line
+type Record struct {
+	Time      time.Time `json:"time"`
+	Policy    string    `json:"policy"`
+	Rule      int       `json:"rule"`
+	Direction string    `json:"direction"`
+	Verdict   string    `json:"verdict"`
+	IPVersion int       `json:"ipVersion"`
+	Protocol  string    `json:"protocol"`
+	SrcIP     string    `json:"srcIP"`
+	SrcPort   uint16    `json:"srcPort,omitempty"`
+	DstIP     string    `json:"dstIP"`
+	DstPort   uint16    `json:"dstPort,omitempty"`
+	ICMPType  *uint8    `json:"icmpType,omitempty"`
+	ICMPCode  *uint8    `json:"icmpCode,omitempty"`
+}
+
+// Collector turns packets logged by NFLOG rules into flow records
+type Collector struct {
+	source  Source
+	encoder *json.Encoder
+}
+
+func NewCollector(source Source, w io.Writer) *Collector {
+	return &Collector{
+		source:  source,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// OpenOutput opens the destination of flow records, records are appended to file
+func OpenOutput(output string) (io.WriteCloser, error) {
+	if output == "" || output == OutputStdout {
+		return nopCloser{os.Stdout}, nil
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open flow log file failed: %w", err)
+	}
+	return f, nil
+}
+
+// Run writes a record for every packet of source until ctx is done or source is exhausted
+func (c *Collector) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := c.source.Close(); err != nil {
+				slog.Warn("close flow log source error:", "err", err)
+			}
+		case <-done:
+		}
+	}()
+
+	for {
+		packet, err := c.source.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) || (errors.Is(err, os.ErrClosed) && ctx.Err() != nil) {
+				return nil
+			}
+			return err
+		}
+		record, err := newRecord(packet)
+		if err != nil {
+			flowDecodeErrors.Inc()
+			slog.Debug("decode flow record error:", "err", err, "prefix", packet.Prefix)
+			continue
+		}
+		if err = c.encoder.Encode(record); err != nil {
+			return fmt.Errorf("write flow record failed: %w", err)
+		}
+		flowRecords.WithLabelValues(record.Verdict).Inc()
+	}
+}
+
+func newRecord(packet Packet) (Record, error) {
+	tag, err := DecodePrefix(packet.Prefix)
+	if err != nil {
+		return Record{}, err
+	}
+	f, err := decodeFlow(packet.Payload)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Time:      packet.Time,
+		Policy:    tag.Policy,
+		Rule:      tag.Rule,
+		Direction: tag.Direction,
+		Verdict:   tag.Verdict,
+		IPVersion: f.ipVersion,
+		Protocol:  protocolName(f.protocol),
+		SrcIP:     f.srcIP.String(),
+		SrcPort:   f.srcPort,
+		DstIP:     f.dstIP.String(),
+		DstPort:   f.dstPort,
+		ICMPType:  f.icmpType,
+		ICMPCode:  f.icmpCode,
+	}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package flowlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedPacket a packet of a LINKTYPE_NFLOG capture
+type recordedPacket struct {
+	time    time.Time
+	prefix  string
+	payload []byte
+}
+
+func writePcap(packets []recordedPacket) []byte {
+	buf := new(bytes.Buffer)
+	header := make([]byte, pcapGlobalHeaderLength)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], LinkTypeNFLOG)
+	buf.Write(header)
+
+	for _, packet := range packets {
+		// nflog header: AF_INET, version 0, group 100
+		data := []byte{2, 0, 0, 100}
+		data = append(data, tlv(nfulaPrefix, append([]byte(packet.prefix), 0))...)
+		data = append(data, tlv(nfulaPayload, packet.payload)...)
+
+		record := make([]byte, pcapRecordHeaderLength)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(packet.time.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(packet.time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(data)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(data)))
+		buf.Write(record)
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func tlv(attrType uint16, value []byte) []byte {
+	length := 4 + len(value)
+	attr := make([]byte, (length+3)&^3)
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(length))
+	binary.LittleEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[4:], value)
+	return attr
+}
+
+func ipv4TCP(src, dst string, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 40)
+	packet[0] = 0x45
+	packet[9] = protocolTCP
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(packet[20:22], srcPort)
+	binary.BigEndian.PutUint16(packet[22:24], dstPort)
+	return packet
+}
+
+func ipv6ICMP(src, dst string, icmpType, icmpCode uint8) []byte {
+	packet := make([]byte, 48)
+	packet[0] = 0x60
+	packet[6] = protocolICMPv6
+	copy(packet[8:24], net.ParseIP(src).To16())
+	copy(packet[24:40], net.ParseIP(dst).To16())
+	packet[40] = icmpType
+	packet[41] = icmpCode
+	return packet
+}
+
+func TestCollectorFromPcap(t *testing.T) {
+	packetTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	capture := writePcap([]recordedPacket{
+		{
+			time:    packetTime,
+			prefix:  EncodePrefix(Tag{Verdict: VerdictAllow, Direction: "in", Rule: 1, Policy: "allow-ssh"}),
+			payload: ipv4TCP("10.0.0.1", "10.0.0.2", 40000, 22),
+		},
+		{
+			time:    packetTime,
+			prefix:  "[bambooFW] in:0 kernel log",
+			payload: ipv4TCP("10.0.0.1", "10.0.0.2", 40000, 80),
+		},
+		{
+			time:    packetTime.Add(time.Second),
+			prefix:  EncodePrefix(Tag{Verdict: VerdictDeny, Direction: "out", Rule: -1}),
+			payload: ipv6ICMP("2001:db8::1", "2001:db8::2", 128, 0),
+		},
+	})
+	source, err := NewPcapSource(bytes.NewReader(capture))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	output := new(bytes.Buffer)
+	assert.NoError(t, NewCollector(source, output).Run(context.Background()))
+
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var record Record
+		if assert.NoError(t, json.Unmarshal([]byte(line), &record)) {
+			records = append(records, record)
+		}
+	}
+	icmpType, icmpCode := uint8(128), uint8(0)
+	assert.Equal(t, []Record{
+		{
+			Time:      packetTime,
+			Policy:    "allow-ssh",
+			Rule:      1,
+			Direction: "in",
+			Verdict:   VerdictAllow,
+			IPVersion: 4,
+			Protocol:  "tcp",
+			SrcIP:     "10.0.0.1",
+			SrcPort:   40000,
+			DstIP:     "10.0.0.2",
+			DstPort:   22,
+		},
+		{
+			Time:      packetTime.Add(time.Second),
+			Rule:      -1,
+			Direction: "out",
+			Verdict:   VerdictDeny,
+			IPVersion: 6,
+			Protocol:  "icmpv6",
+			SrcIP:     "2001:db8::1",
+			DstIP:     "2001:db8::2",
+			ICMPType:  &icmpType,
+			ICMPCode:  &icmpCode,
+		},
+	}, records)
+}
+
+func TestPcapSourceRejectsOversizedRecord(t *testing.T) {
+	capture := writePcap([]recordedPacket{
+		{time: time.Unix(1700000000, 0), prefix: "[bambooFW] in:0 web", payload: ipv4TCP("10.0.0.1", "10.0.0.2", 40000, 80)},
+	})
+	// captured length of the first record beyond the snaplen of the capture
+	binary.LittleEndian.PutUint32(capture[pcapGlobalHeaderLength+8:], 1<<31)
+
+	source, err := NewPcapSource(bytes.NewReader(capture))
+	assert.NoError(t, err)
+	_, err = source.Read()
+	assert.ErrorContains(t, err, "exceeds snaplen 65535")
+}
+
+func TestDecodePrefix(t *testing.T) {
+	tag := Tag{Verdict: VerdictReject, Direction: "in", Rule: 3, Policy: "deny|all"}
+	decoded, err := DecodePrefix(EncodePrefix(tag))
+	assert.NoError(t, err)
+	assert.Equal(t, tag, decoded)
+
+	_, err = DecodePrefix("X|in|0|policy")
+	assert.Error(t, err)
+}
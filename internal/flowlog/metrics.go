@@ -0,0 +1,18 @@
+package flowlog
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	flowRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_agent_flow_records_total",
+		Help: "Number of flow records written by the flow log collector.",
+	}, []string{"verdict"})
+	flowDecodeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bamboo_agent_flow_decode_errors_total",
+		Help: "Number of packets logged by NFLOG rules that could not be decoded into a flow record.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(flowRecords, flowDecodeErrors)
+}
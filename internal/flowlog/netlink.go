@@ -0,0 +1,171 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	nfnlSubsysULOG   = 4
+	nfulnlMsgPacket  = 0
+	nfulnlMsgConfig  = 1
+	nfulaCfgCmd      = 1
+	nfulaCfgMode     = 2
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+
+	// nfgenmsgLength family, version and resource id of netfilter messages
+	nfgenmsgLength = 4
+	// copyRange bytes of packet copied to userspace, enough for ip and transport headers
+	copyRange = 128
+
+	receiveBufferSize = 1 << 16
+	// receiveTimeout bounds a blocking receive, so that Close is noticed by Read
+	receiveTimeout = time.Second
+)
+
+// NetlinkSource reads packets of an NFLOG group from the kernel
+type NetlinkSource struct {
+	fd     int
+	group  uint16
+	buffer []byte
+	closed atomic.Bool
+	// mu is held by Read, so that Close does not close the socket under a receive
+	mu        sync.Mutex
+	closeOnce sync.Once
+	// pending packets of the last received datagram, a datagram can carry many messages
+	pending []Packet
+}
+
+func NewNetlinkSource(group uint16) (*NetlinkSource, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("open netlink socket failed: %w", err)
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind netlink socket failed: %w", err)
+	}
+
+	timeout := syscall.NsecToTimeval(receiveTimeout.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("set netlink socket timeout failed: %w", err)
+	}
+
+	s := &NetlinkSource{fd: fd, group: group, buffer: make([]byte, receiveBufferSize)}
+	// bind the group then ask for packet content
+	cmd := []byte{nfulnlCfgCmdBind}
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], copyRange)
+	mode[4] = nfulnlCopyPacket
+	for _, attr := range [][]byte{netlinkAttribute(nfulaCfgCmd, cmd), netlinkAttribute(nfulaCfgMode, mode)} {
+		if err = s.config(attr); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// config sends a config message of our group and waits for its ack
+func (s *NetlinkSource) config(attr []byte) error {
+	msg := make([]byte, syscall.NLMSG_HDRLEN+nfgenmsgLength)
+	msgType := uint16(nfnlSubsysULOG<<8 | nfulnlMsgConfig)
+	msg = append(msg, attr...)
+	// struct nlmsghdr: length, type, flags, sequence and port id
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], 1)
+	// nfgenmsg: AF_UNSPEC, NFNETLINK_V0, group in network byte order
+	binary.BigEndian.PutUint16(msg[syscall.NLMSG_HDRLEN+2:], s.group)
+
+	if err := syscall.Sendto(s.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("send nflog config failed: %w", err)
+	}
+	n, _, err := syscall.Recvfrom(s.fd, s.buffer, 0)
+	if err != nil {
+		return fmt.Errorf("receive nflog config ack failed: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(s.buffer[:n])
+	if err != nil {
+		return fmt.Errorf("parse nflog config ack failed: %w", err)
+	}
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_ERROR && len(m.Data) >= 4 {
+			if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return fmt.Errorf("nflog config of group %d failed: %w", s.group, syscall.Errno(-errno))
+			}
+		}
+	}
+	return nil
+}
+
+func (s *NetlinkSource) Read() (Packet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.pending) == 0 {
+		if s.closed.Load() {
+			return Packet{}, os.ErrClosed
+		}
+		n, _, err := syscall.Recvfrom(s.fd, s.buffer, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+				continue
+			}
+			if errors.Is(err, syscall.ENOBUFS) {
+				// kernel dropped packets because we are too slow, keep reading
+				slog.Warn("nflog receive buffer overrun, flow records are lost", "group", s.group)
+				continue
+			}
+			return Packet{}, fmt.Errorf("receive nflog packet failed: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(s.buffer[:n])
+		if err != nil {
+			return Packet{}, fmt.Errorf("parse nflog packet failed: %w", err)
+		}
+		now := time.Now()
+		for _, m := range msgs {
+			if m.Header.Type != nfnlSubsysULOG<<8|nfulnlMsgPacket || len(m.Data) < nfgenmsgLength {
+				continue
+			}
+			packet := packetFromAttributes(m.Data[nfgenmsgLength:], binary.NativeEndian, now)
+			// the buffer is reused by the next receive
+			packet.Payload = append([]byte(nil), packet.Payload...)
+			s.pending = append(s.pending, packet)
+		}
+	}
+	packet := s.pending[0]
+	s.pending = s.pending[1:]
+	return packet, nil
+}
+
+// Close stops Read within receiveTimeout then closes the socket
+func (s *NetlinkSource) Close() error {
+	s.closed.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	s.closeOnce.Do(func() {
+		err = syscall.Close(s.fd)
+	})
+	return err
+}
+
+// netlinkAttribute encodes a netlink attribute aligned to 4 bytes
+func netlinkAttribute(attrType uint16, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+	attr := make([]byte, (length+3)&^3)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[syscall.SizeofRtAttr:], value)
+	return attr
+}
@@ -0,0 +1,102 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	protocolICMP    = 1
+	protocolTCP     = 6
+	protocolUDP     = 17
+	protocolICMPv6  = 58
+	protocolSCTP    = 132
+	protocolUDPLite = 136
+
+	ipv4HeaderMinLength = 20
+	ipv6HeaderLength    = 40
+)
+
+var protocolNames = map[uint8]string{
+	protocolICMP:    "icmp",
+	protocolTCP:     "tcp",
+	protocolUDP:     "udp",
+	protocolICMPv6:  "icmpv6",
+	protocolSCTP:    "sctp",
+	protocolUDPLite: "udplite",
+}
+
+// flow is the 5-tuple of a packet, icmp packets have type and code instead of ports
+type flow struct {
+	ipVersion int
+	protocol  uint8
+	srcIP     net.IP
+	dstIP     net.IP
+	srcPort   uint16
+	dstPort   uint16
+	icmpType  *uint8
+	icmpCode  *uint8
+}
+
+// decodeFlow reads the 5-tuple from an ip packet, the payload may be truncated after the transport header
+func decodeFlow(payload []byte) (flow, error) {
+	if len(payload) == 0 {
+		return flow{}, errors.New("empty packet")
+	}
+
+	var (
+		f         flow
+		transport []byte
+	)
+	switch payload[0] >> 4 {
+	case 4:
+		if len(payload) < ipv4HeaderMinLength {
+			return flow{}, errors.New("truncated ipv4 header")
+		}
+		headerLength := int(payload[0]&0x0f) * 4
+		if headerLength < ipv4HeaderMinLength || len(payload) < headerLength {
+			return flow{}, fmt.Errorf("malformed ipv4 header length %d", headerLength)
+		}
+		f.ipVersion = 4
+		f.protocol = payload[9]
+		f.srcIP = net.IP(payload[12:16])
+		f.dstIP = net.IP(payload[16:20])
+		transport = payload[headerLength:]
+	case 6:
+		if len(payload) < ipv6HeaderLength {
+			return flow{}, errors.New("truncated ipv6 header")
+		}
+		// extension headers are not followed, their packets are reported without ports
+		f.ipVersion = 6
+		f.protocol = payload[6]
+		f.srcIP = net.IP(payload[8:24])
+		f.dstIP = net.IP(payload[24:40])
+		transport = payload[ipv6HeaderLength:]
+	default:
+		return flow{}, fmt.Errorf("unknown ip version %d", payload[0]>>4)
+	}
+
+	switch f.protocol {
+	case protocolTCP, protocolUDP, protocolSCTP, protocolUDPLite:
+		if len(transport) >= 4 {
+			f.srcPort = binary.BigEndian.Uint16(transport[0:2])
+			f.dstPort = binary.BigEndian.Uint16(transport[2:4])
+		}
+	case protocolICMP, protocolICMPv6:
+		if len(transport) >= 2 {
+			icmpType, icmpCode := transport[0], transport[1]
+			f.icmpType = &icmpType
+			f.icmpCode = &icmpCode
+		}
+	}
+	return f, nil
+}
+
+func protocolName(protocol uint8) string {
+	if name, ok := protocolNames[protocol]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", protocol)
+}
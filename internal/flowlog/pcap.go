@@ -0,0 +1,100 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+
+	pcapGlobalHeaderLength = 24
+	pcapRecordHeaderLength = 16
+	// nflogHeaderLength family, version and resource id(group) before the attributes
+	nflogHeaderLength = 4
+	// maxPcapRecordLength bounds records of captures without a sane snaplen, the default snaplen of tcpdump
+	maxPcapRecordLength = 262144
+
+	// LinkTypeNFLOG link type of captures written by "tcpdump -i nflog:<group> -w <file>"
+	LinkTypeNFLOG = 239
+)
+
+// PcapSource replays packets of a LINKTYPE_NFLOG capture, so the collector can be exercised without
+// the kernel.
+type PcapSource struct {
+	r           io.Reader
+	byteOrder   binary.ByteOrder
+	nanoseconds bool
+	// snapLen maximum length of records, a corrupted length must not allocate gigabytes
+	snapLen uint32
+}
+
+func NewPcapSource(r io.Reader) (*PcapSource, error) {
+	header := make([]byte, pcapGlobalHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read pcap header failed: %w", err)
+	}
+
+	s := &PcapSource{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header[0:4]) == pcapMagicMicroseconds:
+		s.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[0:4]) == pcapMagicMicroseconds:
+		s.byteOrder = binary.BigEndian
+	case binary.LittleEndian.Uint32(header[0:4]) == pcapMagicNanoseconds:
+		s.byteOrder, s.nanoseconds = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header[0:4]) == pcapMagicNanoseconds:
+		s.byteOrder, s.nanoseconds = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("unknown pcap magic %x", header[0:4])
+	}
+	if linkType := s.byteOrder.Uint32(header[20:24]); linkType != LinkTypeNFLOG {
+		return nil, fmt.Errorf("unsupported link type %d, expected %d(NFLOG)", linkType, LinkTypeNFLOG)
+	}
+	s.snapLen = s.byteOrder.Uint32(header[16:20])
+	if s.snapLen == 0 || s.snapLen > maxPcapRecordLength {
+		s.snapLen = maxPcapRecordLength
+	}
+	return s, nil
+}
+
+func (s *PcapSource) Read() (Packet, error) {
+	header := make([]byte, pcapRecordHeaderLength)
+	if _, err := io.ReadFull(s.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("truncated pcap record header: %w", err)
+		}
+		return Packet{}, err
+	}
+	sec := s.byteOrder.Uint32(header[0:4])
+	fraction := s.byteOrder.Uint32(header[4:8])
+	capturedLength := s.byteOrder.Uint32(header[8:12])
+	if capturedLength > s.snapLen {
+		return Packet{}, fmt.Errorf("pcap record length %d exceeds snaplen %d", capturedLength, s.snapLen)
+	}
+
+	data := make([]byte, capturedLength)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return Packet{}, fmt.Errorf("truncated pcap record: %w", err)
+	}
+	if len(data) < nflogHeaderLength {
+		return Packet{}, fmt.Errorf("truncated nflog header")
+	}
+
+	nsec := int64(fraction) * int64(time.Microsecond)
+	if s.nanoseconds {
+		nsec = int64(fraction)
+	}
+	// attributes of LINKTYPE_NFLOG are in the byte order of the capturing host, as the pcap header
+	return packetFromAttributes(data[nflogHeaderLength:], s.byteOrder, time.Unix(int64(sec), nsec)), nil
+}
+
+func (s *PcapSource) Close() error {
+	if closer, ok := s.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package flowlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	VerdictAllow  = "allow"
+	VerdictDeny   = "deny"
+	VerdictReject = "reject"
+	VerdictPass   = "pass"
+	VerdictLog    = "log"
+
+	prefixSeparator = "|"
+)
+
+// verdictCodes keeps prefix short, iptables truncates NFLOG prefix to 63 characters
+var verdictCodes = map[string]string{
+	VerdictAllow:  "A",
+	VerdictDeny:   "D",
+	VerdictReject: "R",
+	VerdictPass:   "P",
+	VerdictLog:    "L",
+}
+
+// Tag identifies the rule that logged a packet. It is carried by the NFLOG prefix. Packets of the
+// default action of our chains have no policy and rule -1.
+type Tag struct {
+	Verdict   string
+	Direction string
+	Rule      int
+	Policy    string
+}
+
+// EncodePrefix renders tag as NFLOG prefix: verdict|direction|rule|policy, e.g. A|in|0|allow-ssh
+func EncodePrefix(tag Tag) string {
+	return strings.Join([]string{verdictCodes[tag.Verdict], tag.Direction, strconv.Itoa(tag.Rule), tag.Policy}, prefixSeparator)
+}
+
+// DecodePrefix parses the NFLOG prefix rendered by EncodePrefix
+func DecodePrefix(prefix string) (Tag, error) {
+	parts := strings.SplitN(prefix, prefixSeparator, 4)
+	if len(parts) != 4 {
+		return Tag{}, fmt.Errorf("malformed prefix %q", prefix)
+	}
+	tag := Tag{Direction: parts[1], Policy: parts[3]}
+	for verdict, code := range verdictCodes {
+		if code == parts[0] {
+			tag.Verdict = verdict
+		}
+	}
+	if tag.Verdict == "" {
+		return Tag{}, fmt.Errorf("unknown verdict %q of prefix %q", parts[0], prefix)
+	}
+	rule, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Tag{}, fmt.Errorf("malformed rule index of prefix %q", prefix)
+	}
+	tag.Rule = rule
+	return tag, nil
+}
@@ -0,0 +1,72 @@
+package flowlog
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// attribute types of nflog packets, shared by netlink messages and LINKTYPE_NFLOG captures
+const (
+	nfulaTimestamp = 3
+	nfulaPayload   = 9
+	nfulaPrefix    = 10
+
+	// nlaTypeMask strips the nested and byte order flags of attribute type
+	nlaTypeMask = 0x3fff
+)
+
+// Packet is a packet logged by an NFLOG rule
+type Packet struct {
+	Time    time.Time
+	Prefix  string
+	Payload []byte
+}
+
+// Source provides the packets logged by NFLOG rules. Read blocks until a packet is available and
+// returns io.EOF when the source is exhausted.
+type Source interface {
+	Read() (Packet, error)
+	Close() error
+}
+
+// packetFromAttributes builds packet from the type-length-value attributes of nflog. Attributes are
+// aligned to 4 bytes, their length includes the 4 bytes header.
+func packetFromAttributes(data []byte, byteOrder binary.ByteOrder, defaultTime time.Time) Packet {
+	packet := Packet{Time: defaultTime}
+	for len(data) >= 4 {
+		length := int(byteOrder.Uint16(data[0:2]))
+		attrType := byteOrder.Uint16(data[2:4]) & nlaTypeMask
+		if length < 4 || length > len(data) {
+			break
+		}
+		value := data[4:length]
+		switch attrType {
+		case nfulaPayload:
+			packet.Payload = value
+		case nfulaPrefix:
+			packet.Prefix = trimNull(value)
+		case nfulaTimestamp:
+			// struct nfulnl_msg_packet_timestamp, seconds and microseconds in network byte order
+			if len(value) >= 16 {
+				sec := binary.BigEndian.Uint64(value[0:8])
+				usec := binary.BigEndian.Uint64(value[8:16])
+				packet.Time = time.Unix(int64(sec), int64(usec)*int64(time.Microsecond))
+			}
+		}
+		aligned := (length + 3) &^ 3
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+	return packet
+}
+
+func trimNull(value []byte) string {
+	for i, b := range value {
+		if b == 0 {
+			return string(value[:i])
+		}
+	}
+	return string(value)
+}
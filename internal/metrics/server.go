@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/bamboo-firewall/agent/pkg/net"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Run serves prometheus metrics of default registry on address until ctx is done. address is a loopback
+// "host:port" or a unix socket, a routable address is accepted only when allowRemote
+func Run(ctx context.Context, address string, allowRemote bool) error {
+	listener, err := net.Listen(address, allowRemote)
+	if err != nil {
+		return fmt.Errorf("listen metrics failed: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shutdown metrics server failed", "err", err)
+		}
+	}()
+
+	slog.Info("metrics server listening", "address", address)
+	if err = server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve metrics failed: %w", err)
+	}
+	return nil
+}
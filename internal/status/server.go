@@ -0,0 +1,84 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bamboo-firewall/agent/pkg/net"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+type Server struct {
+	address         string
+	status          *Status
+	livenessTimeout time.Duration
+}
+
+// NewServer serves status on address. address is a loopback "host:port" or a unix socket
+// "unix:///run/bamboo-agent/status.sock"
+func NewServer(address string, status *Status, livenessTimeout time.Duration) *Server {
+	return &Server{
+		address:         address,
+		status:          status,
+		livenessTimeout: livenessTimeout,
+	}
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen(s.address, false)
+	if err != nil {
+		return fmt.Errorf("listen status failed: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/readyz", s.handleReadiness)
+	mux.HandleFunc("/livez", s.handleLiveness)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shutdown status server failed", "err", err)
+		}
+	}()
+
+	slog.Info("status server listening", "address", s.address)
+	if err = server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve status failed: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.status.Snapshot()); err != nil {
+		slog.Warn("write status failed", "err", err)
+	}
+}
+
+func (s *Server) handleReadiness(w http.ResponseWriter, _ *http.Request) {
+	writeProbe(w, s.status.Ready)
+}
+
+func (s *Server) handleLiveness(w http.ResponseWriter, _ *http.Request) {
+	writeProbe(w, func() (bool, string) {
+		return s.status.Live(s.livenessTimeout)
+	})
+}
+
+func writeProbe(w http.ResponseWriter, probe func() (bool, string)) {
+	ok, reason := probe()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write([]byte(reason))
+}
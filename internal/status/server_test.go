@@ -0,0 +1,110 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const livenessTimeout = time.Minute
+
+func TestServerProbes(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(s *Status)
+		readyzCode    int
+		livezCode     int
+		readyzMessage string
+	}{
+		{
+			name:          "not in sync",
+			setup:         func(s *Status) {},
+			readyzCode:    http.StatusServiceUnavailable,
+			livezCode:     http.StatusOK,
+			readyzMessage: "datastore is not in sync",
+		},
+		{
+			name: "in sync",
+			setup: func(s *Status) {
+				s.SetDatastoreInSync(true)
+				s.SetTableApplyResult(generictables.TableFilter, generictables.IPFamily4, nil)
+				s.SetIPSetApplyResult(generictables.IPFamily4, nil)
+				s.MarkDataplaneLoopAlive()
+			},
+			readyzCode:    http.StatusOK,
+			livezCode:     http.StatusOK,
+			readyzMessage: "ok",
+		},
+		{
+			name: "apply failed",
+			setup: func(s *Status) {
+				s.SetDatastoreInSync(true)
+				s.SetTableApplyResult(generictables.TableFilter, generictables.IPFamily4, errors.New("exit status 1"))
+			},
+			readyzCode:    http.StatusServiceUnavailable,
+			livezCode:     http.StatusOK,
+			readyzMessage: "apply table filter-ipv4 failed: exit status 1",
+		},
+		{
+			name: "stale dataplane loop",
+			setup: func(s *Status) {
+				s.SetDatastoreInSync(true)
+				s.SetTableApplyResult(generictables.TableFilter, generictables.IPFamily4, nil)
+				s.lastDataplaneLoopTime = time.Now().Add(-2 * livenessTimeout)
+			},
+			readyzCode:    http.StatusOK,
+			livezCode:     http.StatusServiceUnavailable,
+			readyzMessage: "ok",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			tt.setup(s)
+			server := NewServer("127.0.0.1:0", s, livenessTimeout)
+
+			readyz := httptest.NewRecorder()
+			server.handleReadiness(readyz, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.readyzCode, readyz.Code)
+			assert.Equal(t, tt.readyzMessage, readyz.Body.String())
+
+			livez := httptest.NewRecorder()
+			server.handleLiveness(livez, httptest.NewRequest(http.MethodGet, "/livez", nil))
+			assert.Equal(t, tt.livezCode, livez.Code)
+		})
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	s := New()
+	metadata := &dto.HostEndPointPolicyMetadata{GNPVersions: map[string]uint{"gnp": 2}}
+	s.SetPolicyMetadata(metadata)
+	s.SetFetchResult(nil)
+	s.SetFetchResult(errors.New("api-server is unreachable"))
+	server := NewServer("127.0.0.1:0", s, livenessTimeout)
+
+	recorder := httptest.NewRecorder()
+	server.handleStatus(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var snapshot Snapshot
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&snapshot))
+	assert.Equal(t, metadata, snapshot.PolicyMetadata)
+	assert.Equal(t, "api-server is unreachable", snapshot.LastFetchError)
+	assert.False(t, snapshot.LastFetchTime.IsZero())
+	// the last fetch failed, the last success is older
+	assert.True(t, snapshot.LastSuccessFetchTime.Before(snapshot.LastFetchTime))
+}
+
+func TestLivenessTimeout(t *testing.T) {
+	assert.Equal(t, minLivenessTimeout, LivenessTimeout(5*time.Second))
+	assert.Equal(t, 3*time.Minute, LivenessTimeout(time.Minute))
+}
@@ -0,0 +1,246 @@
+package status
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const (
+	// livenessRefreshIntervals refresh intervals the dataplane loop may miss before being considered stuck
+	livenessRefreshIntervals = 3
+	minLivenessTimeout       = 60 * time.Second
+)
+
+// Status collects what agent thinks it has applied. Every method is safe for concurrent use.
+type Status struct {
+	mu sync.RWMutex
+
+	startedAt time.Time
+
+	policyMetadata *dto.HostEndPointPolicyMetadata
+
+	lastFetchTime        time.Time
+	lastFetchError       string
+	lastSuccessFetchTime time.Time
+
+	datastoreInSync bool
+	// lastDataplaneLoopTime last time the dataplane loop was alive
+	lastDataplaneLoopTime time.Time
+
+	tables map[string]ApplyResult
+	ipsets map[string]ApplyResult
+	chains map[string][]Chain
+	dryRun map[string]DryRunResult
+}
+
+type ApplyResult struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// DryRunResult payload computed but not applied in dry-run mode
+type DryRunResult struct {
+	Time    time.Time `json:"time"`
+	Payload string    `json:"payload"`
+}
+
+type Chain struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Match   string   `json:"match,omitempty"`
+	Action  string   `json:"action,omitempty"`
+	Comment []string `json:"comment,omitempty"`
+}
+
+// Snapshot is the json document served by the status endpoint
+type Snapshot struct {
+	StartedAt            time.Time                       `json:"startedAt"`
+	PolicyMetadata       *dto.HostEndPointPolicyMetadata `json:"policyMetadata"`
+	LastFetchTime        time.Time                       `json:"lastFetchTime"`
+	LastFetchError       string                          `json:"lastFetchError,omitempty"`
+	LastSuccessFetchTime time.Time                       `json:"lastSuccessFetchTime"`
+	DatastoreInSync      bool                            `json:"datastoreInSync"`
+	Tables               map[string]ApplyResult          `json:"tables"`
+	IPSets               map[string]ApplyResult          `json:"ipsets"`
+	Chains               map[string][]Chain              `json:"chains"`
+	DryRun               map[string]DryRunResult         `json:"dryRun,omitempty"`
+}
+
+func New() *Status {
+	return &Status{
+		startedAt: time.Now(),
+		tables:    make(map[string]ApplyResult),
+		ipsets:    make(map[string]ApplyResult),
+		chains:    make(map[string][]Chain),
+		dryRun:    make(map[string]DryRunResult),
+	}
+}
+
+func (s *Status) SetPolicyMetadata(metadata *dto.HostEndPointPolicyMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policyMetadata = metadata
+}
+
+func (s *Status) SetFetchResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFetchTime = time.Now()
+	if err != nil {
+		s.lastFetchError = err.Error()
+		return
+	}
+	s.lastFetchError = ""
+	s.lastSuccessFetchTime = s.lastFetchTime
+}
+
+func (s *Status) SetDatastoreInSync(inSync bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.datastoreInSync = inSync
+}
+
+func (s *Status) MarkDataplaneLoopAlive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDataplaneLoopTime = time.Now()
+}
+
+func (s *Status) SetTableApplyResult(table string, ipVersion int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[familyKey(table, ipVersion)] = newApplyResult(err)
+}
+
+func (s *Status) SetIPSetApplyResult(ipVersion int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ipsets[familyKey("ipset", ipVersion)] = newApplyResult(err)
+}
+
+// SetDryRunPayload records the last payload of component that dry-run mode did not apply
+func (s *Status) SetDryRunPayload(component string, ipVersion int, payload string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dryRun[familyKey(component, ipVersion)] = DryRunResult{Time: time.Now(), Payload: payload}
+}
+
+// SetChains records the chains rendered for ip family
+func (s *Status) SetChains(ipVersion int, chains []*generictables.Chain) {
+	rendered := make([]Chain, 0, len(chains))
+	for _, chain := range chains {
+		c := Chain{Name: chain.Name, Rules: make([]Rule, 0, len(chain.Rules))}
+		for _, rule := range chain.Rules {
+			var r Rule
+			if rule.Match != nil {
+				r.Match = rule.Match.Render()
+			}
+			if rule.Action != nil {
+				r.Action = rule.Action.ToParameter()
+			}
+			r.Comment = rule.Comment
+			c.Rules = append(c.Rules, r)
+		}
+		rendered = append(rendered, c)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chains[familyKey("filter", ipVersion)] = rendered
+}
+
+func (s *Status) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := Snapshot{
+		StartedAt:            s.startedAt,
+		PolicyMetadata:       s.policyMetadata,
+		LastFetchTime:        s.lastFetchTime,
+		LastFetchError:       s.lastFetchError,
+		LastSuccessFetchTime: s.lastSuccessFetchTime,
+		DatastoreInSync:      s.datastoreInSync,
+		Tables:               make(map[string]ApplyResult, len(s.tables)),
+		IPSets:               make(map[string]ApplyResult, len(s.ipsets)),
+		Chains:               make(map[string][]Chain, len(s.chains)),
+	}
+	if len(s.dryRun) > 0 {
+		snapshot.DryRun = make(map[string]DryRunResult, len(s.dryRun))
+		for k, v := range s.dryRun {
+			snapshot.DryRun[k] = v
+		}
+	}
+	for k, v := range s.tables {
+		snapshot.Tables[k] = v
+	}
+	for k, v := range s.ipsets {
+		snapshot.IPSets[k] = v
+	}
+	for k, v := range s.chains {
+		snapshot.Chains[k] = v
+	}
+	return snapshot
+}
+
+// Ready agent received policies and the last apply of every table and ipset succeeded
+func (s *Status) Ready() (bool, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.datastoreInSync {
+		return false, "datastore is not in sync"
+	}
+	if len(s.tables) == 0 {
+		return false, "dataplane is not applied"
+	}
+	for name, result := range s.tables {
+		if result.Error != "" {
+			return false, "apply table " + name + " failed: " + result.Error
+		}
+	}
+	for name, result := range s.ipsets {
+		if result.Error != "" {
+			return false, "apply " + name + " failed: " + result.Error
+		}
+	}
+	return true, "ok"
+}
+
+// LivenessTimeout dataplane loop is considered stuck after missing a few refresh intervals, so that long
+// refresh intervals do not fail liveness
+func LivenessTimeout(dataplaneRefreshInterval time.Duration) time.Duration {
+	return max(livenessRefreshIntervals*dataplaneRefreshInterval, minLivenessTimeout)
+}
+
+// Live the dataplane loop was alive during the last timeout
+func (s *Status) Live(timeout time.Duration) (bool, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lastLoopTime := s.lastDataplaneLoopTime
+	if lastLoopTime.IsZero() {
+		lastLoopTime = s.startedAt
+	}
+	if time.Since(lastLoopTime) > timeout {
+		return false, "dataplane loop is stuck since " + lastLoopTime.Format(time.RFC3339)
+	}
+	return true, "ok"
+}
+
+func newApplyResult(err error) ApplyResult {
+	result := ApplyResult{Time: time.Now()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func familyKey(name string, ipVersion int) string {
+	if ipVersion == generictables.IPFamily6 {
+		return name + "-ipv6"
+	}
+	return name + "-ipv4"
+}
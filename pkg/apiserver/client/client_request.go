@@ -4,8 +4,13 @@ import "github.com/bamboo-firewall/agent/pkg/http"
 
 type apiServer struct {
 	client *http.Client
+	// watchClient has no client timeout, watch requests are bounded by their context
+	watchClient *http.Client
 }
 
 func NewAPIServer(address string) *apiServer {
-	return &apiServer{client: http.NewClient(address)}
+	return &apiServer{
+		client:      http.NewClient(address),
+		watchClient: http.NewClient(address, http.WithTimeout(0)),
+	}
 }
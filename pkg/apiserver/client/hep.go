@@ -1,15 +1,25 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
 	"github.com/bamboo-firewall/agent/pkg/http/ierror"
 )
 
+const watchTimeoutGracePeriod = 10 * time.Second
+
+var (
+	ErrNotModified       = errors.New("policies of host endpoint are not modified")
+	ErrWatchNotSupported = errors.New("api-server does not support watch policies")
+)
+
 func (c *apiServer) FetchHostEndpointPolicy(ctx context.Context, tenantID uint64, ip string) ([]*dto.HostEndpointPolicy, error) {
 	res := c.client.NewRequest().
 		SetSubURL("/api/internal/v1/hostEndpoints/fetchPolicies").
@@ -41,3 +51,60 @@ func (c *apiServer) FetchHostEndpointPolicy(ctx context.Context, tenantID uint64
 	}
 	return output, nil
 }
+
+// WatchHostEndpointPolicy holds the request on api-server until the policies of host differ from the
+// given versions or the timeout elapses. ErrNotModified is returned when nothing changed before the
+// timeout and ErrWatchNotSupported when the api-server has no watch endpoint.
+func (c *apiServer) WatchHostEndpointPolicy(ctx context.Context, tenantID uint64, ip string,
+	metadata dto.HostEndPointPolicyMetadata, timeout time.Duration) ([]*dto.HostEndpointPolicy, error) {
+	body, err := json.Marshal(&dto.WatchHostEndpointPolicyInput{
+		TenantID:       tenantID,
+		IP:             ip,
+		MetaData:       metadata,
+		TimeoutSeconds: int(timeout.Seconds()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal watch input: %w", err)
+	}
+
+	// give api-server time to answer after its own timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout+watchTimeoutGracePeriod)
+	defer cancel()
+	res := c.watchClient.NewRequest().
+		SetSubURL("/api/internal/v1/hostEndpoints/watchPolicies").
+		SetHeader("Content-Type", "application/json").
+		SetMethod(http.MethodPost).
+		SetBody(bytes.NewReader(body)).
+		DoRequest(ctx)
+	if res.Err != nil {
+		return nil, fmt.Errorf("failed to watch policy for host endpoint: %w", res.Err)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, ErrNotModified
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		// a 404 with an error code is a real error of api-server, without it the route does not exist
+		var ierr *ierror.Error
+		if err = json.Unmarshal(res.Body, &ierr); err != nil || ierr == nil || ierr.Code == 0 {
+			return nil, ErrWatchNotSupported
+		}
+		return nil, fmt.Errorf("unexpected status code when watch policy for host endpoint, status code: %d, err: %w", res.StatusCode, ierr)
+	default:
+		var ierr *ierror.Error
+		if err = json.Unmarshal(res.Body, &ierr); err != nil {
+			return nil, fmt.Errorf("undefined err., status code: %d, response: %s. err: %w", res.StatusCode, string(res.Body), err)
+		}
+		if ierr.Code == 0 {
+			return nil, fmt.Errorf("unexpected status code when watch policy for host endpoint, status code: %d, response: %s", res.StatusCode, string(res.Body))
+		}
+		return nil, fmt.Errorf("unexpected status code when watch policy for host endpoint, status code: %d, err: %w", res.StatusCode, ierr)
+	}
+
+	var output []*dto.HostEndpointPolicy
+	if err = json.Unmarshal(res.Body, &output); err != nil {
+		return nil, fmt.Errorf("unexpected response when watch policy for host endpoint, response: %s, err: %w",
+			string(res.Body), err)
+	}
+	return output, nil
+}
@@ -1,5 +1,19 @@
 package dto
 
+const (
+	PolicyTypeIngress = "ingress"
+	PolicyTypeEgress  = "egress"
+	// PolicyTypeForward applies the policy to packets routed through host, e.g. to containers or VMs
+	PolicyTypeForward = "forward"
+)
+
+const (
+	// EndOfChainActionPass hands packets not matched by any rule of policy to the next policy
+	EndOfChainActionPass = "pass"
+	// EndOfChainActionDeny drops packets not matched by any rule of policy
+	EndOfChainActionDeny = "deny"
+)
+
 type GlobalNetworkPolicy struct {
 	ID       string         `json:"id"`
 	UUID     string         `json:"uuid"`
@@ -14,10 +28,14 @@ type PolicyMetadata struct {
 }
 
 type PolicySpec struct {
-	Selector string   `json:"selector"`
-	Types    []string `json:"types"`
-	Ingress  []*Rule  `json:"ingress"`
-	Egress   []*Rule  `json:"egress"`
+	Selector string `json:"selector"`
+	// Order policies with lower order are evaluated first, policies without order are evaluated last
+	Order   *float64 `json:"order"`
+	Types   []string `json:"types"`
+	Ingress []*Rule  `json:"ingress"`
+	Egress  []*Rule  `json:"egress"`
+	// EndOfChainAction applies to packets not matched by any rule, empty falls through to the next policy
+	EndOfChainAction string `json:"endOfChainAction"`
 }
 
 type Rule struct {
@@ -4,10 +4,20 @@ const (
 	ProtocolTCP     = "tcp"
 	ProtocolUDP     = "udp"
 	ProtocolICMP    = "icmp"
+	ProtocolICMPv6  = "icmpv6"
 	ProtocolSCTP    = "sctp"
 	ProtocolUDPLite = "udplite"
 )
 
+const (
+	// RejectWithTCPReset answers tcp connections with a reset, it is only valid for tcp rules
+	RejectWithTCPReset        = "tcp-reset"
+	RejectWithPortUnreachable = "port-unreachable"
+	RejectWithHostUnreachable = "host-unreachable"
+	RejectWithNetUnreachable  = "net-unreachable"
+	RejectWithAdminProhibited = "admin-prohibited"
+)
+
 type HostEndpoint struct {
 	ID          string               `json:"id"`
 	UUID        string               `json:"uuid"`
@@ -48,29 +58,53 @@ type HostEndPointPolicyMetadata struct {
 	GNSVersions map[string]uint `json:"gnsVersions"`
 }
 
+type WatchHostEndpointPolicyInput struct {
+	TenantID uint64                     `json:"tenantID"`
+	IP       string                     `json:"ip"`
+	MetaData HostEndPointPolicyMetadata `json:"metadata"`
+	// TimeoutSeconds how long api-server holds the request when nothing changes
+	TimeoutSeconds int `json:"timeoutSeconds"`
+}
+
 type ParsedGNP struct {
-	UUID          string        `json:"uuid"`
-	Version       uint          `json:"version"`
-	Name          string        `json:"name"`
+	UUID    string `json:"uuid"`
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+	// Order policies with lower order are evaluated first, policies without order are evaluated last
+	Order *float64 `json:"order"`
+	// Types directions(ingress, egress, forward) the policy applies to, empty applies to the directions having rules
+	Types         []string      `json:"types"`
 	InboundRules  []*ParsedRule `json:"inboundRules"`
 	OutboundRules []*ParsedRule `json:"outboundRules"`
+	// ForwardInboundRules and ForwardOutboundRules match packets routed through host, entering and leaving
+	// by the interface of host endpoint
+	ForwardInboundRules  []*ParsedRule `json:"forwardInboundRules"`
+	ForwardOutboundRules []*ParsedRule `json:"forwardOutboundRules"`
+	EndOfChainAction     string        `json:"endOfChainAction"`
 }
 
 type ParsedRule struct {
 	Action             string      `json:"action"`
+	RejectWith         string      `json:"rejectWith"`
 	IPVersion          *int        `json:"ipVersion"`
 	Protocol           interface{} `json:"protocol"`
 	IsProtocolNegative bool        `json:"isProtocolNegative"`
+	ICMPType           *int        `json:"icmpType"`
+	ICMPCode           *int        `json:"icmpCode"`
+	NotICMPType        *int        `json:"notICMPType"`
+	NotICMPCode        *int        `json:"notICMPCode"`
 	SrcNets            []string    `json:"srcNets"`
 	IsSrcNetNegative   bool        `json:"isSrcNetNegative"`
 	SrcGNSUUIDs        []string    `json:"srcGNSUUIDs"`
 	SrcHEPUUIDs        []string    `json:"srcHEPUUIDs"`
+	IsSrcSetNegative   bool        `json:"isSrcSetNegative"`
 	SrcPorts           []string    `json:"srcPorts"`
 	IsSrcPortNegative  bool        `json:"isSrcPortNegative"`
 	DstNets            []string    `json:"dstNets"`
 	IsDstNetNegative   bool        `json:"isDstNetNegative"`
 	DstGNSUUIDs        []string    `json:"dstGNSUUIDs"`
 	DstHEPUUIDs        []string    `json:"dstHEPUUIDs"`
+	IsDstSetNegative   bool        `json:"isDstSetNegative"`
 	DstPorts           []string    `json:"dstPorts"`
 	IsDstPortNegative  bool        `json:"isDstPortNegative"`
 }
@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs the commands managing the dataplane(iptables, ipset, nft). Tests replace it with the
+// in-memory dataplane of package fake, so the reconcile loop runs without root.
+type Executor interface {
+	// LookPath searches for an executable named file
+	LookPath(file string) (string, error)
+	// Run runs name with args, feeding stdin to the command when it is not nil
+	Run(name string, args []string, stdin []byte) (stdout []byte, stderr []byte, err error)
+}
+
+// OS runs commands of the host with os/exec
+type OS struct{}
+
+func (OS) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (OS) Run(name string, args []string, stdin []byte) ([]byte, []byte, error) {
+	var outputBuf, errBuf bytes.Buffer
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = &outputBuf
+	cmd.Stderr = &errBuf
+	err := cmd.Run()
+	return outputBuf.Bytes(), errBuf.Bytes(), err
+}
+
+// CommandLine formats a command for logs
+func CommandLine(name string, args []string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
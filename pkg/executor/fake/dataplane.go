@@ -0,0 +1,248 @@
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bamboo-firewall/agent/pkg/executor"
+)
+
+const (
+	iptablesVersion = "iptables v1.8.7 (nf_tables)"
+)
+
+var (
+	_ executor.Executor = (*Dataplane)(nil)
+
+	errExitStatus = errors.New("exit status 1")
+)
+
+// Dataplane is an in-memory iptables, ipset and nftables dataplane implementing executor.Executor. Restore
+// commands and nft transactions are parsed and applied to the in-memory state, save commands print it the
+// way iptables-save, ipset save and nft list table do. It is safe for concurrent use, as the dataplane
+// applies ipv4 and ipv6 in parallel.
+type Dataplane struct {
+	mu sync.Mutex
+	// tables iptables tables indexed by ip version then table name
+	tables map[int]map[string]*table
+	// sets ipsets of both families, ipset names are global
+	sets map[string]*set
+	// nftTables nftables tables indexed by "family name"
+	nftTables map[string]*nftTable
+	// restoreFailures number of next restore commands failing
+	restoreFailures int
+	// saveFailures number of next save commands failing
+	saveFailures int
+	// restores number of restore commands executed successfully
+	restores int
+}
+
+func NewDataplane() *Dataplane {
+	return &Dataplane{
+		tables:    map[int]map[string]*table{4: {}, 6: {}},
+		sets:      make(map[string]*set),
+		nftTables: make(map[string]*nftTable),
+	}
+}
+
+func (d *Dataplane) LookPath(file string) (string, error) {
+	if _, _, ok := parseIPTablesCommand(file); ok || file == "ipset" || file == "nft" {
+		return "/usr/sbin/" + file, nil
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+func (d *Dataplane) Run(name string, args []string, stdin []byte) ([]byte, []byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name == "nft" && len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return d.save(func() ([]byte, []byte, error) { return d.nftList(args) })
+		case "-f":
+			return d.restore(func() error { return d.nftTransaction(string(stdin)) })
+		}
+	}
+	if name == "ipset" && len(args) > 0 {
+		switch args[0] {
+		case "save":
+			return d.save(func() ([]byte, []byte, error) { return []byte(d.ipsetSave()), nil, nil })
+		case "restore":
+			return d.restore(func() error { return d.ipsetRestore(string(stdin)) })
+		}
+	}
+	ipVersion, subcommand, ok := parseIPTablesCommand(name)
+	if !ok {
+		return nil, []byte(fmt.Sprintf("%s: command not found", name)), errExitStatus
+	}
+	switch {
+	case subcommand == "" && slices.Contains(args, "--version"):
+		return []byte(iptablesVersion + "\n"), nil, nil
+	case subcommand == "save" && len(args) == 2 && args[0] == "-t":
+		return d.save(func() ([]byte, []byte, error) { return []byte(d.iptablesSave(ipVersion, args[1])), nil, nil })
+	case subcommand == "restore" && slices.Contains(args, "--noflush"):
+		return d.restore(func() error { return d.iptablesRestore(ipVersion, string(stdin)) })
+	}
+	return nil, []byte(fmt.Sprintf("%s: unsupported arguments %v", name, args)), errExitStatus
+}
+
+func (d *Dataplane) restore(apply func() error) ([]byte, []byte, error) {
+	if d.restoreFailures > 0 {
+		d.restoreFailures--
+		return nil, []byte("injected failure"), errExitStatus
+	}
+	if err := apply(); err != nil {
+		return nil, []byte(err.Error()), errExitStatus
+	}
+	d.restores++
+	return nil, nil, nil
+}
+
+func (d *Dataplane) save(print func() ([]byte, []byte, error)) ([]byte, []byte, error) {
+	if d.saveFailures > 0 {
+		d.saveFailures--
+		return nil, []byte("injected failure"), errExitStatus
+	}
+	return print()
+}
+
+// FailSaves makes the next count save and list commands fail
+func (d *Dataplane) FailSaves(count int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.saveFailures = count
+}
+
+// FailRestores makes the next count restore commands fail without changing the dataplane
+func (d *Dataplane) FailRestores(count int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.restoreFailures = count
+}
+
+// Restores returns the number of restore commands and nft transactions applied
+func (d *Dataplane) Restores() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.restores
+}
+
+// Chains returns the names of chains of table, built-in chains first
+func (d *Dataplane) Chains(ipVersion int, tableName string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.table(ipVersion, tableName).chainNames()
+}
+
+// Rules returns the rules of chain without the "-A chain" prefix, nil when chain does not exist
+func (d *Dataplane) Rules(ipVersion int, tableName, chainName string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.table(ipVersion, tableName).chains[chainName]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(c.rules)
+}
+
+// AppendRule appends a rule owned by another program to chain, which is created when needed
+func (d *Dataplane) AppendRule(ipVersion int, tableName, chainName, rule string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.table(ipVersion, tableName)
+	if _, ok := t.chains[chainName]; !ok {
+		t.chains[chainName] = &chain{policy: "-"}
+	}
+	t.chains[chainName].rules = append(t.chains[chainName].rules, rule)
+}
+
+// InsertRule inserts a rule owned by another program at index, from 1, of chain like docker or kube-proxy
+// running iptables -I
+func (d *Dataplane) InsertRule(ipVersion int, tableName, chainName string, index int, rule string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.table(ipVersion, tableName).applyCommand(fmt.Sprintf("-I %s %d %s", chainName, index, rule))
+}
+
+// DeleteRule deletes the rule at index, from 1, of chain like an admin running iptables -D
+func (d *Dataplane) DeleteRule(ipVersion int, tableName, chainName string, index int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.table(ipVersion, tableName).applyCommand(fmt.Sprintf("-D %s %d", chainName, index))
+}
+
+// NFTRules returns the rules of chain of nftables table "family name", nil when chain does not exist
+func (d *Dataplane) NFTRules(family, tableName, chainName string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.nftTables[family+" "+tableName]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(t.chains[chainName])
+}
+
+// NFTTables returns the nftables tables as "family name"
+func (d *Dataplane) NFTTables() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return sortedKeys(d.nftTables)
+}
+
+// Sets returns the names of all ipsets
+func (d *Dataplane) Sets() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return sortedKeys(d.sets)
+}
+
+// SetMembers returns the members of ipset name as printed by ipset save, nil when the set does not exist
+func (d *Dataplane) SetMembers(name string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.sets[name]
+	if !ok {
+		return nil
+	}
+	return sortedKeys(s.members)
+}
+
+// parseIPTablesCommand parses iptables commands(iptables, ip6tables-nft-restore, iptables-save...)
+func parseIPTablesCommand(name string) (ipVersion int, subcommand string, ok bool) {
+	ipVersion = 4
+	rest, found := strings.CutPrefix(name, "iptables")
+	if !found {
+		if rest, found = strings.CutPrefix(name, "ip6tables"); !found {
+			return 0, "", false
+		}
+		ipVersion = 6
+	}
+	if mode, found := strings.CutPrefix(rest, "-nft"); found {
+		rest = mode
+	} else {
+		rest = strings.TrimPrefix(rest, "-legacy")
+	}
+	switch rest {
+	case "":
+		return ipVersion, "", true
+	case "-save":
+		return ipVersion, "save", true
+	case "-restore":
+		return ipVersion, "restore", true
+	}
+	return 0, "", false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,136 @@
+package fake
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type set struct {
+	setType string
+	family  string
+	members map[string]struct{}
+}
+
+// ipsetSave prints all sets like ipset save
+func (d *Dataplane) ipsetSave() string {
+	var b strings.Builder
+	for _, name := range sortedKeys(d.sets) {
+		s := d.sets[name]
+		fmt.Fprintf(&b, "create %s %s family %s hashsize 1024 maxelem 65536\n", name, s.setType, s.family)
+		for _, member := range sortedKeys(s.members) {
+			fmt.Fprintf(&b, "add %s %s\n", name, member)
+		}
+	}
+	return b.String()
+}
+
+// ipsetRestore applies input like ipset restore: lines are applied until the first failing line,
+// the changes of previous lines are kept
+func (d *Dataplane) ipsetRestore(input string) error {
+	for lineNumber, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := d.applyIPSetCommand(strings.Fields(line)); err != nil {
+			return fmt.Errorf("ipset v7.15: Error in line %d: %w", lineNumber+1, err)
+		}
+	}
+	return nil
+}
+
+func (d *Dataplane) applyIPSetCommand(fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("missing set name")
+	}
+	command, name := fields[0], fields[1]
+	if command == "create" {
+		if _, ok := d.sets[name]; ok {
+			return fmt.Errorf("set cannot be created: set with the same name already exists")
+		}
+		if len(fields) < 5 || fields[3] != "family" {
+			return fmt.Errorf("syntax error: family is required")
+		}
+		d.sets[name] = &set{setType: fields[2], family: fields[4], members: make(map[string]struct{})}
+		return nil
+	}
+
+	s, ok := d.sets[name]
+	if !ok {
+		return fmt.Errorf("the set with the given name does not exist")
+	}
+	switch command {
+	case "add", "del":
+		if len(fields) < 3 {
+			return fmt.Errorf("missing element")
+		}
+		member, err := normalizeMember(fields[2], s.family)
+		if err != nil {
+			return err
+		}
+		_, exists := s.members[member]
+		if command == "add" {
+			if exists {
+				return fmt.Errorf("element cannot be added to the set: it's already added")
+			}
+			s.members[member] = struct{}{}
+		} else {
+			if !exists {
+				return fmt.Errorf("element cannot be deleted from the set: it's not added")
+			}
+			delete(s.members, member)
+		}
+	case "flush":
+		s.members = make(map[string]struct{})
+	case "destroy":
+		if d.isSetReferenced(name) {
+			return fmt.Errorf("set cannot be destroyed: it is in use by a kernel component")
+		}
+		delete(d.sets, name)
+	default:
+		return fmt.Errorf("unknown command %s", command)
+	}
+	return nil
+}
+
+func (d *Dataplane) isSetReferenced(name string) bool {
+	for _, tables := range d.tables {
+		for _, t := range tables {
+			for _, c := range t.chains {
+				for _, rule := range c.rules {
+					for _, setName := range referencedSets(rule) {
+						if setName == name {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// normalizeMember formats member the way ipset save prints it: networks are masked and single
+// addresses are printed without prefix length
+func normalizeMember(member, family string) (string, error) {
+	address, port, isNetPort := strings.Cut(member, ",")
+	ip, ipnet, err := net.ParseCIDR(address)
+	if err != nil {
+		if ip = net.ParseIP(address); ip == nil {
+			return "", fmt.Errorf("syntax error: cannot parse %s", address)
+		}
+		ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+	}
+	if (ip.To4() != nil) != (family == "inet") {
+		return "", fmt.Errorf("syntax error: %s is not of family %s", address, family)
+	}
+	normalized := ipnet.String()
+	if ones, bits := ipnet.Mask.Size(); ones == bits {
+		normalized = ipnet.IP.String()
+	}
+	if isNetPort {
+		normalized += "," + port
+	}
+	return normalized, nil
+}
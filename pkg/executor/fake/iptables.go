@@ -0,0 +1,267 @@
+package fake
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	builtinChains = map[string][]string{
+		"filter": {"INPUT", "FORWARD", "OUTPUT"},
+	}
+	standardTargets = []string{"ACCEPT", "DROP", "REJECT", "RETURN", "LOG", "NFLOG"}
+)
+
+type table struct {
+	chains map[string]*chain
+	// builtins names of built-in chains, in the order of iptables-save
+	builtins []string
+}
+
+type chain struct {
+	// policy of built-in chains, "-" for user-defined chains
+	policy string
+	// rules without the "-A chain" prefix
+	rules []string
+}
+
+func newTable(name string) *table {
+	t := &table{
+		chains:   make(map[string]*chain),
+		builtins: builtinChains[name],
+	}
+	for _, builtin := range t.builtins {
+		t.chains[builtin] = &chain{policy: "ACCEPT"}
+	}
+	return t
+}
+
+func (t *table) clone() *table {
+	cloned := &table{
+		chains:   make(map[string]*chain, len(t.chains)),
+		builtins: t.builtins,
+	}
+	for name, c := range t.chains {
+		cloned.chains[name] = &chain{policy: c.policy, rules: slices.Clone(c.rules)}
+	}
+	return cloned
+}
+
+func (t *table) chainNames() []string {
+	names := slices.Clone(t.builtins)
+	for _, name := range sortedKeys(t.chains) {
+		if !slices.Contains(t.builtins, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (d *Dataplane) table(ipVersion int, name string) *table {
+	t, ok := d.tables[ipVersion][name]
+	if !ok {
+		t = newTable(name)
+		d.tables[ipVersion][name] = t
+	}
+	return t
+}
+
+// iptablesSave prints table like iptables-save -t
+func (d *Dataplane) iptablesSave(ipVersion int, tableName string) string {
+	t := d.table(ipVersion, tableName)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s\n", tableName)
+	names := t.chainNames()
+	for _, name := range names {
+		fmt.Fprintf(&b, ":%s %s [0:0]\n", name, t.chains[name].policy)
+	}
+	for _, name := range names {
+		for _, rule := range t.chains[name].rules {
+			fmt.Fprintf(&b, "-A %s %s\n", name, rule)
+		}
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// iptablesRestore applies input like iptables-nft-restore --noflush: each table is committed atomically,
+// a failing line discards the whole table. References to chains and sets are checked on COMMIT, so a
+// chain can be deleted before the rules jumping to it in the same transaction.
+func (d *Dataplane) iptablesRestore(ipVersion int, input string) error {
+	var (
+		tableName string
+		working   *table
+	)
+	for lineNumber, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(line, "*"):
+			tableName = line[1:]
+			working = d.table(ipVersion, tableName).clone()
+		case working == nil:
+			err = fmt.Errorf("no table specified")
+		case line == "COMMIT":
+			if err = d.checkReferences(working); err == nil {
+				d.tables[ipVersion][tableName] = working
+				working = nil
+			}
+		case strings.HasPrefix(line, ":"):
+			err = working.declareChain(line[1:])
+		default:
+			err = working.applyCommand(line)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d failed: %s: %w", lineNumber+1, line, err)
+		}
+	}
+	if working != nil {
+		return fmt.Errorf("COMMIT expected at line %d", len(strings.Split(input, "\n")))
+	}
+	return nil
+}
+
+// declareChain creates a chain, or flushes it when it exists
+func (t *table) declareChain(declaration string) error {
+	fields := strings.Fields(declaration)
+	if len(fields) < 2 {
+		return fmt.Errorf("bad chain declaration")
+	}
+	name, policy := fields[0], fields[1]
+	if c, ok := t.chains[name]; ok {
+		c.rules = nil
+		if slices.Contains(t.builtins, name) && policy != "-" {
+			c.policy = policy
+		}
+		return nil
+	}
+	t.chains[name] = &chain{policy: "-"}
+	return nil
+}
+
+func (t *table) applyCommand(line string) error {
+	command, rest, _ := strings.Cut(line, " ")
+	chainName, spec, _ := strings.Cut(rest, " ")
+	if command == "--delete-chain" || command == "-X" {
+		return t.deleteChain(chainName)
+	}
+	if command == "-N" || command == "--new-chain" {
+		if _, ok := t.chains[chainName]; ok {
+			return fmt.Errorf("chain %s already exists", chainName)
+		}
+		t.chains[chainName] = &chain{policy: "-"}
+		return nil
+	}
+
+	c, ok := t.chains[chainName]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", chainName)
+	}
+	switch command {
+	case "-A", "--append":
+		c.rules = append(c.rules, spec)
+	case "-I", "--insert":
+		index, ruleSpec := 1, spec
+		if first, remaining, _ := strings.Cut(spec, " "); isNumber(first) {
+			index, _ = strconv.Atoi(first)
+			ruleSpec = remaining
+		}
+		if index < 1 || index > len(c.rules)+1 {
+			return fmt.Errorf("index of insertion too big")
+		}
+		c.rules = slices.Insert(c.rules, index-1, ruleSpec)
+	case "-R", "--replace":
+		first, ruleSpec, _ := strings.Cut(spec, " ")
+		index, err := strconv.Atoi(first)
+		if err != nil || index < 1 || index > len(c.rules) {
+			return fmt.Errorf("index of replacement too big")
+		}
+		c.rules[index-1] = ruleSpec
+	case "-D", "--delete":
+		if isNumber(spec) {
+			index, _ := strconv.Atoi(spec)
+			if index < 1 || index > len(c.rules) {
+				return fmt.Errorf("index of deletion too big")
+			}
+			c.rules = slices.Delete(c.rules, index-1, index)
+			return nil
+		}
+		index := slices.Index(c.rules, spec)
+		if index < 0 {
+			return fmt.Errorf("bad rule (does a matching rule exist in that chain?)")
+		}
+		c.rules = slices.Delete(c.rules, index, index+1)
+	case "-F", "--flush":
+		c.rules = nil
+	default:
+		return fmt.Errorf("unknown command %s", command)
+	}
+	return nil
+}
+
+func (t *table) deleteChain(name string) error {
+	c, ok := t.chains[name]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", name)
+	}
+	if slices.Contains(t.builtins, name) {
+		return fmt.Errorf("can not delete built-in chain %s", name)
+	}
+	if len(c.rules) > 0 {
+		return fmt.Errorf("directory not empty: chain %s has rules", name)
+	}
+	delete(t.chains, name)
+	return nil
+}
+
+// checkReferences checks that jump targets and sets of rules exist
+func (d *Dataplane) checkReferences(t *table) error {
+	for _, name := range sortedKeys(t.chains) {
+		for _, rule := range t.chains[name].rules {
+			if target := jumpTarget(rule); target != "" && !slices.Contains(standardTargets, target) {
+				if _, ok := t.chains[target]; !ok {
+					return fmt.Errorf("couldn't load target `%s'", target)
+				}
+			}
+			for _, setName := range referencedSets(rule) {
+				if _, ok := d.sets[setName]; !ok {
+					return fmt.Errorf("set %s doesn't exist", setName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jumpTarget returns the target of -j or -g of rule
+func jumpTarget(rule string) string {
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "-j" || fields[i] == "-g" || fields[i] == "--jump" || fields[i] == "--goto" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// referencedSets returns the ipsets matched by rule
+func referencedSets(rule string) []string {
+	var sets []string
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "--match-set" {
+			sets = append(sets, fields[i+1])
+		}
+	}
+	return sets
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,109 @@
+package fake
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// nftTable is a nftables table with chains only, sets are not supported
+type nftTable struct {
+	// chains rules of chains in the order of nft list table
+	chains map[string][]string
+	order  []string
+}
+
+func (t *nftTable) clone() *nftTable {
+	c := &nftTable{chains: make(map[string][]string, len(t.chains)), order: slices.Clone(t.order)}
+	for name, rules := range t.chains {
+		c.chains[name] = slices.Clone(rules)
+	}
+	return c
+}
+
+// nftList prints table the way nft list table does
+func (d *Dataplane) nftList(args []string) ([]byte, []byte, error) {
+	if len(args) != 4 || args[1] != "table" {
+		return nil, []byte(fmt.Sprintf("nft: unsupported arguments %v", args)), errExitStatus
+	}
+	key := args[2] + " " + args[3]
+	t, ok := d.nftTables[key]
+	if !ok {
+		return nil, []byte("Error: No such file or directory"), errExitStatus
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "table %s {\n", key)
+	for _, name := range t.order {
+		fmt.Fprintf(&b, "\tchain %s {\n", name)
+		for _, rule := range t.chains[name] {
+			fmt.Fprintf(&b, "\t\t%s\n", rule)
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return []byte(b.String()), nil, nil
+}
+
+// nftTransaction applies the commands of nft -f atomically, a failed command discards all of them
+func (d *Dataplane) nftTransaction(input string) error {
+	tables := make(map[string]*nftTable, len(d.nftTables))
+	for key, t := range d.nftTables {
+		tables[key] = t.clone()
+	}
+	for _, line := range strings.Split(input, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 4 {
+			return fmt.Errorf("syntax error: %s", line)
+		}
+		command, key := fields[0]+" "+fields[1], fields[2]+" "+fields[3]
+		switch command {
+		case "add table":
+			if _, ok := tables[key]; !ok {
+				tables[key] = &nftTable{chains: make(map[string][]string)}
+			}
+			continue
+		case "delete table":
+			if _, ok := tables[key]; !ok {
+				return fmt.Errorf("no such file or directory: %s", line)
+			}
+			delete(tables, key)
+			continue
+		}
+		t, ok := tables[key]
+		if !ok || len(fields) < 5 {
+			return fmt.Errorf("no such file or directory: %s", line)
+		}
+		name := fields[4]
+		_, exists := t.chains[name]
+		switch command {
+		case "add chain":
+			if !exists {
+				t.chains[name] = []string{}
+				t.order = append(t.order, name)
+			}
+		case "flush chain", "add rule", "delete chain":
+			if !exists {
+				return fmt.Errorf("no such file or directory: %s", line)
+			}
+			switch command {
+			case "flush chain":
+				t.chains[name] = []string{}
+			case "add rule":
+				t.chains[name] = append(t.chains[name], strings.Join(fields[5:], " "))
+			default:
+				if len(t.chains[name]) > 0 {
+					return fmt.Errorf("device or resource busy: %s", line)
+				}
+				delete(t.chains, name)
+				t.order = slices.DeleteFunc(t.order, func(n string) bool { return n == name })
+			}
+		default:
+			return fmt.Errorf("unsupported command: %s", line)
+		}
+	}
+	d.nftTables = tables
+	return nil
+}
@@ -1,14 +1,32 @@
 package generictables
 
+import "strings"
+
 type ActionFactory interface {
 	Jump(target string) Action
 	Allow() Action
 	Drop() Action
-	Log(prefix string) Action
+	Reject(with string) Action
+	// Log logs packets with prefix at syslog level(0 emerg to 7 debug)
+	Log(prefix string, level uint8) Action
+	// NFLog sends packets to userspace listeners of netlink group, tagged with prefix
+	NFLog(group uint16, prefix string) Action
 	Return() Action
+	// SetMaskedMark sets the bits of mask in the packet mark to the bits of mark, other bits are kept
+	SetMaskedMark(mark, mask uint32) Action
 }
 
 type Action interface {
 	ToParameter() string
 	String() string
 }
+
+// LogPrefix makes prefix fit in maxLength with a trailing space separating it from the logged packet.
+// Double quotes are removed because the prefix is quoted in rules.
+func LogPrefix(prefix string, maxLength int) string {
+	prefix = strings.TrimRight(strings.ReplaceAll(prefix, `"`, ""), " ")
+	if len(prefix) > maxLength-1 {
+		prefix = prefix[:maxLength-1]
+	}
+	return prefix + " "
+}
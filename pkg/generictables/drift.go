@@ -0,0 +1,66 @@
+package generictables
+
+import "sort"
+
+const (
+	DriftChainMissing    = "chain-missing"
+	DriftUnexpectedChain = "unexpected-chain"
+	DriftRuleMissing     = "rule-missing"
+	DriftRuleChanged     = "rule-changed"
+	DriftUnexpectedRule  = "unexpected-rule"
+)
+
+// Drift is a difference between the rules of the last apply and the rules in dataplane, made outside of agent
+type Drift struct {
+	Chain string
+	// Index position of the rule in chain from 1, 0 when the whole chain drifted
+	Index int
+	// Expected hash of the rule we applied, empty when we did not apply a rule there
+	Expected string
+	// Actual hash of the rule in dataplane, empty when the rule is missing or not ours
+	Actual string
+	Reason string
+}
+
+// DiffChainHashes returns the drifts of actual chains from the expected chains, both indexed by chain name.
+// Chains of actual that are not expected are drifts too, so actual must only contain our chains.
+func DiffChainHashes(expected, actual map[string][]string) []Drift {
+	var drifts []Drift
+	for _, chainName := range sortedChainNames(expected) {
+		expectedHashes := expected[chainName]
+		actualHashes, ok := actual[chainName]
+		if !ok {
+			drifts = append(drifts, Drift{Chain: chainName, Reason: DriftChainMissing})
+			continue
+		}
+		for i := 0; i < max(len(expectedHashes), len(actualHashes)); i++ {
+			drift := Drift{Chain: chainName, Index: i + 1}
+			switch {
+			case i >= len(actualHashes):
+				drift.Expected, drift.Reason = expectedHashes[i], DriftRuleMissing
+			case i >= len(expectedHashes):
+				drift.Actual, drift.Reason = actualHashes[i], DriftUnexpectedRule
+			case expectedHashes[i] != actualHashes[i]:
+				drift.Expected, drift.Actual, drift.Reason = expectedHashes[i], actualHashes[i], DriftRuleChanged
+			default:
+				continue
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+	for _, chainName := range sortedChainNames(actual) {
+		if _, ok := expected[chainName]; !ok {
+			drifts = append(drifts, Drift{Chain: chainName, Reason: DriftUnexpectedChain})
+		}
+	}
+	return drifts
+}
+
+func sortedChainNames(chains map[string][]string) []string {
+	names := make([]string, 0, len(chains))
+	for name := range chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
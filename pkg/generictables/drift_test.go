@@ -0,0 +1,48 @@
+package generictables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffChainHashes(t *testing.T) {
+	expected := map[string][]string{
+		"BAMBOO-INPUT":  {"a", "b", "c"},
+		"BAMBOO-PI-web": {"d"},
+		"BAMBOO-PI-db":  {"e"},
+	}
+
+	tests := []struct {
+		name   string
+		actual map[string][]string
+		drifts []Drift
+	}{
+		{
+			name:   "in sync",
+			actual: map[string][]string{"BAMBOO-INPUT": {"a", "b", "c"}, "BAMBOO-PI-web": {"d"}, "BAMBOO-PI-db": {"e"}},
+		},
+		{
+			name:   "rule edited, inserted and deleted",
+			actual: map[string][]string{"BAMBOO-INPUT": {"a", "", "c", ""}, "BAMBOO-PI-web": {}, "BAMBOO-PI-db": {"e"}},
+			drifts: []Drift{
+				{Chain: "BAMBOO-INPUT", Index: 2, Expected: "b", Reason: DriftRuleChanged},
+				{Chain: "BAMBOO-INPUT", Index: 4, Reason: DriftUnexpectedRule},
+				{Chain: "BAMBOO-PI-web", Index: 1, Expected: "d", Reason: DriftRuleMissing},
+			},
+		},
+		{
+			name:   "chain deleted and added",
+			actual: map[string][]string{"BAMBOO-INPUT": {"a", "b", "c"}, "BAMBOO-PI-web": {"d"}, "BAMBOO-PI-x": {}},
+			drifts: []Drift{
+				{Chain: "BAMBOO-PI-db", Reason: DriftChainMissing},
+				{Chain: "BAMBOO-PI-x", Reason: DriftUnexpectedChain},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.drifts, DiffChainHashes(expected, tt.actual))
+		})
+	}
+}
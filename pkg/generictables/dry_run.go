@@ -0,0 +1,36 @@
+package generictables
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// DryRun replaces the execution of restore payloads in observe-only mode. The payloads are computed
+// against the rules read from dataplane, so they are the diff between dataplane and the desired state.
+type DryRun struct {
+	mu sync.Mutex
+	// report receives every skipped payload, e.g. to expose it on the status endpoint
+	report func(payload string)
+	// lastPayload avoids logging the same payload on every refresh, dataplane never converges in dry-run
+	lastPayload string
+}
+
+func NewDryRun(report func(payload string)) *DryRun {
+	return &DryRun{report: report}
+}
+
+// Skip logs payload of component instead of applying it
+func (d *DryRun) Skip(component string, ipVersion int, payload []byte) {
+	d.mu.Lock()
+	changed := d.lastPayload != string(payload)
+	d.lastPayload = string(payload)
+	d.mu.Unlock()
+
+	if changed {
+		slog.Info("dry-run: skip applying to dataplane", "component", component, "ipVersion", ipVersion,
+			"payload", string(payload))
+	}
+	if d.report != nil {
+		d.report(string(payload))
+	}
+}
@@ -19,8 +19,25 @@ type MatchCriteria interface {
 	NotSourceIPSet(name string) MatchCriteria
 	DestIPSet(name string) MatchCriteria
 	NotDestIPSet(name string) MatchCriteria
+	// DestNetPortIPSet matches the destination address and port against a set of net,port members
+	DestNetPortIPSet(name string) MatchCriteria
 	SourcePorts(ports []string) MatchCriteria
 	NotSourcePorts(ports []string) MatchCriteria
 	DestPorts(ports []string) MatchCriteria
 	NotDestPorts(ports []string) MatchCriteria
+	ICMPV4Type(icmpType uint8) MatchCriteria
+	NotICMPV4Type(icmpType uint8) MatchCriteria
+	ICMPV4TypeAndCode(icmpType, code uint8) MatchCriteria
+	NotICMPV4TypeAndCode(icmpType, code uint8) MatchCriteria
+	ICMPV6Type(icmpType uint8) MatchCriteria
+	NotICMPV6Type(icmpType uint8) MatchCriteria
+	ICMPV6TypeAndCode(icmpType, code uint8) MatchCriteria
+	NotICMPV6TypeAndCode(icmpType, code uint8) MatchCriteria
+	// Limit matches until rate(e.g. 10/minute) is reached, burst is the initial number of packets matched
+	Limit(rate string, burst int) MatchCriteria
+	InInterface(name string) MatchCriteria
+	OutInterface(name string) MatchCriteria
+	// MarkMatchesWithMask matches packets whose mark bits of mask equal mark
+	MarkMatchesWithMask(mark, mask uint32) MatchCriteria
+	NotMarkMatchesWithMask(mark, mask uint32) MatchCriteria
 }
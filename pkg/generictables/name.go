@@ -0,0 +1,26 @@
+package generictables
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// shortHashLength number of hex characters of the uuid hash in names, 32 bits
+const shortHashLength = 8
+
+// ShortHash returns a short hash of uuid, usable in chain and set names
+func ShortHash(uuid string) string {
+	sum := sha256.Sum256([]byte(uuid))
+	return hex.EncodeToString(sum[:])[:shortHashLength]
+}
+
+// NameWithHash returns name suffixed by the short hash of uuid, name is truncated so that the result fits
+// maxLength. Names of objects with different uuid are practically unique, even when their names share a long
+// prefix, but the 32 bits hash does not rule out collisions.
+func NameWithHash(name, uuid string, maxLength int) string {
+	suffix := "-" + ShortHash(uuid)
+	if len(name)+len(suffix) > maxLength {
+		name = name[:maxLength-len(suffix)]
+	}
+	return name + suffix
+}
@@ -0,0 +1,19 @@
+package generictables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameWithHash(t *testing.T) {
+	short := NameWithHash("BAMBOO-PI-ssh", "uuid-1", 28)
+	assert.Equal(t, "BAMBOO-PI-ssh-"+ShortHash("uuid-1"), short)
+
+	long1 := NameWithHash("BAMBOO-PI-allow-monitoring-from-office", "uuid-1", 28)
+	long2 := NameWithHash("BAMBOO-PI-allow-monitoring-from-home", "uuid-2", 28)
+	assert.Len(t, long1, 28)
+	assert.Len(t, long2, 28)
+	assert.NotEqual(t, long1, long2)
+	assert.Equal(t, long1, NameWithHash("BAMBOO-PI-allow-monitoring-from-office", "uuid-1", 28))
+}
@@ -2,28 +2,62 @@ package generictables
 
 const (
 	HashPrefix = "bamboo:"
-	LogPrefix  = "[bambooFW] "
 
 	TableFilter = "filter"
 
-	DefaultChainInput  = "INPUT"
-	DefaultChainOutput = "OUTPUT"
+	DefaultChainInput   = "INPUT"
+	DefaultChainOutput  = "OUTPUT"
+	DefaultChainForward = "FORWARD"
 
 	ChainNamePrefix = "BAMBOO-"
 
-	OurDefaultInputChain  = ChainNamePrefix + DefaultChainInput
-	OurDefaultOutputChain = ChainNamePrefix + DefaultChainOutput
+	OurDefaultInputChain   = ChainNamePrefix + DefaultChainInput
+	OurDefaultOutputChain  = ChainNamePrefix + DefaultChainOutput
+	OurDefaultForwardChain = ChainNamePrefix + DefaultChainForward
 
 	OurInputChainPrefix  = ChainNamePrefix + "PI-"
 	OurOutputChainPrefix = ChainNamePrefix + "PO-"
 
+	// OurForwardInputChainPrefix and OurForwardOutputChainPrefix prefix chains of policies for packets routed
+	// through host, entering and leaving by host endpoint
+	OurForwardInputChainPrefix  = ChainNamePrefix + "PFI-"
+	OurForwardOutputChainPrefix = ChainNamePrefix + "PFO-"
+
+	// OurHostEndpointInputChainPrefix and OurHostEndpointOutputChainPrefix prefix chains of host endpoints
+	// bound to an interface, suffixed by the interface name
+	OurHostEndpointInputChainPrefix  = ChainNamePrefix + "HI-"
+	OurHostEndpointOutputChainPrefix = ChainNamePrefix + "HO-"
+	// OurHostEndpointForwardInputChainPrefix and OurHostEndpointForwardOutputChainPrefix prefix chains of forwarded
+	// packets entering and leaving by the interface of host endpoints
+	OurHostEndpointForwardInputChainPrefix  = ChainNamePrefix + "HFI-"
+	OurHostEndpointForwardOutputChainPrefix = ChainNamePrefix + "HFO-"
+
 	IPFamily4 = 4
 	IPFamily6 = 6
 )
 
+const (
+	RulePositionFirst = "first"
+	RulePositionLast  = "last"
+	RulePositionAfter = "after"
+)
+
+// RulePosition where our rule is placed in a default chain shared with other programs. A first rule is inserted
+// on top, rules other programs insert above it later are tolerated.
+type RulePosition struct {
+	Kind string
+	// AfterChain target of the jump our rule follows, for kind after. Our rule is last when no rule jumps to it
+	AfterChain string
+}
+
 type Table interface {
+	GetName() string
+	GetIPVersion() int
 	SetDefaultRuleOfDefaultChain(chainName string, rule Rule)
 	UpdateChains(chains []*Chain)
 	NeedClean()
-	Apply()
+	// Apply writes the desired state to dataplane, returns the error of the last attempt
+	Apply() error
+	// CheckDrift returns the changes made to our rules outside of agent since the last apply
+	CheckDrift() ([]Drift, error)
 }
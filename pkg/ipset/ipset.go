@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bamboo-firewall/agent/pkg/executor"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 	"github.com/bamboo-firewall/agent/pkg/net"
 )
@@ -22,6 +24,11 @@ const (
 
 const ipsetCmd = "ipset"
 
+const (
+	setTypeNet     = "hash:net"
+	setTypeNetPort = "hash:net,port"
+)
+
 type IPSet struct {
 	ipVersion int
 	// setFromDatastore network sets from datastore
@@ -40,15 +47,21 @@ type IPSet struct {
 	inetVersion string
 
 	ipsetCmd string
+	// executor runs ipset commands
+	executor executor.Executor
+
+	// dryRun is set in observe-only mode, restore payloads are reported instead of executed
+	dryRun *generictables.DryRun
+	// destroyDryRun reports the payloads destroying unused sets, apart from dryRun so that both payloads
+	// are kept instead of replacing each other every refresh
+	destroyDryRun *generictables.DryRun
 }
 
-func NewIPSet(ipVersion int) (*IPSet, error) {
-	if err := checkIPSetCmd(); err != nil {
-		return nil, err
-	}
+func NewIPSet(ipVersion int, opts ...option) (*IPSet, error) {
 	set := &IPSet{
 		ourMemberRegex: regexp.MustCompile(`^add (` + namePrefix + `[a-zA-Z0-9_-]+) (\S+)(.*)$`),
 		ipsetCmd:       ipsetCmd,
+		executor:       executor.OS{},
 	}
 
 	if ipVersion == generictables.IPFamily6 {
@@ -58,12 +71,18 @@ func NewIPSet(ipVersion int) (*IPSet, error) {
 		set.inetVersion = inetV4
 		set.ipVersion = generictables.IPFamily4
 	}
+	for _, opt := range opts {
+		opt(set)
+	}
+	if err := set.checkIPSetCmd(); err != nil {
+		return nil, err
+	}
 	set.ourSetRegex = regexp.MustCompile(fmt.Sprintf(`^create (%s[a-zA-Z0-9_-]+) ([a-z:,]+) (family) (%s) (.*)$`, namePrefix, set.inetVersion))
 	return set, nil
 }
 
-func checkIPSetCmd() error {
-	_, err := exec.LookPath(ipsetCmd)
+func (i *IPSet) checkIPSetCmd() error {
+	_, err := i.executor.LookPath(i.ipsetCmd)
 	if err != nil {
 		return errors.New("ipset not found in $PATH")
 	}
@@ -78,7 +97,7 @@ func (i *IPSet) UpdateIPSet(ipset map[string]map[string]struct{}) {
 	i.setFromDatastore = ipset
 }
 
-func (i *IPSet) Apply() {
+func (i *IPSet) Apply() error {
 	if !i.inSyncWithDataplane {
 		i.loadFromDataplane()
 	}
@@ -86,8 +105,9 @@ func (i *IPSet) Apply() {
 	retries := 3
 	retryDelay := 100 * time.Millisecond
 
+	var err error
 	for {
-		err := i.apply()
+		err = i.apply()
 		if err != nil {
 			slog.Warn("apply ipset failed. Retrying", "err", err, "inet", i.inetVersion)
 			if retries > 0 {
@@ -96,6 +116,7 @@ func (i *IPSet) Apply() {
 				retryDelay *= 2
 			} else {
 				slog.Error("apply ipset fail after retry.", "err", err, "inet", i.inetVersion)
+				applyFailures.WithLabelValues(strconv.Itoa(i.ipVersion)).Inc()
 				break
 			}
 			continue
@@ -103,6 +124,7 @@ func (i *IPSet) Apply() {
 		break
 	}
 	i.inSyncWithDataplane = false
+	return err
 }
 
 func (i *IPSet) apply() error {
@@ -122,7 +144,7 @@ func (i *IPSet) apply() error {
 	for name, members := range i.setFromDatastore {
 		// create ipset
 		if _, ok := cloneSetFromDataplane[name]; !ok {
-			buf.WriteString(fmt.Sprintf("create %s hash:net family %s\n", name, i.inetVersion))
+			buf.WriteString(fmt.Sprintf("create %s %s family %s\n", name, setTypeOf(members), i.inetVersion))
 		}
 		// create new members for ipset
 		for member := range members {
@@ -150,7 +172,7 @@ func (i *IPSet) apply() error {
 	if buf.Len() == 0 {
 		return nil
 	}
-	return i.execRestore(buf)
+	return i.execRestore(buf, i.dryRun)
 }
 
 func (i *IPSet) CleanUnusedSet() {
@@ -179,6 +201,41 @@ func (i *IPSet) CleanUnusedSet() {
 	}
 }
 
+// Clean destroys all our ipsets of this family, whatever the sets from datastore are
+func (i *IPSet) Clean() error {
+	i.loadFromDataplane()
+	if !i.inSyncWithDataplane {
+		return errors.New("get ipsets from dataplane failed")
+	}
+	i.inSyncWithDataplane = false
+	i.unusedSet = make(map[string]struct{})
+	for name := range i.setFromDataplane {
+		i.unusedSet[name] = struct{}{}
+	}
+
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	var err error
+	for {
+		err = i.cleanUnusedSet()
+		if err != nil {
+			slog.Warn("destroy ipset failed. Retrying", "err", err, "inet", i.inetVersion)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				slog.Error("destroy ipset fail after retry.", "err", err, "inet", i.inetVersion)
+				break
+			}
+			continue
+		}
+		break
+	}
+	return err
+}
+
 func (i *IPSet) cleanUnusedSet() error {
 	slog.Debug("start cleaning unused set", "unusedSet", i.unusedSet, "inet", i.inetVersion)
 	defer slog.Debug("finish clean unused set", "inet", i.inetVersion)
@@ -189,23 +246,30 @@ func (i *IPSet) cleanUnusedSet() error {
 	if buf.Len() == 0 {
 		return nil
 	}
-	return i.execRestore(buf)
+	dryRun := i.destroyDryRun
+	if dryRun == nil {
+		dryRun = i.dryRun
+	}
+	return i.execRestore(buf, dryRun)
 }
 
-func (i *IPSet) execRestore(buf *bytes.Buffer) error {
+func (i *IPSet) execRestore(buf *bytes.Buffer, dryRun *generictables.DryRun) error {
 	slog.Debug("start exec restore", "inet", i.inetVersion)
 	defer slog.Debug("finish exec restore", "inet", i.inetVersion)
 	contentBytes := buf.Next(buf.Len())
+	if dryRun != nil {
+		dryRun.Skip(i.ipsetCmd, i.ipVersion, contentBytes)
+		return nil
+	}
 
-	var outputBuf, errBuf bytes.Buffer
-	cmd := exec.Command(i.ipsetCmd, "restore")
-	slog.Debug("exec restore", "cmd", cmd.String(), "content", string(contentBytes), "inet", i.inetVersion)
-	cmd.Stdin = bytes.NewReader(contentBytes)
-	cmd.Stdout = &outputBuf
-	cmd.Stderr = &errBuf
-	err := cmd.Run()
-	if errBuf.Len() > 0 || err != nil {
-		return fmt.Errorf("restore failed. stderr: %s. err: %w", errBuf.String(), err)
+	args := []string{"restore"}
+	slog.Debug("exec restore", "cmd", executor.CommandLine(i.ipsetCmd, args), "content", string(contentBytes), "inet", i.inetVersion)
+	startTime := time.Now()
+	_, stderr, err := i.executor.Run(i.ipsetCmd, args, contentBytes)
+	restoreDurationSeconds.WithLabelValues(strconv.Itoa(i.ipVersion)).Observe(time.Since(startTime).Seconds())
+	if len(stderr) > 0 || err != nil {
+		restoreErrors.WithLabelValues(strconv.Itoa(i.ipVersion)).Inc()
+		return fmt.Errorf("restore failed. stderr: %s. err: %w", stderr, err)
 	}
 	return nil
 }
@@ -244,30 +308,28 @@ func (i *IPSet) getIPSetFromDataplane() (map[string]map[string]struct{}, error)
 }
 
 func (i *IPSet) attemptToGetIPSetFromDataplane() (map[string]map[string]struct{}, error) {
-	cmd := exec.Command(i.ipsetCmd, "save")
-	stdout, err := cmd.StdoutPipe()
+	stdout, stderr, err := i.executor.Run(i.ipsetCmd, []string{"save"}, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error stdout from cmd: %w", err)
-	}
-	if err = cmd.Start(); err != nil {
-		return nil, fmt.Errorf("error starting cmd: %w", err)
+		return nil, fmt.Errorf("error run cmd: %w. stderr: %s", err, stderr)
 	}
-	ipsets, err := i.readIPSetFrom(stdout)
+	ipsets, err := i.readIPSetFrom(bytes.NewReader(stdout))
 	if err != nil {
-		if errKill := cmd.Process.Kill(); errKill != nil {
-			err = fmt.Errorf("scanner error: %w. then kill process error: %w", err, errKill)
-		} else {
-			err = fmt.Errorf("error scanner: %w", err)
-		}
-		return nil, err
-	}
-	if err = cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("error wait cmd: %w", err)
+		return nil, fmt.Errorf("error scanner: %w", err)
 	}
 	return ipsets, nil
 }
 
-func (i *IPSet) readIPSetFrom(r io.ReadCloser) (map[string]map[string]struct{}, error) {
+// setTypeOf returns hash:net,port for sets of net,port members, e.g. 10.0.0.0/8,tcp:80, hash:net otherwise
+func setTypeOf(members map[string]struct{}) string {
+	for member := range members {
+		if IsNetPortMember(member) {
+			return setTypeNetPort
+		}
+	}
+	return setTypeNet
+}
+
+func (i *IPSet) readIPSetFrom(r io.Reader) (map[string]map[string]struct{}, error) {
 	ipsets := make(map[string]map[string]struct{})
 
 	scanner := bufio.NewScanner(r)
@@ -285,12 +347,17 @@ func (i *IPSet) readIPSetFrom(r io.ReadCloser) (map[string]map[string]struct{},
 			if ipsets[captures[1]] == nil {
 				continue
 			}
-			_, ipnet, err := net.ParseCIDROrIP(captures[2])
+			ip, port, isNetPort := strings.Cut(captures[2], ",")
+			_, ipnet, err := net.ParseCIDROrIP(ip)
 			if err != nil {
 				slog.Warn("parse ip false", "ip", captures[2], "err", err, "inet", i.inetVersion)
 				continue
 			}
-			ipsets[captures[1]][ipnet.String()] = struct{}{}
+			member := ipnet.String()
+			if isNetPort {
+				member += "," + port
+			}
+			ipsets[captures[1]][member] = struct{}{}
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -0,0 +1,30 @@
+package ipset
+
+import (
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+type option func(*IPSet)
+
+// WithDryRun computes the restore payloads without executing ipset restore, nil applies them as usual
+func WithDryRun(dryRun *generictables.DryRun) option {
+	return func(i *IPSet) {
+		i.dryRun = dryRun
+	}
+}
+
+// WithDestroyDryRun reports the restore payloads destroying unused sets apart from WithDryRun, which
+// reports them too when it is not set
+func WithDestroyDryRun(dryRun *generictables.DryRun) option {
+	return func(i *IPSet) {
+		i.destroyDryRun = dryRun
+	}
+}
+
+// WithExecutor runs ipset commands with e instead of the commands of host
+func WithExecutor(e executor.Executor) option {
+	return func(i *IPSet) {
+		i.executor = e
+	}
+}
@@ -0,0 +1,114 @@
+package ipset
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+func TestIPSetApply(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	ipset, err := NewIPSet(generictables.IPFamily4, WithExecutor(dataplane))
+	require.NoError(t, err)
+
+	// initial apply creates sets
+	ipset.UpdateIPSet(map[string]map[string]struct{}{
+		"BAMBOO-gnsv4-web": {"10.0.0.1/32": {}, "10.1.0.0/16": {}},
+		"BAMBOO-gnsv4-db":  {"10.2.0.1/32": {}},
+	})
+	require.NoError(t, ipset.Apply())
+	assert.Equal(t, []string{"BAMBOO-gnsv4-db", "BAMBOO-gnsv4-web"}, dataplane.Sets())
+	assert.Equal(t, []string{"10.0.0.1", "10.1.0.0/16"}, dataplane.SetMembers("BAMBOO-gnsv4-web"))
+
+	// applying the same sets is a no-op
+	restores := dataplane.Restores()
+	require.NoError(t, ipset.Apply())
+	assert.Equal(t, restores, dataplane.Restores())
+
+	// members are added and deleted, unused sets are destroyed by CleanUnusedSet
+	ipset.UpdateIPSet(map[string]map[string]struct{}{
+		"BAMBOO-gnsv4-web": {"10.0.0.1/32": {}, "10.0.0.2/32": {}},
+	})
+	require.NoError(t, ipset.Apply())
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, dataplane.SetMembers("BAMBOO-gnsv4-web"))
+	assert.Equal(t, []string{"BAMBOO-gnsv4-db", "BAMBOO-gnsv4-web"}, dataplane.Sets())
+	ipset.CleanUnusedSet()
+	assert.Equal(t, []string{"BAMBOO-gnsv4-web"}, dataplane.Sets())
+}
+
+func TestIPSetClean(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	ipsetV4, err := NewIPSet(generictables.IPFamily4, WithExecutor(dataplane))
+	require.NoError(t, err)
+	ipsetV6, err := NewIPSet(generictables.IPFamily6, WithExecutor(dataplane))
+	require.NoError(t, err)
+	ipsetV4.UpdateIPSet(map[string]map[string]struct{}{"BAMBOO-gnsv4-web": {"10.0.0.1/32": {}}})
+	ipsetV6.UpdateIPSet(map[string]map[string]struct{}{"BAMBOO-gnsv6-web": {"2001:db8::1/128": {}}})
+	require.NoError(t, ipsetV4.Apply())
+	require.NoError(t, ipsetV6.Apply())
+
+	// Clean only destroys the sets of its family
+	require.NoError(t, ipsetV4.Clean())
+	assert.Equal(t, []string{"BAMBOO-gnsv6-web"}, dataplane.Sets())
+}
+
+func TestIPSetDryRun(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	existing, err := NewIPSet(generictables.IPFamily4, WithExecutor(dataplane))
+	require.NoError(t, err)
+	existing.UpdateIPSet(map[string]map[string]struct{}{"BAMBOO-gnsv4-db": {"10.2.0.1/32": {}}})
+	require.NoError(t, existing.Apply())
+	restores := dataplane.Restores()
+
+	payloads := make(map[string]string)
+	report := func(kind string) *generictables.DryRun {
+		return generictables.NewDryRun(func(payload string) { payloads[kind] = payload })
+	}
+	ipset, err := NewIPSet(generictables.IPFamily4, WithExecutor(dataplane),
+		WithDryRun(report("apply")), WithDestroyDryRun(report("destroy")))
+	require.NoError(t, err)
+	ipset.UpdateIPSet(map[string]map[string]struct{}{"BAMBOO-gnsv4-web": {"10.0.0.1/32": {}}})
+
+	// every refresh computes the same payloads against dataplane, ipset restore is never run
+	for range 2 {
+		require.NoError(t, ipset.Apply())
+		ipset.CleanUnusedSet()
+		assert.Contains(t, payloads["apply"], "create BAMBOO-gnsv4-web hash:net family inet")
+		assert.Contains(t, payloads["apply"], "add BAMBOO-gnsv4-web 10.0.0.1/32")
+		assert.Equal(t, "destroy BAMBOO-gnsv4-db\n", payloads["destroy"])
+	}
+	assert.Equal(t, restores, dataplane.Restores())
+	assert.Equal(t, []string{"BAMBOO-gnsv4-db"}, dataplane.Sets())
+
+	// Clean reports the destroyed sets without destroying them
+	require.NoError(t, ipset.Clean())
+	assert.Equal(t, restores, dataplane.Restores())
+	assert.Equal(t, []string{"BAMBOO-gnsv4-db"}, dataplane.Sets())
+}
+
+func TestReadIPSetFrom(t *testing.T) {
+	const saveOutput = `create BAMBOO-gnsv4-web hash:net family inet hashsize 1024 maxelem 65536
+add BAMBOO-gnsv4-web 10.0.0.1
+add BAMBOO-gnsv4-web 10.1.0.0/16
+create BAMBOO-netportv4-0123abcd hash:net,port family inet hashsize 1024 maxelem 65536
+add BAMBOO-netportv4-0123abcd 10.0.0.0/24,tcp:80
+create BAMBOO-gnsv6-web hash:net family inet6 hashsize 1024 maxelem 65536
+add BAMBOO-gnsv6-web 2001:db8::1
+create docker-hosts hash:ip family inet hashsize 1024 maxelem 65536
+add docker-hosts 172.17.0.2
+`
+	ipset, err := NewIPSet(generictables.IPFamily4, WithExecutor(fake.NewDataplane()))
+	require.NoError(t, err)
+
+	ipsets, err := ipset.readIPSetFrom(strings.NewReader(saveOutput))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]struct{}{
+		"BAMBOO-gnsv4-web":          {"10.0.0.1/32": {}, "10.1.0.0/16": {}},
+		"BAMBOO-netportv4-0123abcd": {"10.0.0.0/24,tcp:80": {}},
+	}, ipsets)
+}
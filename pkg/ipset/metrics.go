@@ -0,0 +1,22 @@
+package ipset
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	restoreDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "bamboo_ipset_restore_duration_seconds",
+		Help: "Time taken to run ipset restore.",
+	}, []string{"ip_version"})
+	restoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_ipset_restore_errors_total",
+		Help: "Number of ipset restore failures, retries included.",
+	}, []string{"ip_version"})
+	applyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_ipset_apply_failures_total",
+		Help: "Number of applies that still failed after all retries.",
+	}, []string{"ip_version"})
+)
+
+func init() {
+	prometheus.MustRegister(restoreDurationSeconds, restoreErrors, applyFailures)
+}
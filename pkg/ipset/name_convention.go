@@ -2,6 +2,10 @@ package ipset
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/generictables"
 )
 
 const (
@@ -20,11 +24,11 @@ func NewNameConvention() *NameConvention {
 	}
 }
 
-func (i *NameConvention) SetMainNameOfSet(uuid string, index int, ipVersion int, sourceName, name string) string {
-	mainNameOfSet := fmt.Sprintf("%s%sv%d-%d-%s", namePrefix, sourceName, ipVersion, index, name)
-	if len(mainNameOfSet) > maxNameLength {
-		mainNameOfSet = mainNameOfSet[:maxNameLength]
-	}
+// SetMainNameOfSet names the set of uuid with the short hash of uuid, so that sets never collide.
+// Sets named by truncation of previous versions are unused and destroyed after the next apply.
+func (i *NameConvention) SetMainNameOfSet(uuid string, ipVersion int, sourceName, name string) string {
+	mainNameOfSet := generictables.NameWithHash(fmt.Sprintf("%s%sv%d-%s", namePrefix, sourceName, ipVersion, name),
+		uuid, maxNameLength)
 	i.mainNameOfSet[uuid] = mainNameOfSet
 	return mainNameOfSet
 }
@@ -33,3 +37,30 @@ func (i *NameConvention) GetMainNameOfSetByUUID(uuid string) (mainName string, p
 	mainName, present = i.mainNameOfSet[uuid]
 	return
 }
+
+// NetSetName names an agent managed hash:net set by its members, so that rules with the same literal
+// nets share the set and a changed list of nets is a new set
+func NetSetName(ipVersion int, members []string) string {
+	return anonymousSetName(fmt.Sprintf("%snetv%d", namePrefix, ipVersion), members)
+}
+
+// NetPortSetName names an agent managed hash:net,port set by its members, see NetPortMember
+func NetPortSetName(ipVersion int, members []string) string {
+	return anonymousSetName(fmt.Sprintf("%snetportv%d", namePrefix, ipVersion), members)
+}
+
+// NetPortMember returns the member of a hash:net,port set, e.g. 10.0.0.0/8,tcp:80
+func NetPortMember(net, protocol, port string) string {
+	return fmt.Sprintf("%s,%s:%s", net, protocol, port)
+}
+
+// IsNetPortMember returns whether member belongs to a hash:net,port set
+func IsNetPortMember(member string) bool {
+	return strings.Contains(member, ",")
+}
+
+func anonymousSetName(name string, members []string) string {
+	sorted := slices.Clone(members)
+	slices.Sort(sorted)
+	return generictables.NameWithHash(name, strings.Join(sorted, " "), maxNameLength)
+}
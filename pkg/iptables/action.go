@@ -2,6 +2,7 @@ package iptables
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 )
@@ -34,14 +35,22 @@ func (a *actionFactory) Jump(target string) generictables.Action {
 	return JumpToChainAction{target: target}
 }
 
-func (a *actionFactory) Log(prefix string) generictables.Action {
-	return LogAction{prefix: prefix}
+func (a *actionFactory) Log(prefix string, level uint8) generictables.Action {
+	return LogAction{prefix: prefix, level: level}
+}
+
+func (a *actionFactory) NFLog(group uint16, prefix string) generictables.Action {
+	return NFLogAction{group: group, prefix: prefix}
 }
 
 func (a *actionFactory) Drop() generictables.Action {
 	return DropAction{}
 }
 
+func (a *actionFactory) SetMaskedMark(mark, mask uint32) generictables.Action {
+	return SetMaskedMarkAction{mark: mark, mask: mask}
+}
+
 type AcceptAction struct{}
 
 func (a AcceptAction) ToParameter() string {
@@ -77,19 +86,42 @@ func (a ReturnAction) String() string {
 	return "RETURN"
 }
 
+// MaxLogPrefixLength the kernel LOG target truncates prefix to 29 characters
+const MaxLogPrefixLength = 29
+
 type LogAction struct {
 	prefix string
+	level  uint8
 }
 
 func (a LogAction) ToParameter() string {
-	// ToDo: move log level to config or const
-	return fmt.Sprintf(`-j LOG --log-prefix "%s " --log-level 5`, a.prefix)
+	return fmt.Sprintf(`-j LOG --log-prefix "%s" --log-level %d`, generictables.LogPrefix(a.prefix, MaxLogPrefixLength), a.level)
 }
 
 func (a LogAction) String() string {
 	return "LOG"
 }
 
+// maxNFLogPrefixLength the kernel NFLOG target accepts prefix up to 63 characters
+const maxNFLogPrefixLength = 63
+
+type NFLogAction struct {
+	group  uint16
+	prefix string
+}
+
+func (a NFLogAction) ToParameter() string {
+	prefix := strings.ReplaceAll(a.prefix, `"`, "")
+	if len(prefix) > maxNFLogPrefixLength {
+		prefix = prefix[:maxNFLogPrefixLength]
+	}
+	return fmt.Sprintf(`-j NFLOG --nflog-group %d --nflog-prefix "%s"`, a.group, prefix)
+}
+
+func (a NFLogAction) String() string {
+	return "NFLOG"
+}
+
 type GotoAction struct {
 	target string
 }
@@ -123,3 +155,16 @@ func (a DropAction) ToParameter() string {
 func (a DropAction) String() string {
 	return "DROP"
 }
+
+type SetMaskedMarkAction struct {
+	mark uint32
+	mask uint32
+}
+
+func (a SetMaskedMarkAction) ToParameter() string {
+	return fmt.Sprintf("-j MARK --set-xmark %#x/%#x", a.mark, a.mask)
+}
+
+func (a SetMaskedMarkAction) String() string {
+	return fmt.Sprintf("SET-MARK->%#x/%#x", a.mark, a.mask)
+}
@@ -95,6 +95,10 @@ func (m matchBuilder) NotDestIPSet(name string) generictables.MatchCriteria {
 	return append(m, fmt.Sprintf("-m set ! --match-set %s dst", name))
 }
 
+func (m matchBuilder) DestNetPortIPSet(name string) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m set --match-set %s dst,dst", name))
+}
+
 func (m matchBuilder) SourcePorts(ports []string) generictables.MatchCriteria {
 	joinPorts := strings.Join(ports, ",")
 	return append(m, fmt.Sprintf("-m multiport --source-ports %s", joinPorts))
@@ -114,3 +118,58 @@ func (m matchBuilder) NotDestPorts(ports []string) generictables.MatchCriteria {
 	joinPorts := strings.Join(ports, ",")
 	return append(m, fmt.Sprintf("-m multiport ! --destination-ports %s", joinPorts))
 }
+
+func (m matchBuilder) ICMPV4Type(icmpType uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp --icmp-type %d", icmpType))
+}
+
+func (m matchBuilder) NotICMPV4Type(icmpType uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp ! --icmp-type %d", icmpType))
+}
+
+func (m matchBuilder) ICMPV4TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp --icmp-type %d/%d", icmpType, code))
+}
+
+func (m matchBuilder) NotICMPV4TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp ! --icmp-type %d/%d", icmpType, code))
+}
+
+func (m matchBuilder) ICMPV6Type(icmpType uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp6 --icmpv6-type %d", icmpType))
+}
+
+func (m matchBuilder) NotICMPV6Type(icmpType uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp6 ! --icmpv6-type %d", icmpType))
+}
+
+func (m matchBuilder) ICMPV6TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp6 --icmpv6-type %d/%d", icmpType, code))
+}
+
+func (m matchBuilder) NotICMPV6TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m icmp6 ! --icmpv6-type %d/%d", icmpType, code))
+}
+
+func (m matchBuilder) Limit(rate string, burst int) generictables.MatchCriteria {
+	if burst > 0 {
+		return append(m, fmt.Sprintf("-m limit --limit %s --limit-burst %d", rate, burst))
+	}
+	return append(m, fmt.Sprintf("-m limit --limit %s", rate))
+}
+
+func (m matchBuilder) InInterface(name string) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("--in-interface %s", name))
+}
+
+func (m matchBuilder) OutInterface(name string) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("--out-interface %s", name))
+}
+
+func (m matchBuilder) MarkMatchesWithMask(mark, mask uint32) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m mark --mark %#x/%#x", mark, mask))
+}
+
+func (m matchBuilder) NotMarkMatchesWithMask(mark, mask uint32) generictables.MatchCriteria {
+	return append(m, fmt.Sprintf("-m mark ! --mark %#x/%#x", mark, mask))
+}
@@ -0,0 +1,26 @@
+package iptables
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	restoreDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "bamboo_iptables_restore_duration_seconds",
+		Help: "Time taken to run iptables-restore.",
+	}, []string{"table", "ip_version"})
+	restoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_iptables_restore_errors_total",
+		Help: "Number of iptables-restore failures, retries included.",
+	}, []string{"table", "ip_version"})
+	applyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_iptables_apply_failures_total",
+		Help: "Number of applies that still failed after all retries.",
+	}, []string{"table", "ip_version"})
+	driftEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_iptables_drifts_total",
+		Help: "Number of our rules and chains changed outside of agent since the last apply.",
+	}, []string{"table", "ip_version", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(restoreDurationSeconds, restoreErrors, applyFailures, driftEvents)
+}
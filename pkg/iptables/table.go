@@ -6,14 +6,15 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"os/exec"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/bamboo-firewall/agent/pkg/executor"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 )
 
@@ -24,11 +25,15 @@ const (
 	defaultLockSecondTimeout = 3
 
 	maxNameLength = 28
+
+	// anyPosition accepts our rule of default chain wherever it sits among the rules of other programs
+	anyPosition = -1
 )
 
 var (
 	chainRegexp      = regexp.MustCompile(`^:(\S+)`)
 	ruleAppendRegexp = regexp.MustCompile(`^-A (\S+)`)
+	jumpRegexp       = regexp.MustCompile(`\s-[jg] (\S+)`)
 )
 
 type Table struct {
@@ -56,6 +61,16 @@ type Table struct {
 
 	// defaultOurRuleOfDefaultChain contain our rule in default chain
 	defaultOurRuleOfDefaultChain map[string]generictables.Rule
+	// defaultRulePosition position of our rule among the rules of other programs in default chain
+	defaultRulePosition generictables.RulePosition
+	// rulesAboveOurRuleReported default chains whose rules inserted above our first rule were logged
+	rulesAboveOurRuleReported map[string]bool
+
+	// expectedChainHashes hashes of our chains written by the last apply, nil when there is nothing to compare
+	// dataplane with: before the first apply, after a clean or once its drift is reported
+	expectedChainHashes map[string][]string
+	// expectedDefaultHashes hash of our rule of default chains written by the last apply
+	expectedDefaultHashes map[string]string
 
 	// needCleanToDataplane clean all our rules and chains
 	needCleanToDataplane bool
@@ -64,6 +79,11 @@ type Table struct {
 
 	restoreCmd string
 	saveCmd    string
+	// executor runs iptables commands
+	executor executor.Executor
+
+	// dryRun is set in observe-only mode, restore payloads are reported instead of executed
+	dryRun *generictables.DryRun
 }
 
 func NewTable(name string, hashPrefix string, opts ...option) (*Table, error) {
@@ -79,12 +99,15 @@ func NewTable(name string, hashPrefix string, opts ...option) (*Table, error) {
 		renderer:                     NewRenderer(hashPrefix),
 		chainNameToChain:             make(map[string]*generictables.Chain),
 		defaultOurRuleOfDefaultChain: make(map[string]generictables.Rule),
+		defaultRulePosition:          generictables.RulePosition{Kind: generictables.RulePositionLast},
+		rulesAboveOurRuleReported:    make(map[string]bool),
+		executor:                     executor.OS{},
 	}
 	for _, opt := range opts {
 		opt(t)
 	}
 
-	ipTableVersion, mode, err := getIptablesVersion(t.ipVersion)
+	ipTableVersion, mode, err := getIptablesVersion(t.executor, t.ipVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -97,19 +120,16 @@ func NewTable(name string, hashPrefix string, opts ...option) (*Table, error) {
 		t.waitSupportSecond = true
 	}
 
-	t.hashCommentRegexp = regexp.MustCompile(`--comment "?` + hashPrefix + `([a-zA-Z0-9_-]+)"?`)
+	t.hashCommentRegexp = newHashCommentRegexp(hashPrefix)
+	t.ourChainsRegexp = newOurChainsRegexp()
 
-	ourChainPrefix := []string{"BAMBOO-"}
-	ourChainPattern := "^(" + strings.Join(ourChainPrefix, "|") + ")"
-	t.ourChainsRegexp = regexp.MustCompile(ourChainPattern)
-
-	restoreCmd, err := getIptablesRestoreOrSaveCmd(mode, t.ipVersion, "restore")
+	restoreCmd, err := getIptablesRestoreOrSaveCmd(t.executor, mode, t.ipVersion, "restore")
 	if err != nil {
 		return nil, err
 	}
 	t.restoreCmd = restoreCmd
 
-	saveCmd, err := getIptablesRestoreOrSaveCmd(mode, t.ipVersion, "save")
+	saveCmd, err := getIptablesRestoreOrSaveCmd(t.executor, mode, t.ipVersion, "save")
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +141,17 @@ func NewTable(name string, hashPrefix string, opts ...option) (*Table, error) {
 	return t, nil
 }
 
-func getIptablesRestoreOrSaveCmd(mode string, ipVersion int, restoreOrSave string) (string, error) {
+func newHashCommentRegexp(hashPrefix string) *regexp.Regexp {
+	return regexp.MustCompile(`--comment "?` + hashPrefix + `([a-zA-Z0-9_-]+)"?`)
+}
+
+func newOurChainsRegexp() *regexp.Regexp {
+	ourChainPrefix := []string{"BAMBOO-"}
+	ourChainPattern := "^(" + strings.Join(ourChainPrefix, "|") + ")"
+	return regexp.MustCompile(ourChainPattern)
+}
+
+func getIptablesRestoreOrSaveCmd(e executor.Executor, mode string, ipVersion int, restoreOrSave string) (string, error) {
 	verInFix := ""
 	if ipVersion == generictables.IPFamily6 {
 		verInFix = "6"
@@ -131,7 +161,7 @@ func getIptablesRestoreOrSaveCmd(mode string, ipVersion int, restoreOrSave strin
 		"ip" + verInFix + "tables-" + restoreOrSave,
 	}
 	for _, candidate := range candidates {
-		_, err := exec.LookPath(candidate)
+		_, err := e.LookPath(candidate)
 		if err == nil {
 			return candidate, nil
 		} else {
@@ -141,6 +171,14 @@ func getIptablesRestoreOrSaveCmd(mode string, ipVersion int, restoreOrSave strin
 	return "", fmt.Errorf("no iptables restore command found for mode %s and ipVersion %d", mode, ipVersion)
 }
 
+func (t *Table) GetName() string {
+	return t.name
+}
+
+func (t *Table) GetIPVersion() int {
+	return t.ipVersion
+}
+
 func (t *Table) SetDefaultRuleOfDefaultChain(chainName string, rule generictables.Rule) {
 	t.defaultOurRuleOfDefaultChain[chainName] = rule
 }
@@ -161,15 +199,20 @@ func (t *Table) NeedClean() {
 	t.needCleanToDataplane = true
 }
 
-func (t *Table) Apply() {
+func (t *Table) Apply() error {
 	if !t.inSyncWithDataplane {
 		t.loadFromDataplane()
+		if t.inSyncWithDataplane {
+			t.reportDrifts(t.driftsOf(t.chainHashesFromDataplane, t.rawRulesOfDefaultChainFromDataplane))
+		}
 	}
+	cleaning := t.needCleanToDataplane
 	retries := 3
 	retryDelay := 100 * time.Millisecond
 
+	var err error
 	for {
-		err := t.apply()
+		err = t.apply()
 		if err != nil {
 			slog.Warn("apply rule failed. Retrying", "table", t.name, "err", err)
 			if retries > 0 {
@@ -178,14 +221,172 @@ func (t *Table) Apply() {
 				retryDelay *= 2
 			} else {
 				slog.Error("apply rule fail after retry.", "table", t.name, "err", err)
+				applyFailures.WithLabelValues(t.name, strconv.Itoa(t.ipVersion)).Inc()
 				break
 			}
 			continue
 		}
 		break
 	}
+	t.expectedChainHashes, t.expectedDefaultHashes = nil, nil
+	if err == nil && !cleaning && t.dryRun == nil && len(t.chainNameToChain) > 0 {
+		t.expectedChainHashes, t.expectedDefaultHashes = t.desiredHashes()
+	}
 	t.inSyncWithDataplane = false
 	t.needCleanToDataplane = false
+	return err
+}
+
+// CheckDrift compares the rules in dataplane with the rules of the last apply. Drifts are reported once,
+// the next apply repairs them.
+func (t *Table) CheckDrift() ([]generictables.Drift, error) {
+	if t.expectedChainHashes == nil {
+		return nil, nil
+	}
+	hashes, rules, err := t.getHashesAndRulesFromDataplane()
+	if err != nil {
+		return nil, err
+	}
+	drifts := t.driftsOf(hashes, rules)
+	t.reportDrifts(drifts)
+	return drifts, nil
+}
+
+// desiredHashes returns the hashes of our chains and of our rule of default chains
+func (t *Table) desiredHashes() (map[string][]string, map[string]string) {
+	chainHashes := make(map[string][]string, len(t.chainNameToChain))
+	for chainName, chain := range t.chainNameToChain {
+		chainHashes[chainName] = t.renderer.RuleHashes(chain)
+	}
+	defaultHashes := make(map[string]string, len(t.defaultOurRuleOfDefaultChain))
+	for chainName, defaultRule := range t.defaultOurRuleOfDefaultChain {
+		defaultHashes[chainName] = t.renderer.RuleHashes(&generictables.Chain{
+			Name:  chainName,
+			Rules: []generictables.Rule{defaultRule},
+		})[0]
+	}
+	return chainHashes, defaultHashes
+}
+
+// driftsOf returns the drifts of hashes and rules of default chains read from dataplane from the hashes of
+// the last apply
+func (t *Table) driftsOf(hashes, rules map[string][]string) []generictables.Drift {
+	if t.expectedChainHashes == nil {
+		return nil
+	}
+	ourChainHashes := make(map[string][]string)
+	for chainName, chainHashes := range hashes {
+		if _, ok := t.expectedDefaultHashes[chainName]; !ok {
+			ourChainHashes[chainName] = chainHashes
+		}
+	}
+	drifts := generictables.DiffChainHashes(t.expectedChainHashes, ourChainHashes)
+	for _, chainName := range sortedKeys(t.expectedDefaultHashes) {
+		position, _ := t.defaultRuleIndex(rules[chainName])
+		drifts = append(drifts, defaultChainDrifts(chainName, t.expectedDefaultHashes[chainName], hashes[chainName],
+			t.defaultRulePositionToKeep(position))...)
+	}
+	return drifts
+}
+
+// defaultRuleIndex returns how many rules of other programs in default chain come before our rule. Our rule
+// is last when no rule jumps to the chain it follows, anchorFound is false then.
+func (t *Table) defaultRuleIndex(rules []string) (index int, anchorFound bool) {
+	var otherRules []string
+	for _, rule := range rules {
+		if !t.hashCommentRegexp.MatchString(rule) {
+			otherRules = append(otherRules, rule)
+		}
+	}
+	switch t.defaultRulePosition.Kind {
+	case generictables.RulePositionFirst:
+		return 0, true
+	case generictables.RulePositionAfter:
+		index, anchorFound = len(otherRules), false
+		for i, rule := range otherRules {
+			captures := jumpRegexp.FindStringSubmatch(rule)
+			if captures != nil && captures[1] == t.defaultRulePosition.AfterChain {
+				index, anchorFound = i+1, true
+			}
+		}
+		return index, anchorFound
+	default:
+		return len(otherRules), true
+	}
+}
+
+// defaultRulePositionToKeep returns the position our rule already in default chain must sit at to be kept.
+// With first, rules inserted above ours are tolerated: docker and kube-proxy insert their rules on top on every
+// resync, moving ours back on top would fight them forever.
+func (t *Table) defaultRulePositionToKeep(position int) int {
+	if t.defaultRulePosition.Kind == generictables.RulePositionFirst {
+		return anyPosition
+	}
+	return position
+}
+
+// reportRulesAboveOurRule logs once that rules of other programs were inserted above our first rule of
+// default chain, until our rule is on top again
+func (t *Table) reportRulesAboveOurRule(chainName string, hashes []string) {
+	if t.defaultRulePosition.Kind != generictables.RulePositionFirst {
+		return
+	}
+	if len(hashes) == 0 || hashes[0] != "" {
+		delete(t.rulesAboveOurRuleReported, chainName)
+		return
+	}
+	if t.rulesAboveOurRuleReported[chainName] {
+		return
+	}
+	t.rulesAboveOurRuleReported[chainName] = true
+	slog.Warn("rules of other programs were inserted above our first rule, it is kept in place", "table", t.name,
+		"chain", chainName)
+}
+
+// defaultChainDrifts returns the drifts of a default chain, our rule must follow position rules of other
+// programs, anywhere with anyPosition, and be the only one of ours. Rules of other programs are not drifts.
+func defaultChainDrifts(chainName, expectedHash string, hashes []string, position int) []generictables.Drift {
+	var drifts []generictables.Drift
+	found := false
+	otherRules := 0
+	for i, hash := range hashes {
+		if hash == "" {
+			otherRules++
+			continue
+		}
+		if !found && hash == expectedHash && (position == anyPosition || otherRules == position) {
+			found = true
+			continue
+		}
+		drifts = append(drifts, generictables.Drift{
+			Chain:  chainName,
+			Index:  i + 1,
+			Actual: hash,
+			Reason: generictables.DriftUnexpectedRule,
+		})
+	}
+	if !found {
+		drifts = append(drifts, generictables.Drift{
+			Chain:    chainName,
+			Index:    len(hashes) + 1,
+			Expected: expectedHash,
+			Reason:   generictables.DriftRuleMissing,
+		})
+	}
+	return drifts
+}
+
+// reportDrifts logs and counts drifts, the expected hashes are forgotten so that a drift is reported once
+func (t *Table) reportDrifts(drifts []generictables.Drift) {
+	if len(drifts) == 0 {
+		return
+	}
+	for _, drift := range drifts {
+		slog.Warn("dataplane drift detected", "table", t.name, "ipVersion", t.ipVersion, "chain", drift.Chain,
+			"rule", drift.Index, "reason", drift.Reason, "expected", drift.Expected, "actual", drift.Actual)
+		driftEvents.WithLabelValues(t.name, strconv.Itoa(t.ipVersion), drift.Reason).Inc()
+	}
+	t.expectedChainHashes, t.expectedDefaultHashes = nil, nil
 }
 
 func (t *Table) apply() error {
@@ -245,7 +446,7 @@ func (t *Table) apply() error {
 		if _, ok := updatedChains[chainName]; ok {
 			continue
 		}
-		if chainName == generictables.OurDefaultInputChain || chainName == generictables.OurDefaultOutputChain {
+		if isOurDefaultChain(chainName) {
 			continue
 		}
 
@@ -275,7 +476,7 @@ func (t *Table) apply() error {
 		if _, ok := updatedChains[chainName]; ok {
 			continue
 		}
-		if !(chainName == generictables.OurDefaultInputChain || chainName == generictables.OurDefaultOutputChain) {
+		if !isOurDefaultChain(chainName) {
 			continue
 		}
 
@@ -302,40 +503,53 @@ func (t *Table) apply() error {
 
 	}
 	// Step 3: Write our rule of default chain
-	// Make sure one our rule of last of default chain
+	// Make sure one our rule at the configured position of default chain, rules of other programs are kept
 	for chainName, defaultRule := range t.defaultOurRuleOfDefaultChain {
 		defaultHashes := t.renderer.RuleHashes(&generictables.Chain{
 			Name:  chainName,
 			Rules: []generictables.Rule{defaultRule},
 		})
 		defaultHash := defaultHashes[0]
-		if _, ok := t.chainHashesFromDataplane[chainName]; !ok {
-			buf.WriteRule(t.renderer.RenderAppend(&defaultRule, chainName, defaultHash))
+		hashes := t.chainHashesFromDataplane[chainName]
+		rules := t.rawRulesOfDefaultChainFromDataplane[chainName]
+		position, anchorFound := t.defaultRuleIndex(rules)
+		if len(defaultChainDrifts(chainName, defaultHash, hashes, t.defaultRulePositionToKeep(position))) == 0 {
+			t.reportRulesAboveOurRule(chainName, hashes)
 			continue
 		}
+		if !anchorFound {
+			slog.Warn("no rule jumps to the chain our rule follows, place our rule last", "table", t.name,
+				"chain", chainName, "after", t.defaultRulePosition.AfterChain)
+		}
 
-		hashes := t.chainHashesFromDataplane[chainName]
 		for i, hash := range hashes {
-			if i == len(hashes)-1 {
-				if hash == defaultHash {
-					continue
-				} else if hash != "" && hash != defaultHash {
-					buf.WriteRule(t.renderer.RenderDelete(t.rawRulesOfDefaultChainFromDataplane[chainName][i]))
-				}
-				buf.WriteRule(t.renderer.RenderAppend(&defaultRule, chainName, defaultHash))
-			} else {
-				if hash != "" {
-					buf.WriteRule(t.renderer.RenderDelete(t.rawRulesOfDefaultChainFromDataplane[chainName][i]))
-				}
+			if hash != "" {
+				buf.WriteRule(t.renderer.RenderDelete(rules[i]))
+			}
+		}
+		if t.defaultRulePosition.Kind == generictables.RulePositionLast {
+			buf.WriteRule(t.renderer.RenderAppend(&defaultRule, chainName, defaultHash))
+		} else {
+			buf.WriteRule(t.renderer.RenderInsertAtIndex(&defaultRule, chainName, position+1, defaultHash))
+		}
+	}
+	// Step 4: Delete our rules of default chains we do not jump from anymore, before deleting their target
+	for chainName, hashes := range t.chainHashesFromDataplane {
+		if _, ok := t.defaultOurRuleOfDefaultChain[chainName]; ok || t.ourChainsRegexp.MatchString(chainName) {
+			continue
+		}
+		for i, hash := range hashes {
+			if hash != "" {
+				buf.WriteRule(t.renderer.RenderDelete(t.rawRulesOfDefaultChainFromDataplane[chainName][i]))
 			}
 		}
 	}
-	// Step 4: Delete all our unreferenced chain
+	// Step 5: Delete all our unreferenced chain
 	for chainName := range t.chainHashesFromDataplane {
 		if _, ok := referenceChains[chainName]; ok {
 			continue
 		}
-		if _, ok := t.defaultOurRuleOfDefaultChain[chainName]; ok {
+		if !t.ourChainsRegexp.MatchString(chainName) {
 			continue
 		}
 		buf.WriteChain(chainName)
@@ -360,8 +574,10 @@ func (t *Table) Clean() error {
 	buf.StartTransaction(t.name)
 
 	// first: remove all our rule in default chain
-	for chainName := range t.defaultOurRuleOfDefaultChain {
-		hashes := t.chainHashesFromDataplane[chainName]
+	for chainName, hashes := range t.chainHashesFromDataplane {
+		if t.ourChainsRegexp.MatchString(chainName) {
+			continue
+		}
 		for i, hash := range hashes {
 			if hash != "" {
 				buf.WriteRule(t.renderer.RenderDelete(t.rawRulesOfDefaultChainFromDataplane[chainName][i]))
@@ -371,7 +587,7 @@ func (t *Table) Clean() error {
 
 	// second: delete our default chains(default rule and reference to our chains)
 	for chainName := range t.chainHashesFromDataplane {
-		if !(chainName == generictables.OurDefaultInputChain || chainName == generictables.OurDefaultOutputChain) {
+		if !isOurDefaultChain(chainName) {
 			continue
 		}
 
@@ -381,12 +597,12 @@ func (t *Table) Clean() error {
 
 	// third: delete all our chains
 	for chainName := range t.chainHashesFromDataplane {
-		if chainName == generictables.OurDefaultInputChain || chainName == generictables.OurDefaultOutputChain {
+		if isOurDefaultChain(chainName) {
 			continue
 		}
 
 		// ignore default chain
-		if _, ok := t.defaultOurRuleOfDefaultChain[chainName]; ok {
+		if !t.ourChainsRegexp.MatchString(chainName) {
 			continue
 		}
 
@@ -408,6 +624,10 @@ func (t *Table) execRestore(buf *RestoreBuilder) error {
 	slog.Debug("start exec restore", "ipVersion", t.ipVersion)
 	defer slog.Debug("finish exec restore", "ipVersion", t.ipVersion)
 	contentBytes := buf.buf.Next(buf.buf.Len())
+	if t.dryRun != nil {
+		t.dryRun.Skip(t.restoreCmd, t.ipVersion, contentBytes)
+		return nil
+	}
 	args := []string{"--noflush", "--verbose"}
 	if t.hasWait {
 		args = append(args, "--wait")
@@ -429,16 +649,15 @@ func (t *Table) execRestore(buf *RestoreBuilder) error {
 		}()
 	}
 
-	var outputBuf, errBuf bytes.Buffer
-	cmd := exec.Command(t.restoreCmd, args...)
-	slog.Debug("exec restore", "cmd", cmd.String(), "content", string(contentBytes), "ipVersion", t.ipVersion)
-	cmd.Stdin = bytes.NewReader(contentBytes)
-	cmd.Stdout = &outputBuf
-	cmd.Stderr = &errBuf
-	err := cmd.Run()
+	cmdLine := executor.CommandLine(t.restoreCmd, args)
+	slog.Debug("exec restore", "cmd", cmdLine, "content", string(contentBytes), "ipVersion", t.ipVersion)
+	startTime := time.Now()
+	stdout, stderr, err := t.executor.Run(t.restoreCmd, args, contentBytes)
+	restoreDurationSeconds.WithLabelValues(t.name, strconv.Itoa(t.ipVersion)).Observe(time.Since(startTime).Seconds())
 	if err != nil {
-		slog.Error("restore fail", "cmd", cmd.String(), "input", string(contentBytes), "stdout", outputBuf.String(), "stderr", errBuf.String())
-		return fmt.Errorf("restore failed. stderr: %s . err: %w", errBuf.String(), err)
+		restoreErrors.WithLabelValues(t.name, strconv.Itoa(t.ipVersion)).Inc()
+		slog.Error("restore fail", "cmd", cmdLine, "input", string(contentBytes), "stdout", string(stdout), "stderr", string(stderr))
+		return fmt.Errorf("restore failed. stderr: %s . err: %w", stderr, err)
 	}
 	return nil
 }
@@ -480,31 +699,17 @@ func (t *Table) getHashesAndRulesFromDataplane() (map[string][]string, map[strin
 
 func (t *Table) attemptToGetHashesAndRulesFromDataplane() (map[string][]string, map[string][]string, error) {
 	// get command from config
-	cmd := exec.Command(t.saveCmd, "-t", t.name)
-	stdout, err := cmd.StdoutPipe()
+	stdout, stderr, err := t.executor.Run(t.saveCmd, []string{"-t", t.name}, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error stdout from cmd: %w", err)
-	}
-	if err = cmd.Start(); err != nil {
-		return nil, nil, fmt.Errorf("error start cmd: %w", err)
+		return nil, nil, fmt.Errorf("error run cmd: %w. stderr: %s", err, stderr)
 	}
-	hashes, rules, err := t.readHashesAndRulesFrom(stdout)
-	if err != nil {
-		if errKill := cmd.Process.Kill(); errKill != nil {
-			err = fmt.Errorf("scanner error: %w. then kill process error: %w", err, errKill)
-		}
-		return nil, nil, err
-	}
-	if err = cmd.Wait(); err != nil {
-		return nil, nil, fmt.Errorf("error wait cmd: %w", err)
-	}
-	return hashes, rules, nil
+	return t.readHashesAndRulesFrom(bytes.NewReader(stdout))
 }
 
 // readHashesAndRulesFrom
 // hashes contain hashed our rule of chains
 // rules contain raw our rule of default chain
-func (t *Table) readHashesAndRulesFrom(r io.ReadCloser) (map[string][]string, map[string][]string, error) {
+func (t *Table) readHashesAndRulesFrom(r io.Reader) (map[string][]string, map[string][]string, error) {
 	hashes := make(map[string][]string)
 	rules := make(map[string][]string)
 	scanner := bufio.NewScanner(r)
@@ -538,20 +743,22 @@ func (t *Table) readHashesAndRulesFrom(r io.ReadCloser) (map[string][]string, ma
 		}
 		hashes[chainName] = append(hashes[chainName], hash)
 
-		// Get our rules of default chain
+		// Get rules of default chain, rules of other programs place our rule
 		if !t.ourChainsRegexp.MatchString(chainName) {
-			fullRule := "-"
-			if captures = t.hashCommentRegexp.FindSubmatch(line); captures != nil {
+			if hash != "" {
 				chainHasOurRule[chainName] = struct{}{}
-				fullRule = string(line)
 			}
-			rules[chainName] = append(rules[chainName], fullRule)
+			rules[chainName] = append(rules[chainName], string(line))
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, nil, fmt.Errorf("error scanner error: %w", err)
 	}
 
+	// Default chain we put our rule in is kept even without our rule
+	for chainName := range t.defaultOurRuleOfDefaultChain {
+		chainHasOurRule[chainName] = struct{}{}
+	}
 	// Remove all chain has not rules of our
 	for chainName := range hashes {
 		if _, ok := chainHasOurRule[chainName]; !ok {
@@ -567,9 +774,24 @@ func (t *Table) readHashesAndRulesFrom(r io.ReadCloser) (map[string][]string, ma
 	return hashes, rules, nil
 }
 
-func GetMaxCustomChainName(originName string) string {
-	if len(originName) > maxNameLength {
-		return originName[0:maxNameLength]
+// isOurDefaultChain reports whether chainName is our chain a default chain jumps to
+func isOurDefaultChain(chainName string) bool {
+	return chainName == generictables.OurDefaultInputChain || chainName == generictables.OurDefaultOutputChain ||
+		chainName == generictables.OurDefaultForwardChain
+}
+
+// GetCustomChainName returns the name of a chain rendered from the object of uuid, truncated to the
+// maximum length of chain names. Chains named by truncation of previous versions are unreferenced and
+// deleted by the next apply.
+func GetCustomChainName(originName, uuid string) string {
+	return generictables.NameWithHash(originName, uuid, maxNameLength)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	return originName
+	sort.Strings(keys)
+	return keys
 }
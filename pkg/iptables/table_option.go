@@ -1,5 +1,10 @@
 package iptables
 
+import (
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
 type option func(*Table)
 
 func WithIPFamily(family int) option {
@@ -16,3 +21,25 @@ func WithLockSecondsTimeout(timeout int) option {
 		t.lockSecondTimeout = timeout
 	}
 }
+
+// WithDryRun computes the restore payloads without executing iptables-restore, nil applies them as usual
+func WithDryRun(dryRun *generictables.DryRun) option {
+	return func(t *Table) {
+		t.dryRun = dryRun
+	}
+}
+
+// WithDefaultRulePosition places our rule of default chains at position among the rules of other programs,
+// last by default
+func WithDefaultRulePosition(position generictables.RulePosition) option {
+	return func(t *Table) {
+		t.defaultRulePosition = position
+	}
+}
+
+// WithExecutor runs iptables commands with e instead of the commands of host
+func WithExecutor(e executor.Executor) option {
+	return func(t *Table) {
+		t.executor = e
+	}
+}
@@ -0,0 +1,307 @@
+package iptables
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const foreignInputRule = "-p tcp -m tcp --dport 22 -j ACCEPT"
+
+func newFakeFilterTable(t *testing.T, dataplane *fake.Dataplane) *Table {
+	table, err := NewTable(generictables.TableFilter, generictables.HashPrefix,
+		WithIPFamily(generictables.IPFamily4), WithExecutor(dataplane))
+	require.NoError(t, err)
+	table.SetDefaultRuleOfDefaultChain(generictables.DefaultChainInput, generictables.Rule{
+		Match:  NewMatch(),
+		Action: NewAction().Jump(generictables.OurDefaultInputChain),
+	})
+	return table
+}
+
+func policyChains(policyChain string, policyRules ...generictables.Rule) []*generictables.Chain {
+	return []*generictables.Chain{
+		{
+			Name: generictables.OurDefaultInputChain,
+			Rules: []generictables.Rule{
+				{Match: NewMatch(), Action: NewAction().Jump(policyChain)},
+				{Match: NewMatch(), Action: NewAction().Drop()},
+			},
+		},
+		{Name: policyChain, Rules: policyRules},
+	}
+}
+
+func allowPort(port string) generictables.Rule {
+	return generictables.Rule{
+		Match:  NewMatch().Protocol("tcp").DestPorts([]string{port}),
+		Action: NewAction().Allow(),
+	}
+}
+
+// withoutHashes strips the hash comment of rules to compare them with the rendered rules
+func withoutHashes(rules []string) []string {
+	stripped := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, "-m comment --comment \""+generictables.HashPrefix) {
+			_, rule, _ = strings.Cut(rule, "\" ")
+		}
+		stripped = append(stripped, rule)
+	}
+	return stripped
+}
+
+func TestTableApply(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+	table := newFakeFilterTable(t, dataplane)
+
+	// initial apply creates chains and appends the jump after the rules of other programs
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80"), allowPort("443")))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"INPUT", "FORWARD", "OUTPUT", "BAMBOO-INPUT", "BAMBOO-PI-web"},
+		dataplane.Chains(generictables.IPFamily4, generictables.TableFilter))
+	inputRules := dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)
+	assert.Equal(t, []string{foreignInputRule, "-j BAMBOO-INPUT"}, withoutHashes(inputRules))
+	assert.Equal(t, []string{"-p tcp -m multiport --destination-ports 80 -j ACCEPT", "-p tcp -m multiport --destination-ports 443 -j ACCEPT"},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, "BAMBOO-PI-web")))
+
+	// applying the same chains is a no-op
+	restores := dataplane.Restores()
+	require.NoError(t, table.Apply())
+	assert.Equal(t, restores, dataplane.Restores())
+
+	// changed rules are replaced and deleted in place
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("8080")))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"-p tcp -m multiport --destination-ports 8080 -j ACCEPT"},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, "BAMBOO-PI-web")))
+
+	// unreferenced chains are deleted
+	table.UpdateChains(policyChains("BAMBOO-PI-api", allowPort("9090")))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"INPUT", "FORWARD", "OUTPUT", "BAMBOO-INPUT", "BAMBOO-PI-api"},
+		dataplane.Chains(generictables.IPFamily4, generictables.TableFilter))
+	assert.Equal(t, inputRules, dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput))
+}
+
+func TestTableApplyDefaultRulePosition(t *testing.T) {
+	const (
+		dockerRule = "-j DOCKER-USER"
+		dropRule   = "-p tcp -m tcp --dport 23 -j DROP"
+	)
+	tests := []struct {
+		name     string
+		position generictables.RulePosition
+		expected []string
+	}{
+		{
+			name:     "last",
+			position: generictables.RulePosition{Kind: generictables.RulePositionLast},
+			expected: []string{foreignInputRule, dockerRule, dropRule, "-j BAMBOO-INPUT"},
+		},
+		{
+			name:     "first",
+			position: generictables.RulePosition{Kind: generictables.RulePositionFirst},
+			expected: []string{"-j BAMBOO-INPUT", foreignInputRule, dockerRule, dropRule},
+		},
+		{
+			name:     "after chain",
+			position: generictables.RulePosition{Kind: generictables.RulePositionAfter, AfterChain: "DOCKER-USER"},
+			expected: []string{foreignInputRule, dockerRule, "-j BAMBOO-INPUT", dropRule},
+		},
+		{
+			name:     "after missing chain",
+			position: generictables.RulePosition{Kind: generictables.RulePositionAfter, AfterChain: "CILIUM_INPUT"},
+			expected: []string{foreignInputRule, dockerRule, dropRule, "-j BAMBOO-INPUT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataplane := fake.NewDataplane()
+			dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, "DOCKER-USER", "-j RETURN")
+			dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+			// our rule of an agent configured with another position is moved
+			dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput,
+				`-m comment --comment "bamboo:aaaaaaaaaaaaaaaa" -j ACCEPT`)
+			dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, dockerRule)
+			dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, dropRule)
+			table := newFakeFilterTable(t, dataplane)
+			WithDefaultRulePosition(tt.position)(table)
+
+			table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+			require.NoError(t, table.Apply())
+			assert.Equal(t, tt.expected,
+				withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)))
+
+			// our rule in place is kept
+			restores := dataplane.Restores()
+			require.NoError(t, table.Apply())
+			assert.Equal(t, restores, dataplane.Restores())
+		})
+	}
+}
+
+func TestTableApplyFirstPositionKeepsRulesInsertedAbove(t *testing.T) {
+	const dockerRule = "-j DOCKER-USER"
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, "DOCKER-USER", "-j RETURN")
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+	table := newFakeFilterTable(t, dataplane)
+	WithDefaultRulePosition(generictables.RulePosition{Kind: generictables.RulePositionFirst})(table)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+	require.NoError(t, table.Apply())
+	require.Equal(t, []string{"-j BAMBOO-INPUT", foreignInputRule},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)))
+
+	// another program inserts its rule on top on every resync, our rule is not moved back above it
+	require.NoError(t, dataplane.InsertRule(generictables.IPFamily4, generictables.TableFilter,
+		generictables.DefaultChainInput, 1, dockerRule))
+	drifts, err := table.CheckDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+	restores := dataplane.Restores()
+	for range 3 {
+		require.NoError(t, table.Apply())
+	}
+	assert.Equal(t, restores, dataplane.Restores())
+	assert.Equal(t, []string{dockerRule, "-j BAMBOO-INPUT", foreignInputRule},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)))
+
+	// a missing rule is still inserted on top
+	require.NoError(t, dataplane.DeleteRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, 2))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"-j BAMBOO-INPUT", dockerRule, foreignInputRule},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)))
+}
+
+func TestTableApplyRemovesJumpOfUnusedDefaultChain(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainForward, foreignInputRule)
+	forwardChains := append(policyChains("BAMBOO-PI-web", allowPort("80")),
+		&generictables.Chain{Name: generictables.OurDefaultForwardChain, Rules: []generictables.Rule{}})
+	table := newFakeFilterTable(t, dataplane)
+	table.SetDefaultRuleOfDefaultChain(generictables.DefaultChainForward, generictables.Rule{
+		Match:  NewMatch(),
+		Action: NewAction().Jump(generictables.OurDefaultForwardChain),
+	})
+	table.UpdateChains(forwardChains)
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{foreignInputRule, "-j BAMBOO-FORWARD"},
+		withoutHashes(dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainForward)))
+
+	// agent restarted without forward chain
+	table = newFakeFilterTable(t, dataplane)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"INPUT", "FORWARD", "OUTPUT", "BAMBOO-INPUT", "BAMBOO-PI-web"},
+		dataplane.Chains(generictables.IPFamily4, generictables.TableFilter))
+	assert.Equal(t, []string{foreignInputRule},
+		dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainForward))
+}
+
+func TestTableApplyRetriesFailedRestore(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	table := newFakeFilterTable(t, dataplane)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+
+	dataplane.FailRestores(1)
+	require.NoError(t, table.Apply())
+	assert.Len(t, dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, "BAMBOO-PI-web"), 1)
+}
+
+func TestTableClean(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+	table := newFakeFilterTable(t, dataplane)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+	require.NoError(t, table.Apply())
+
+	table.NeedClean()
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"INPUT", "FORWARD", "OUTPUT"}, dataplane.Chains(generictables.IPFamily4, generictables.TableFilter))
+	assert.Equal(t, []string{foreignInputRule},
+		dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput))
+}
+
+func TestTableCheckDrift(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+	table := newFakeFilterTable(t, dataplane)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", allowPort("80")))
+	require.NoError(t, table.Apply())
+
+	drifts, err := table.CheckDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	// rules of other programs are not drifts
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainOutput, foreignInputRule)
+	drifts, err = table.CheckDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	require.NoError(t, dataplane.DeleteRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, 2))
+	require.NoError(t, dataplane.DeleteRule(generictables.IPFamily4, generictables.TableFilter, "BAMBOO-PI-web", 1))
+	drifts, err = table.CheckDrift()
+	require.NoError(t, err)
+	if assert.Len(t, drifts, 2) {
+		assert.Equal(t, "BAMBOO-PI-web", drifts[0].Chain)
+		assert.Equal(t, generictables.DriftRuleMissing, drifts[0].Reason)
+		assert.Equal(t, generictables.DefaultChainInput, drifts[1].Chain)
+		assert.Equal(t, generictables.DriftRuleMissing, drifts[1].Reason)
+	}
+
+	// a drift is reported once
+	drifts, err = table.CheckDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	// apply repairs the drift
+	require.NoError(t, table.Apply())
+	assert.Len(t, dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput), 2)
+	assert.Len(t, dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, "BAMBOO-PI-web"), 1)
+	drifts, err = table.CheckDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestReadHashesAndRulesFrom(t *testing.T) {
+	const saveOutput = `# Generated by iptables-save v1.8.7
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:BAMBOO-INPUT - [0:0]
+:DOCKER - [0:0]
+-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+-A INPUT -m comment --comment "bamboo:aaaaaaaaaaaaaaaa" -j BAMBOO-INPUT
+-A BAMBOO-INPUT -m comment --comment "bamboo:bbbbbbbbbbbbbbbb" -j DROP
+-A DOCKER -j RETURN
+COMMIT
+`
+	table := &Table{
+		hashCommentRegexp: newHashCommentRegexp(generictables.HashPrefix),
+		ourChainsRegexp:   newOurChainsRegexp(),
+		defaultOurRuleOfDefaultChain: map[string]generictables.Rule{
+			generictables.DefaultChainInput:  {},
+			generictables.DefaultChainOutput: {},
+		},
+	}
+
+	hashes, rules, err := table.readHashesAndRulesFrom(strings.NewReader(saveOutput))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"INPUT":        {"", "aaaaaaaaaaaaaaaa"},
+		"OUTPUT":       {},
+		"BAMBOO-INPUT": {"bbbbbbbbbbbbbbbb"},
+	}, hashes)
+	assert.Equal(t, map[string][]string{
+		"INPUT": {"-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT", `-A INPUT -m comment --comment "bamboo:aaaaaaaaaaaaaaaa" -j BAMBOO-INPUT`},
+	}, rules)
+}
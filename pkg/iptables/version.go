@@ -2,9 +2,9 @@ package iptables
 
 import (
 	"fmt"
-	"os/exec"
 	"regexp"
 
+	"github.com/bamboo-firewall/agent/pkg/executor"
 	"github.com/bamboo-firewall/agent/pkg/generictables"
 )
 
@@ -46,10 +46,9 @@ func (v version) isGTE(targetVersion version) bool {
 	return false
 }
 
-func getIptablesVersion(ipVersion int) (version, string, error) {
+func getIptablesVersion(e executor.Executor, ipVersion int) (version, string, error) {
 	iptablesCommand := getIptablesCommand(ipVersion)
-	cmd := exec.Command(iptablesCommand, "--version")
-	out, err := cmd.Output()
+	out, _, err := e.Run(iptablesCommand, []string{"--version"}, nil)
 	if err != nil {
 		return version{}, "", fmt.Errorf("failed to get iptables version: %w", err)
 	}
@@ -0,0 +1,49 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// UnixAddressPrefix prefixes a unix socket address, e.g. "unix:///run/bamboo-agent/status.sock"
+	UnixAddressPrefix = "unix://"
+
+	socketDirPerm = 0755
+)
+
+// Listen listens on a unix socket or a "host:port" address. host must be loopback unless allowRemote, so local
+// endpoints are not reachable from other hosts by mistake
+func Listen(address string, allowRemote bool) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		if err := os.MkdirAll(filepath.Dir(path), socketDirPerm); err != nil {
+			return nil, fmt.Errorf("create socket dir failed: %w", err)
+		}
+		// remove socket of previous run
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove socket failed: %w", err)
+		}
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if !allowRemote && !isLoopbackHost(host) {
+		return nil, fmt.Errorf("address must be loopback or unix socket: %s", address)
+	}
+	return net.Listen("tcp", address)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
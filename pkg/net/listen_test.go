@@ -0,0 +1,62 @@
+package net
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	tests := []struct {
+		name        string
+		address     string
+		allowRemote bool
+		expectedErr string
+	}{
+		{
+			name:    "loopback ipv4",
+			address: "127.0.0.1:0",
+		},
+		{
+			name:    "localhost",
+			address: "localhost:0",
+		},
+		{
+			name:    "unix socket",
+			address: UnixAddressPrefix + filepath.Join(t.TempDir(), "run", "agent.sock"),
+		},
+		{
+			name:        "all interfaces",
+			address:     ":0",
+			expectedErr: "address must be loopback or unix socket: :0",
+		},
+		{
+			name:        "routable address",
+			address:     "10.0.0.1:9091",
+			expectedErr: "address must be loopback or unix socket: 10.0.0.1:9091",
+		},
+		{
+			name:        "all interfaces allowed",
+			address:     ":0",
+			allowRemote: true,
+		},
+		{
+			name:        "invalid address",
+			address:     "127.0.0.1",
+			expectedErr: "invalid address 127.0.0.1: address 127.0.0.1: missing port in address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := Listen(tt.address, tt.allowRemote)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NoError(t, listener.Close())
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package nftables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+func NewAction() generictables.ActionFactory {
+	return &actionFactory{}
+}
+
+type actionFactory struct{}
+
+func (a *actionFactory) Allow() generictables.Action {
+	return AcceptAction{}
+}
+
+func (a *actionFactory) Goto(target string) generictables.Action {
+	return GotoAction{
+		target: target,
+	}
+}
+
+func (a *actionFactory) Return() generictables.Action {
+	return ReturnAction{}
+}
+
+func (a *actionFactory) Reject(with string) generictables.Action {
+	return RejectAction{with: with}
+}
+
+func (a *actionFactory) Jump(target string) generictables.Action {
+	return JumpToChainAction{target: target}
+}
+
+func (a *actionFactory) Log(prefix string, level uint8) generictables.Action {
+	return LogAction{prefix: prefix, level: level}
+}
+
+func (a *actionFactory) NFLog(group uint16, prefix string) generictables.Action {
+	return NFLogAction{group: group, prefix: prefix}
+}
+
+func (a *actionFactory) Drop() generictables.Action {
+	return DropAction{}
+}
+
+func (a *actionFactory) SetMaskedMark(mark, mask uint32) generictables.Action {
+	return SetMaskedMarkAction{mark: mark, mask: mask}
+}
+
+type AcceptAction struct{}
+
+func (a AcceptAction) ToParameter() string {
+	return "accept"
+}
+
+func (a AcceptAction) String() string {
+	return "ACCEPT"
+}
+
+type RejectAction struct {
+	with string
+}
+
+func (a RejectAction) ToParameter() string {
+	if a.with != "" {
+		return fmt.Sprintf("reject with %s", a.with)
+	}
+	return "reject"
+}
+
+func (a RejectAction) String() string {
+	return "REJECT"
+}
+
+type ReturnAction struct{}
+
+func (a ReturnAction) ToParameter() string {
+	return "return"
+}
+
+func (a ReturnAction) String() string {
+	return "RETURN"
+}
+
+// MaxLogPrefixLength nftables accepts log prefix up to 127 characters
+const MaxLogPrefixLength = 127
+
+// logLevels names of syslog levels in nftables
+var logLevels = []string{"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug"}
+
+type LogAction struct {
+	prefix string
+	level  uint8
+}
+
+func (a LogAction) ToParameter() string {
+	level := logLevels[len(logLevels)-1]
+	if int(a.level) < len(logLevels) {
+		level = logLevels[a.level]
+	}
+	return fmt.Sprintf(`log prefix "%s" level %s`, generictables.LogPrefix(a.prefix, MaxLogPrefixLength), level)
+}
+
+func (a LogAction) String() string {
+	return "LOG"
+}
+
+// NFLogAction logs to a nfnetlink_log group, as the NFLOG target of iptables
+type NFLogAction struct {
+	group  uint16
+	prefix string
+}
+
+func (a NFLogAction) ToParameter() string {
+	prefix := strings.ReplaceAll(a.prefix, `"`, "")
+	if len(prefix) > MaxLogPrefixLength {
+		prefix = prefix[:MaxLogPrefixLength]
+	}
+	return fmt.Sprintf(`log prefix "%s" group %d`, prefix, a.group)
+}
+
+func (a NFLogAction) String() string {
+	return "NFLOG"
+}
+
+// GotoAction and JumpToChainAction carry the generic chain name. The table maps it to the
+// family qualified nftables chain name when rendering, see Table.chainName.
+type GotoAction struct {
+	target string
+}
+
+func (a GotoAction) ToParameter() string {
+	return fmt.Sprintf("goto %s", a.target)
+}
+
+func (a GotoAction) String() string {
+	return fmt.Sprintf("GOTO->%s", a.target)
+}
+
+type JumpToChainAction struct {
+	target string
+}
+
+func (a JumpToChainAction) ToParameter() string {
+	return fmt.Sprintf("jump %s", a.target)
+}
+
+func (a JumpToChainAction) String() string {
+	return fmt.Sprintf("JUMP->%s", a.target)
+}
+
+type DropAction struct{}
+
+func (a DropAction) ToParameter() string {
+	return "drop"
+}
+
+func (a DropAction) String() string {
+	return "DROP"
+}
+
+type SetMaskedMarkAction struct {
+	mark uint32
+	mask uint32
+}
+
+func (a SetMaskedMarkAction) ToParameter() string {
+	return fmt.Sprintf("meta mark set mark & %#x ^ %#x", ^a.mask, a.mark&a.mask)
+}
+
+func (a SetMaskedMarkAction) String() string {
+	return fmt.Sprintf("SET-MARK->%#x/%#x", a.mark, a.mask)
+}
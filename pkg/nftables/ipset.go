@@ -0,0 +1,389 @@
+package nftables
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/net"
+)
+
+const (
+	setNamePrefix = "BAMBOO-"
+
+	setTypeV4 = "ipv4_addr"
+	setTypeV6 = "ipv6_addr"
+	// netPortSetTypeSuffix concatenates the port to the address type for sets of net,port members
+	netPortSetTypeSuffix = " . inet_service"
+)
+
+var (
+	setRegexp     = regexp.MustCompile(`^\s*set (` + setNamePrefix + `[a-zA-Z0-9_-]+) \{`)
+	setTypeRegexp = regexp.MustCompile(`^\s*type (.+)$`)
+)
+
+// IPSet manages our network sets as named sets of our nftables table. It is the nftables
+// counterpart of ipset.IPSet, so rules rendered by the nftables backend can reference them with @name.
+type IPSet struct {
+	tableName string
+	ipVersion int
+	setType   string
+	// setFromDatastore network sets from datastore
+	setFromDatastore map[string]map[string]struct{}
+	// setFromDataplane sets from dataplane
+	setFromDataplane map[string]map[string]struct{}
+	// unusedSet list of unused set
+	unusedSet map[string]struct{}
+
+	// inSyncWithDataplane get sets from dataplane done
+	inSyncWithDataplane bool
+
+	// dryRun is set in observe-only mode, transactions are reported instead of executed
+	dryRun *generictables.DryRun
+	// destroyDryRun reports the transactions deleting unused sets, apart from dryRun so that both
+	// transactions are kept instead of replacing each other every refresh
+	destroyDryRun *generictables.DryRun
+
+	// executor runs nft commands
+	executor executor.Executor
+}
+
+func NewIPSet(tableName string, ipVersion int, opts ...setOption) (*IPSet, error) {
+	set := &IPSet{
+		tableName: tableName,
+		executor:  executor.OS{},
+	}
+	if ipVersion == generictables.IPFamily6 {
+		set.ipVersion = generictables.IPFamily6
+		set.setType = setTypeV6
+	} else {
+		set.ipVersion = generictables.IPFamily4
+		set.setType = setTypeV4
+	}
+	for _, opt := range opts {
+		opt(set)
+	}
+	if err := checkNFTCmd(set.executor); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (i *IPSet) GetIPVersion() int {
+	return i.ipVersion
+}
+
+func (i *IPSet) UpdateIPSet(ipset map[string]map[string]struct{}) {
+	i.setFromDatastore = ipset
+}
+
+func (i *IPSet) Apply() error {
+	if !i.inSyncWithDataplane {
+		i.loadFromDataplane()
+		if !i.inSyncWithDataplane {
+			applyFailures.WithLabelValues(metricKindSet, strconv.Itoa(i.ipVersion)).Inc()
+			return errors.New("get nftables sets from dataplane failed")
+		}
+	}
+
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	var err error
+	for {
+		err = i.apply()
+		if err != nil {
+			slog.Warn("apply nftables set failed. Retrying", "err", err, "type", i.setType)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				slog.Error("apply nftables set fail after retry.", "err", err, "type", i.setType)
+				applyFailures.WithLabelValues(metricKindSet, strconv.Itoa(i.ipVersion)).Inc()
+				break
+			}
+			continue
+		}
+		break
+	}
+	i.inSyncWithDataplane = false
+	return err
+}
+
+// apply writes the sets that differ from dataplane. Sets are created with auto-merge so that
+// overlapping networks are accepted like hash:net does, hence a changed set is flushed and refilled
+// instead of adding and deleting single elements.
+func (i *IPSet) apply() error {
+	slog.Debug("start applying nftables set", "setFromDatastore", i.setFromDatastore,
+		"setFromDataplane", i.setFromDataplane, "type", i.setType)
+	defer slog.Debug("finish applying nftables set", "type", i.setType)
+	buf := new(TransactionBuilder)
+	buf.StartTransaction(tableFamily, i.tableName)
+
+	for _, name := range sortedKeys(i.setFromDatastore) {
+		members, isNetPort := setElements(i.setFromDatastore[name])
+		currentMembers, ok := i.setFromDataplane[name]
+		if !ok {
+			if isNetPort {
+				// auto-merge does not support concatenations, members of net,port sets never overlap
+				buf.WriteLine(fmt.Sprintf("add set %s %s %s { type %s ; flags interval ; }",
+					tableFamily, i.tableName, name, i.setType+netPortSetTypeSuffix))
+			} else {
+				buf.WriteLine(fmt.Sprintf("add set %s %s %s { type %s ; flags interval ; auto-merge ; }",
+					tableFamily, i.tableName, name, i.setType))
+			}
+		} else if isSameMembers(members, currentMembers) {
+			continue
+		} else {
+			buf.WriteLine(fmt.Sprintf("flush set %s %s %s", tableFamily, i.tableName, name))
+		}
+
+		var elements []string
+		for _, member := range sortedKeys(members) {
+			if member == "" {
+				continue
+			}
+			elements = append(elements, member)
+		}
+		if len(elements) > 0 {
+			buf.WriteLine(fmt.Sprintf("add element %s %s %s { %s }", tableFamily, i.tableName, name, strings.Join(elements, ", ")))
+		}
+	}
+	// get unused set to remove later
+	i.unusedSet = make(map[string]struct{})
+	for name := range i.setFromDataplane {
+		if _, ok := i.setFromDatastore[name]; !ok {
+			i.unusedSet[name] = struct{}{}
+		}
+	}
+
+	buf.EndTransaction()
+	if buf.IsEmpty() {
+		return nil
+	}
+	return i.execTransaction(buf, i.dryRun)
+}
+
+func (i *IPSet) CleanUnusedSet() {
+	if len(i.unusedSet) == 0 {
+		return
+	}
+
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	for {
+		err := i.cleanUnusedSet()
+		if err != nil {
+			slog.Warn("clean nftables set failed. Retrying", "err", err, "type", i.setType)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				slog.Error("clean nftables set fail after retry.", "err", err, "type", i.setType)
+				break
+			}
+			continue
+		}
+		break
+	}
+}
+
+// Clean deletes all our sets of this family, whatever the sets from datastore are
+func (i *IPSet) Clean() error {
+	i.loadFromDataplane()
+	if !i.inSyncWithDataplane {
+		return errors.New("get nftables sets from dataplane failed")
+	}
+	i.inSyncWithDataplane = false
+	i.unusedSet = make(map[string]struct{})
+	for name := range i.setFromDataplane {
+		i.unusedSet[name] = struct{}{}
+	}
+
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	var err error
+	for {
+		err = i.cleanUnusedSet()
+		if err != nil {
+			slog.Warn("delete nftables set failed. Retrying", "err", err, "type", i.setType)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				slog.Error("delete nftables set fail after retry.", "err", err, "type", i.setType)
+				break
+			}
+			continue
+		}
+		break
+	}
+	return err
+}
+
+func (i *IPSet) cleanUnusedSet() error {
+	slog.Debug("start cleaning unused set", "unusedSet", i.unusedSet, "type", i.setType)
+	defer slog.Debug("finish clean unused set", "type", i.setType)
+	buf := new(TransactionBuilder)
+	buf.StartTransaction(tableFamily, i.tableName)
+	for _, name := range sortedKeys(i.unusedSet) {
+		buf.WriteLine(fmt.Sprintf("delete set %s %s %s", tableFamily, i.tableName, name))
+	}
+	buf.EndTransaction()
+	if buf.IsEmpty() {
+		return nil
+	}
+	dryRun := i.destroyDryRun
+	if dryRun == nil {
+		dryRun = i.dryRun
+	}
+	return i.execTransaction(buf, dryRun)
+}
+
+func (i *IPSet) loadFromDataplane() {
+	slog.Debug("start loading nftables set from dataplane", "type", i.setType)
+	ruleset, err := listTable(i.executor, tableFamily, i.tableName)
+	if err != nil {
+		slog.Error("Get nftables sets from Dataplane failed", "err", err, "type", i.setType)
+		return
+	}
+	sets, err := i.readSetsFrom(ruleset)
+	if err != nil {
+		slog.Error("Read nftables sets from Dataplane failed", "err", err, "type", i.setType)
+		return
+	}
+	i.setFromDataplane = sets
+	i.inSyncWithDataplane = true
+	slog.Debug("finish load nftables set from dataplane", "sets", i.setFromDataplane, "type", i.setType)
+}
+
+// readSetsFrom reads our sets of this family from the output of "nft list table"
+// example:
+/*
+	set BAMBOO-gnsv4-0-example {
+		type ipv4_addr
+		flags interval
+		auto-merge
+		elements = { 10.0.0.0/8, 192.168.1.1,
+			     192.168.1.2 }
+	}
+*/
+func (i *IPSet) readSetsFrom(ruleset []byte) (map[string]map[string]struct{}, error) {
+	sets := make(map[string]map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(ruleset))
+	var (
+		currentSet      string
+		currentSetType  string
+		currentElements []string
+		inElements      bool
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if captures := setRegexp.FindStringSubmatch(scanner.Text()); captures != nil {
+			currentSet = captures[1]
+			currentSetType = ""
+			currentElements = nil
+			continue
+		}
+		if currentSet == "" {
+			continue
+		}
+
+		if inElements || strings.HasPrefix(line, "elements = {") {
+			line = strings.TrimPrefix(line, "elements = {")
+			inElements = !strings.HasSuffix(line, "}")
+			line = strings.TrimSuffix(line, "}")
+			for _, element := range strings.Split(line, ",") {
+				if element = strings.TrimSpace(element); element != "" {
+					currentElements = append(currentElements, element)
+				}
+			}
+			continue
+		}
+		if captures := setTypeRegexp.FindStringSubmatch(line); captures != nil {
+			currentSetType = captures[1]
+			continue
+		}
+		if line == "}" {
+			if currentSetType == i.setType || currentSetType == i.setType+netPortSetTypeSuffix {
+				members := make(map[string]struct{})
+				for _, element := range currentElements {
+					members[normalizeElement(element)] = struct{}{}
+				}
+				sets[currentSet] = members
+			}
+			currentSet = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanner error: %w", err)
+	}
+	return sets, nil
+}
+
+// normalizeElement formats element the same way as members from datastore. nft prints a single ip
+// without prefix length. Ranges created by auto-merge are kept as they are.
+func normalizeElement(element string) string {
+	if ip, port, ok := strings.Cut(element, " . "); ok {
+		return normalizeElement(ip) + " . " + port
+	}
+	_, ipnet, err := net.ParseCIDROrIP(element)
+	if err != nil {
+		return element
+	}
+	return ipnet.String()
+}
+
+// setElements converts the members of a set to nftables elements. Members of hash:net,port sets
+// (10.0.0.0/8,tcp:80) become concatenations(10.0.0.0/8 . 80), the protocol is matched by rules.
+func setElements(members map[string]struct{}) (map[string]struct{}, bool) {
+	elements := make(map[string]struct{}, len(members))
+	var isNetPort bool
+	for member := range members {
+		ip, protocolPort, ok := strings.Cut(member, ",")
+		if !ok {
+			elements[member] = struct{}{}
+			continue
+		}
+		isNetPort = true
+		_, port, _ := strings.Cut(protocolPort, ":")
+		elements[ip+" . "+port] = struct{}{}
+	}
+	return elements, isNetPort
+}
+
+func isSameMembers(desired, current map[string]struct{}) bool {
+	count := 0
+	for member := range desired {
+		if member == "" {
+			continue
+		}
+		if _, ok := current[normalizeElement(member)]; !ok {
+			return false
+		}
+		count++
+	}
+	return count == len(current)
+}
+
+func (i *IPSet) execTransaction(buf *TransactionBuilder, dryRun *generictables.DryRun) error {
+	if dryRun != nil {
+		dryRun.Skip(nftCmd, i.ipVersion, buf.Bytes())
+		return nil
+	}
+	return execTransaction(i.executor, buf.Bytes(), metricKindSet, i.ipVersion)
+}
@@ -0,0 +1,238 @@
+package nftables
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+var (
+	_ generictables.MatchCriteria = (*matchBuilder)(nil)
+)
+
+// portProtocols protocols whose header starts with the source and destination ports matched by th
+const portProtocols = "{ tcp, udp, udplite, sctp }"
+
+// NewMatch returns a match builder for the given ip family. The family decides whether address
+// matches are rendered with the ip or ip6 payload expression.
+func NewMatch(ipVersion int) generictables.MatchCriteria {
+	return matchBuilder{ipVersion: ipVersion}
+}
+
+type matchBuilder struct {
+	ipVersion   int
+	expressions []string
+}
+
+func (m matchBuilder) Render() string {
+	return strings.Join(m.withPortProtocol().expressions, " ")
+}
+
+// withPortProtocol restricts a match of ports without protocol to the protocols having ports, as iptables
+// requires -p with port matches. th matches the same offset in the header of any protocol otherwise.
+func (m matchBuilder) withPortProtocol() matchBuilder {
+	portIndex := slices.IndexFunc(m.expressions, func(expression string) bool {
+		return strings.Contains(expression, "th sport") || strings.Contains(expression, "th dport")
+	})
+	if portIndex < 0 || slices.ContainsFunc(m.expressions, func(expression string) bool {
+		return strings.HasPrefix(expression, "meta l4proto ") && !strings.HasPrefix(expression, "meta l4proto != ")
+	}) {
+		return m
+	}
+	m.expressions = slices.Insert(slices.Clone(m.expressions), portIndex, "meta l4proto "+portProtocols)
+	return m
+}
+
+func (m matchBuilder) String() string {
+	return fmt.Sprintf("Match[%v]", m.expressions)
+}
+
+func (m matchBuilder) Copy() generictables.MatchCriteria {
+	mCopy := matchBuilder{ipVersion: m.ipVersion}
+	mCopy.expressions = append(mCopy.expressions, m.expressions...)
+	return mCopy
+}
+
+func (m matchBuilder) Merge(match generictables.MatchCriteria) generictables.MatchCriteria {
+	if match == nil {
+		return m
+	}
+	mBuilder := match.(matchBuilder)
+	return m.append(mBuilder.expressions...)
+}
+
+func (m matchBuilder) append(expressions ...string) matchBuilder {
+	merged := make([]string, 0, len(m.expressions)+len(expressions))
+	merged = append(merged, m.expressions...)
+	m.expressions = append(merged, expressions...)
+	return m
+}
+
+func (m matchBuilder) ConntrackState(stateNames string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("ct state %s", conntrackStates(stateNames)))
+}
+
+func (m matchBuilder) NotConntrackState(stateNames string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("ct state != %s", conntrackStates(stateNames)))
+}
+
+func (m matchBuilder) Protocol(protocol interface{}) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta l4proto %v", protocol))
+}
+
+func (m matchBuilder) NotProtocol(protocol interface{}) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta l4proto != %v", protocol))
+}
+
+func (m matchBuilder) ProtocolNum(num uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta l4proto %d", num))
+}
+
+func (m matchBuilder) NotProtocolNum(num uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta l4proto != %d", num))
+}
+
+func (m matchBuilder) SourceNet(net string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s saddr %s", m.addressFamily(), net))
+}
+
+func (m matchBuilder) NotSourceNet(net string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s saddr != %s", m.addressFamily(), net))
+}
+
+func (m matchBuilder) DestNet(net string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s daddr %s", m.addressFamily(), net))
+}
+
+func (m matchBuilder) NotDestNet(net string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s daddr != %s", m.addressFamily(), net))
+}
+
+func (m matchBuilder) SourceIPSet(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s saddr @%s", m.addressFamily(), name))
+}
+
+func (m matchBuilder) NotSourceIPSet(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s saddr != @%s", m.addressFamily(), name))
+}
+
+func (m matchBuilder) DestIPSet(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s daddr @%s", m.addressFamily(), name))
+}
+
+func (m matchBuilder) NotDestIPSet(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s daddr != @%s", m.addressFamily(), name))
+}
+
+func (m matchBuilder) DestNetPortIPSet(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("%s daddr . th dport @%s", m.addressFamily(), name))
+}
+
+func (m matchBuilder) SourcePorts(ports []string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("th sport %s", portSet(ports)))
+}
+
+func (m matchBuilder) NotSourcePorts(ports []string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("th sport != %s", portSet(ports)))
+}
+
+func (m matchBuilder) DestPorts(ports []string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("th dport %s", portSet(ports)))
+}
+
+func (m matchBuilder) NotDestPorts(ports []string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("th dport != %s", portSet(ports)))
+}
+
+func (m matchBuilder) ICMPV4Type(icmpType uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmp type %d", icmpType))
+}
+
+func (m matchBuilder) NotICMPV4Type(icmpType uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmp type != %d", icmpType))
+}
+
+func (m matchBuilder) ICMPV4TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmp type %d icmp code %d", icmpType, code))
+}
+
+// NotICMPV4TypeAndCode matches packets that are not of both type and code, hence the concatenation
+func (m matchBuilder) NotICMPV4TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmp type . icmp code != { %d . %d }", icmpType, code))
+}
+
+func (m matchBuilder) ICMPV6Type(icmpType uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmpv6 type %d", icmpType))
+}
+
+func (m matchBuilder) NotICMPV6Type(icmpType uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmpv6 type != %d", icmpType))
+}
+
+func (m matchBuilder) ICMPV6TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmpv6 type %d icmpv6 code %d", icmpType, code))
+}
+
+func (m matchBuilder) NotICMPV6TypeAndCode(icmpType, code uint8) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("icmpv6 type . icmpv6 code != { %d . %d }", icmpType, code))
+}
+
+func (m matchBuilder) Limit(rate string, burst int) generictables.MatchCriteria {
+	if burst > 0 {
+		return m.append(fmt.Sprintf("limit rate %s burst %d packets", rate, burst))
+	}
+	return m.append(fmt.Sprintf("limit rate %s", rate))
+}
+
+func (m matchBuilder) InInterface(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf(`iifname "%s"`, name))
+}
+
+func (m matchBuilder) OutInterface(name string) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf(`oifname "%s"`, name))
+}
+
+func (m matchBuilder) MarkMatchesWithMask(mark, mask uint32) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta mark & %#x == %#x", mask, mark))
+}
+
+func (m matchBuilder) NotMarkMatchesWithMask(mark, mask uint32) generictables.MatchCriteria {
+	return m.append(fmt.Sprintf("meta mark & %#x != %#x", mask, mark))
+}
+
+// nfProto restricts the rule to the family of the builder. It is needed for rules of base chains
+// because our table is an inet table and sees both ipv4 and ipv6 packets.
+func (m matchBuilder) nfProto() matchBuilder {
+	return m.append(fmt.Sprintf("meta nfproto %s", nfProtoName(m.ipVersion)))
+}
+
+func (m matchBuilder) addressFamily() string {
+	if m.ipVersion == generictables.IPFamily6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func nfProtoName(ipVersion int) string {
+	if ipVersion == generictables.IPFamily6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// conntrackStates converts iptables style state names(ESTABLISHED,RELATED) to a nftables set
+func conntrackStates(stateNames string) string {
+	states := strings.Split(strings.ToLower(stateNames), ",")
+	return fmt.Sprintf("{ %s }", strings.Join(states, ", "))
+}
+
+// portSet converts iptables style ports(80, 1000:2000) to a nftables anonymous set
+func portSet(ports []string) string {
+	nftPorts := make([]string, 0, len(ports))
+	for _, port := range ports {
+		nftPorts = append(nftPorts, strings.Replace(port, ":", "-", 1))
+	}
+	return fmt.Sprintf("{ %s }", strings.Join(nftPorts, ", "))
+}
@@ -0,0 +1,58 @@
+package nftables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+func TestMatchPortProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		match    generictables.MatchCriteria
+		expected string
+	}{
+		{
+			name:     "ports with protocol",
+			match:    NewMatch(generictables.IPFamily4).Protocol("tcp").DestPorts([]string{"80"}),
+			expected: "meta l4proto tcp th dport { 80 }",
+		},
+		{
+			name:     "ports without protocol",
+			match:    NewMatch(generictables.IPFamily4).SourceNet("10.0.0.0/8").DestPorts([]string{"80", "443"}),
+			expected: "ip saddr 10.0.0.0/8 meta l4proto { tcp, udp, udplite, sctp } th dport { 80, 443 }",
+		},
+		{
+			name:     "negated ports without protocol",
+			match:    NewMatch(generictables.IPFamily6).NotSourcePorts([]string{"53"}),
+			expected: "meta l4proto { tcp, udp, udplite, sctp } th sport != { 53 }",
+		},
+		{
+			name:     "ports with negated protocol",
+			match:    NewMatch(generictables.IPFamily4).NotProtocol("tcp").DestPorts([]string{"53"}),
+			expected: "meta l4proto != tcp meta l4proto { tcp, udp, udplite, sctp } th dport { 53 }",
+		},
+		{
+			name:     "protocol merged with ports",
+			match:    NewMatch(generictables.IPFamily4).Protocol("udp").Merge(NewMatch(generictables.IPFamily4).DestPorts([]string{"53"})),
+			expected: "meta l4proto udp th dport { 53 }",
+		},
+		{
+			name:     "net and port set",
+			match:    NewMatch(generictables.IPFamily4).DestNetPortIPSet("BAMBOO-netportv4-0123abcd"),
+			expected: "meta l4proto { tcp, udp, udplite, sctp } ip daddr . th dport @BAMBOO-netportv4-0123abcd",
+		},
+		{
+			name:     "no ports",
+			match:    NewMatch(generictables.IPFamily4).SourceNet("10.0.0.0/8"),
+			expected: "ip saddr 10.0.0.0/8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.match.Render())
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package nftables
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	metricKindTable = "table"
+	metricKindSet   = "set"
+)
+
+var (
+	transactionDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "bamboo_nftables_transaction_duration_seconds",
+		Help: "Time taken to run nft -f.",
+	}, []string{"kind", "ip_version"})
+	transactionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_nftables_transaction_errors_total",
+		Help: "Number of nft -f failures, retries included.",
+	}, []string{"kind", "ip_version"})
+	applyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_nftables_apply_failures_total",
+		Help: "Number of applies that still failed after all retries.",
+	}, []string{"kind", "ip_version"})
+	driftEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bamboo_nftables_drifts_total",
+		Help: "Number of our rules and chains changed outside of agent since the last apply.",
+	}, []string{"ip_version", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(transactionDurationSeconds, transactionErrors, applyFailures, driftEvents)
+}
@@ -0,0 +1,73 @@
+package nftables
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bamboo-firewall/agent/pkg/executor"
+)
+
+const (
+	// TableName name of our table in nftables. It is an inet table shared by ipv4 and ipv6.
+	TableName = "bamboo"
+
+	tableFamily = "inet"
+
+	nftCmd = "nft"
+)
+
+func checkNFTCmd(e executor.Executor) error {
+	_, err := e.LookPath(nftCmd)
+	if err != nil {
+		return errors.New("nft not found in $PATH")
+	}
+	return nil
+}
+
+// execTransaction applies content atomically with nft -f. kind and ipVersion label the metrics.
+func execTransaction(e executor.Executor, content []byte, kind string, ipVersion int) error {
+	args := []string{"-f", "-"}
+	cmdLine := executor.CommandLine(nftCmd, args)
+	slog.Debug("exec nft", "cmd", cmdLine, "content", string(content))
+	startTime := time.Now()
+	stdout, stderr, err := e.Run(nftCmd, args, content)
+	transactionDurationSeconds.WithLabelValues(kind, strconv.Itoa(ipVersion)).Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		transactionErrors.WithLabelValues(kind, strconv.Itoa(ipVersion)).Inc()
+		slog.Error("nft fail", "cmd", cmdLine, "input", string(content), "stdout", string(stdout), "stderr", string(stderr))
+		return fmt.Errorf("nft failed. stderr: %s . err: %w", stderr, err)
+	}
+	return nil
+}
+
+// DeleteTable deletes our table with the chains and sets of both ip families, nothing is done when the table
+// does not exist. The transaction is labelled with ip version 0 in metrics, the table is shared by both families.
+func DeleteTable(e executor.Executor, tableName string) error {
+	if err := checkNFTCmd(e); err != nil {
+		return err
+	}
+	ruleset, err := listTable(e, tableFamily, tableName)
+	if err != nil {
+		return err
+	}
+	if ruleset == nil {
+		return nil
+	}
+	return execTransaction(e, []byte(fmt.Sprintf("delete table %s %s\n", tableFamily, tableName)), metricKindTable, 0)
+}
+
+// listTable returns the ruleset of table. Empty output is returned when the table does not exist.
+func listTable(e executor.Executor, family, tableName string) ([]byte, error) {
+	stdout, stderr, err := e.Run(nftCmd, []string{"list", "table", family, tableName}, nil)
+	if err != nil {
+		if strings.Contains(string(stderr), "No such file or directory") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list table failed. stderr: %s . err: %w", stderr, err)
+	}
+	return stdout, nil
+}
@@ -0,0 +1,118 @@
+package nftables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const (
+	// maxCommentLength nftables limits a rule comment to 128 bytes
+	maxCommentLength = 128
+)
+
+type Renderer interface {
+	RenderAdd(rule *generictables.Rule, chainName string, hash string) string
+	RenderAddChain(chainName string, hook string) string
+	RenderFlushChain(chainName string) string
+	RenderDeleteChain(chainName string) string
+	RuleHashes(c *generictables.Chain) []string
+}
+
+func NewRenderer(family, tableName, hashCommentPrefix string, chainNameFn func(string) string) Renderer {
+	return &renderer{
+		family:            family,
+		tableName:         tableName,
+		hashCommentPrefix: hashCommentPrefix,
+		chainNameFn:       chainNameFn,
+	}
+}
+
+type renderer struct {
+	family            string
+	tableName         string
+	hashCommentPrefix string
+	// chainNameFn maps the generic chain name of jump and goto actions to the chain name in nftables
+	chainNameFn func(string) string
+}
+
+func (r *renderer) RenderAdd(rule *generictables.Rule, chainName string, hash string) string {
+	var options []string
+	options = append(options, fmt.Sprintf("add rule %s %s %s", r.family, r.tableName, chainName))
+
+	if rule.Match != nil {
+		matchParameter := rule.Match.Render()
+		if matchParameter != "" {
+			options = append(options, matchParameter)
+		}
+	}
+
+	if rule.Action != nil {
+		actionParameter := r.actionParameter(rule.Action)
+		if actionParameter != "" {
+			options = append(options, actionParameter)
+		}
+	}
+
+	if comment := r.comment(hash, rule.Comment); comment != "" {
+		options = append(options, comment)
+	}
+
+	return strings.Join(options, " ")
+}
+
+// RenderAddChain renders chain creation. "add chain" does not fail when the chain already exists.
+// hook is empty for regular chain, otherwise the chain is a base chain attached to that hook.
+func (r *renderer) RenderAddChain(chainName string, hook string) string {
+	if hook == "" {
+		return fmt.Sprintf("add chain %s %s %s", r.family, r.tableName, chainName)
+	}
+	return fmt.Sprintf("add chain %s %s %s { type filter hook %s priority 0 ; policy accept ; }",
+		r.family, r.tableName, chainName, hook)
+}
+
+func (r *renderer) RenderFlushChain(chainName string) string {
+	return fmt.Sprintf("flush chain %s %s %s", r.family, r.tableName, chainName)
+}
+
+func (r *renderer) RenderDeleteChain(chainName string) string {
+	return fmt.Sprintf("delete chain %s %s %s", r.family, r.tableName, chainName)
+}
+
+func (r *renderer) RuleHashes(c *generictables.Chain) []string {
+	renderFn := func(rule *generictables.Rule, chainName string) string {
+		return r.RenderAdd(rule, chainName, "HASH")
+	}
+	return generictables.RuleHashes(c, renderFn)
+}
+
+func (r *renderer) actionParameter(action generictables.Action) string {
+	switch a := action.(type) {
+	case JumpToChainAction:
+		return JumpToChainAction{target: r.chainNameFn(a.target)}.ToParameter()
+	case GotoAction:
+		return GotoAction{target: r.chainNameFn(a.target)}.ToParameter()
+	default:
+		return action.ToParameter()
+	}
+}
+
+// comment nftables only allows one comment per rule, so the hash and the comments of rule are joined
+func (r *renderer) comment(hash string, comments []string) string {
+	var parts []string
+	if hash != "" {
+		parts = append(parts, r.hashCommentPrefix+hash)
+	}
+	for _, comment := range comments {
+		parts = append(parts, strings.ReplaceAll(comment, `"`, "'"))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	comment := strings.Join(parts, "; ")
+	if len(comment) > maxCommentLength {
+		comment = comment[:maxCommentLength]
+	}
+	return fmt.Sprintf(`comment "%s"`, comment)
+}
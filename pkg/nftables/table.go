@@ -0,0 +1,429 @@
+package nftables
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+var (
+	chainRegexp = regexp.MustCompile(`^\s*chain (\S+) \{`)
+)
+
+// Table implements generictables.Table on top of a nftables inet table.
+// The table is shared by the ipv4 and ipv6 Table, so every chain name is qualified by the family
+// (ip-BAMBOO-INPUT, ip6-BAMBOO-INPUT). Default chains(INPUT, OUTPUT) are base chains of our table
+// hooked to the matching netfilter hook.
+type Table struct {
+	name      string
+	ipVersion int
+	renderer  Renderer
+
+	// hashCommentRegexp matches the rule-tracking comment, capturing the rule hash.
+	hashCommentRegexp *regexp.Regexp
+	// chainPrefix prefix of all chains of this family in our table
+	chainPrefix string
+
+	// chainHashesFromDataplane contains the rules hashes that we think are in the dataplane, indexed by nftables chain name.
+	chainHashesFromDataplane map[string][]string
+
+	// chainNameToChain contains the desired state of our chain(get from api-server), indexed by chain name.
+	chainNameToChain map[string]*generictables.Chain
+
+	// defaultOurRuleOfDefaultChain contain our rule in default chain
+	defaultOurRuleOfDefaultChain map[string]generictables.Rule
+
+	// expectedChainHashes hashes of our chains written by the last apply, indexed by nftables chain name. It is
+	// nil when there is nothing to compare dataplane with: before the first apply, after a clean or once its
+	// drift is reported
+	expectedChainHashes map[string][]string
+
+	// needCleanToDataplane clean all our rules and chains
+	needCleanToDataplane bool
+	// inSyncWithDataplane get policy from dataplane done
+	inSyncWithDataplane bool
+
+	// dryRun is set in observe-only mode, transactions are reported instead of executed
+	dryRun *generictables.DryRun
+
+	// executor runs nft commands
+	executor executor.Executor
+}
+
+func NewTable(name string, hashPrefix string, opts ...option) (*Table, error) {
+	if name == "" {
+		return nil, fmt.Errorf("table name is empty")
+	}
+	if hashPrefix == "" {
+		return nil, fmt.Errorf("hash prefix is empty")
+	}
+
+	t := &Table{
+		name:                         name,
+		ipVersion:                    generictables.IPFamily4,
+		chainNameToChain:             make(map[string]*generictables.Chain),
+		defaultOurRuleOfDefaultChain: make(map[string]generictables.Rule),
+		executor:                     executor.OS{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if err := checkNFTCmd(t.executor); err != nil {
+		return nil, err
+	}
+
+	if t.ipVersion == generictables.IPFamily6 {
+		t.chainPrefix = "ip6-"
+	} else {
+		t.chainPrefix = "ip-"
+	}
+	t.renderer = NewRenderer(tableFamily, name, hashPrefix, t.chainName)
+	t.hashCommentRegexp = newHashCommentRegexp(hashPrefix)
+
+	slog.Debug("nftables info", "table", t.name, "family", tableFamily, "ipVersion", t.ipVersion)
+
+	return t, nil
+}
+
+func newHashCommentRegexp(hashPrefix string) *regexp.Regexp {
+	return regexp.MustCompile(`comment "` + hashPrefix + `([a-zA-Z0-9_-]+)`)
+}
+
+// chainName returns name of chain in nftables
+func (t *Table) chainName(name string) string {
+	return t.chainPrefix + name
+}
+
+func (t *Table) GetName() string {
+	return t.name
+}
+
+func (t *Table) GetIPVersion() int {
+	return t.ipVersion
+}
+
+func (t *Table) SetDefaultRuleOfDefaultChain(chainName string, rule generictables.Rule) {
+	t.defaultOurRuleOfDefaultChain[chainName] = rule
+}
+
+// UpdateChains update rules of our chain
+func (t *Table) UpdateChains(chains []*generictables.Chain) {
+	t.chainNameToChain = make(map[string]*generictables.Chain)
+	for _, chain := range chains {
+		t.UpdateChain(chain)
+	}
+}
+
+func (t *Table) UpdateChain(chain *generictables.Chain) {
+	t.chainNameToChain[chain.Name] = chain
+}
+
+func (t *Table) NeedClean() {
+	t.needCleanToDataplane = true
+}
+
+func (t *Table) Apply() error {
+	if !t.inSyncWithDataplane {
+		t.loadFromDataplane()
+		if !t.inSyncWithDataplane {
+			// applying without the view of dataplane would add chains that already exist and fail the
+			// whole transaction, or keep chains that should be deleted
+			applyFailures.WithLabelValues(metricKindTable, strconv.Itoa(t.ipVersion)).Inc()
+			return errors.New("load nftables table from dataplane failed")
+		}
+		t.reportDrifts(t.driftsOf(t.chainHashesFromDataplane))
+	}
+	cleaning := t.needCleanToDataplane
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	var err error
+	for {
+		err = t.apply()
+		if err != nil {
+			slog.Warn("apply rule failed. Retrying", "table", t.name, "err", err)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				slog.Error("apply rule fail after retry.", "table", t.name, "err", err)
+				applyFailures.WithLabelValues(metricKindTable, strconv.Itoa(t.ipVersion)).Inc()
+				break
+			}
+			continue
+		}
+		break
+	}
+	t.expectedChainHashes = nil
+	if err == nil && !cleaning && t.dryRun == nil && len(t.chainNameToChain) > 0 {
+		t.expectedChainHashes = t.desiredHashes()
+	}
+	t.inSyncWithDataplane = false
+	t.needCleanToDataplane = false
+	return err
+}
+
+// CheckDrift compares the rules in dataplane with the rules of the last apply. Drifts are reported once,
+// the next apply repairs them.
+func (t *Table) CheckDrift() ([]generictables.Drift, error) {
+	if t.expectedChainHashes == nil {
+		return nil, nil
+	}
+	hashes, err := t.getHashesFromDataplane()
+	if err != nil {
+		return nil, err
+	}
+	drifts := t.driftsOf(hashes)
+	t.reportDrifts(drifts)
+	return drifts, nil
+}
+
+// desiredHashes returns the hashes of our chains and base chains in nftables name
+func (t *Table) desiredHashes() map[string][]string {
+	chains, _ := t.desiredChains()
+	hashes := make(map[string][]string, len(chains))
+	for chainName, chain := range chains {
+		hashes[chainName] = t.renderer.RuleHashes(chain)
+	}
+	return hashes
+}
+
+// driftsOf returns the drifts of hashes read from dataplane from the hashes of the last apply
+func (t *Table) driftsOf(hashes map[string][]string) []generictables.Drift {
+	if t.expectedChainHashes == nil {
+		return nil
+	}
+	return generictables.DiffChainHashes(t.expectedChainHashes, hashes)
+}
+
+// reportDrifts logs and counts drifts, the expected hashes are forgotten so that a drift is reported once
+func (t *Table) reportDrifts(drifts []generictables.Drift) {
+	if len(drifts) == 0 {
+		return
+	}
+	for _, drift := range drifts {
+		slog.Warn("dataplane drift detected", "table", t.name, "ipVersion", t.ipVersion, "chain", drift.Chain,
+			"rule", drift.Index, "reason", drift.Reason, "expected", drift.Expected, "actual", drift.Actual)
+		driftEvents.WithLabelValues(strconv.Itoa(t.ipVersion), drift.Reason).Inc()
+	}
+	t.expectedChainHashes = nil
+}
+
+// desiredChains returns our chains and the base chains in nftables name, with the hook of base chains
+func (t *Table) desiredChains() (map[string]*generictables.Chain, map[string]string) {
+	chains := make(map[string]*generictables.Chain)
+	hooks := make(map[string]string)
+	for name, chain := range t.chainNameToChain {
+		chains[t.chainName(name)] = &generictables.Chain{
+			Name:  t.chainName(name),
+			Rules: chain.Rules,
+		}
+	}
+	for name, rule := range t.defaultOurRuleOfDefaultChain {
+		rule.Match = NewMatch(t.ipVersion).(matchBuilder).nfProto().Merge(rule.Match)
+		chains[t.chainName(name)] = &generictables.Chain{
+			Name:  t.chainName(name),
+			Rules: []generictables.Rule{rule},
+		}
+		hooks[t.chainName(name)] = strings.ToLower(name)
+	}
+	return chains, hooks
+}
+
+func (t *Table) apply() error {
+	slog.Debug("start apply policy", "chainNameToChain", t.chainNameToChain, "chainHashesFromDataplane",
+		t.chainHashesFromDataplane, "ipVersion", t.ipVersion)
+	defer slog.Debug("finish apply policy", "ipVersion", t.ipVersion)
+	if t.needCleanToDataplane {
+		return t.Clean()
+	}
+
+	if len(t.chainNameToChain) == 0 {
+		return nil
+	}
+
+	buf := new(TransactionBuilder)
+	buf.StartTransaction(tableFamily, t.name)
+
+	chains, hooks := t.desiredChains()
+	chainNames := sortedKeys(chains)
+
+	// First: create chains, so that every jump of rules has its target
+	for _, chainName := range chainNames {
+		if _, ok := t.chainHashesFromDataplane[chainName]; ok {
+			continue
+		}
+		buf.WriteLine(t.renderer.RenderAddChain(chainName, hooks[chainName]))
+	}
+
+	// Second: rewrite the chains whose rules changed. The whole transaction is atomic, so flushing
+	// the chain does not open a window without rules.
+	for _, chainName := range chainNames {
+		chain := chains[chainName]
+		currentHashes := t.renderer.RuleHashes(chain)
+		previousHashes := t.chainHashesFromDataplane[chainName]
+		if reflect.DeepEqual(previousHashes, currentHashes) {
+			continue
+		}
+		if _, ok := t.chainHashesFromDataplane[chainName]; ok {
+			buf.WriteLine(t.renderer.RenderFlushChain(chainName))
+		}
+		for i := range chain.Rules {
+			buf.WriteLine(t.renderer.RenderAdd(&chain.Rules[i], chainName, currentHashes[i]))
+		}
+	}
+
+	// Third: delete all our unreferenced chains. All of them are flushed before deleting so that
+	// jumps between them do not block the deletion.
+	var unreferencedChains []string
+	for _, chainName := range sortedKeys(t.chainHashesFromDataplane) {
+		if _, ok := chains[chainName]; ok {
+			continue
+		}
+		unreferencedChains = append(unreferencedChains, chainName)
+		buf.WriteLine(t.renderer.RenderFlushChain(chainName))
+	}
+	for _, chainName := range unreferencedChains {
+		buf.WriteLine(t.renderer.RenderDeleteChain(chainName))
+	}
+
+	buf.EndTransaction()
+	if buf.IsEmpty() {
+		slog.Info("No new rules applied", "ipVersion", t.ipVersion)
+	} else {
+		return t.execTransaction(buf)
+	}
+
+	return nil
+}
+
+// Clean all our rules and chains of this family. The table itself is kept because it is shared with
+// the other family and our sets.
+func (t *Table) Clean() error {
+	slog.Debug("start clean policy", "chainHashesFromDataplane", t.chainHashesFromDataplane, "ipVersion", t.ipVersion)
+	defer slog.Debug("finish clean policy", "ipVersion", t.ipVersion)
+	buf := new(TransactionBuilder)
+	buf.StartTransaction(tableFamily, t.name)
+
+	chainNames := sortedKeys(t.chainHashesFromDataplane)
+	for _, chainName := range chainNames {
+		buf.WriteLine(t.renderer.RenderFlushChain(chainName))
+	}
+	for _, chainName := range chainNames {
+		buf.WriteLine(t.renderer.RenderDeleteChain(chainName))
+	}
+
+	buf.EndTransaction()
+	if buf.IsEmpty() {
+		slog.Info("No rule to clean", "table", t.name)
+	} else {
+		return t.execTransaction(buf)
+	}
+
+	return nil
+}
+
+func (t *Table) execTransaction(buf *TransactionBuilder) error {
+	slog.Debug("start exec nft", "ipVersion", t.ipVersion)
+	defer slog.Debug("finish exec nft", "ipVersion", t.ipVersion)
+	if t.dryRun != nil {
+		t.dryRun.Skip(nftCmd, t.ipVersion, buf.Bytes())
+		return nil
+	}
+	return execTransaction(t.executor, buf.Bytes(), metricKindTable, t.ipVersion)
+}
+
+func (t *Table) loadFromDataplane() {
+	slog.Debug("start load from dataplane", "ipVersion", t.ipVersion)
+	hashes, err := t.getHashesFromDataplane()
+	if err != nil {
+		slog.Error("Get hashes from Dataplane failed", "err", err)
+		return
+	}
+	t.chainHashesFromDataplane = hashes
+	t.inSyncWithDataplane = true
+	slog.Debug("finish load from dataplane", "chainHashesFromDataplane", t.chainHashesFromDataplane, "ipVersion", t.ipVersion)
+}
+
+func (t *Table) getHashesFromDataplane() (map[string][]string, error) {
+	retries := 3
+	retryDelay := 100 * time.Millisecond
+
+	for {
+		ruleset, err := listTable(t.executor, tableFamily, t.name)
+		if err != nil {
+			slog.Warn("Get hashes from Dataplane failed. Retrying", "table", t.name, "err", err)
+			if retries > 0 {
+				retries--
+				time.Sleep(retryDelay)
+				retryDelay *= 2
+			} else {
+				return nil, err
+			}
+			continue
+		}
+		return t.readHashesFrom(ruleset)
+	}
+}
+
+// readHashesFrom reads the output of "nft list table" and returns the hashes of rules of our chains
+// for this family. Rules without our comment have an empty hash.
+func (t *Table) readHashesFrom(ruleset []byte) (map[string][]string, error) {
+	hashes := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(ruleset))
+	var currentChain string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if captures := chainRegexp.FindStringSubmatch(scanner.Text()); captures != nil {
+			currentChain = ""
+			if strings.HasPrefix(captures[1], t.chainPrefix) {
+				currentChain = captures[1]
+				hashes[currentChain] = []string{}
+			}
+			continue
+		}
+		if currentChain == "" {
+			continue
+		}
+		if line == "}" {
+			currentChain = ""
+			continue
+		}
+		// skip empty line and the hook definition of base chain
+		if line == "" || strings.HasPrefix(line, "type ") {
+			continue
+		}
+
+		hash := ""
+		if captures := t.hashCommentRegexp.FindStringSubmatch(line); captures != nil {
+			hash = captures[1]
+		}
+		hashes[currentChain] = append(hashes[currentChain], hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanner error: %w", err)
+	}
+	return hashes, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
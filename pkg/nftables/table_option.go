@@ -0,0 +1,52 @@
+package nftables
+
+import (
+	"github.com/bamboo-firewall/agent/pkg/executor"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+type option func(*Table)
+
+func WithIPFamily(family int) option {
+	return func(t *Table) {
+		t.ipVersion = family
+	}
+}
+
+// WithDryRun computes the nft transactions without executing them
+func WithDryRun(dryRun *generictables.DryRun) option {
+	return func(t *Table) {
+		t.dryRun = dryRun
+	}
+}
+
+// WithExecutor runs nft commands with e instead of the commands of host
+func WithExecutor(e executor.Executor) option {
+	return func(t *Table) {
+		t.executor = e
+	}
+}
+
+type setOption func(*IPSet)
+
+// WithSetDryRun computes the nft transactions of sets without executing them
+func WithSetDryRun(dryRun *generictables.DryRun) setOption {
+	return func(i *IPSet) {
+		i.dryRun = dryRun
+	}
+}
+
+// WithSetDestroyDryRun reports the nft transactions deleting unused sets apart from WithSetDryRun, which
+// reports them too when it is not set
+func WithSetDestroyDryRun(dryRun *generictables.DryRun) setOption {
+	return func(i *IPSet) {
+		i.destroyDryRun = dryRun
+	}
+}
+
+// WithSetExecutor runs nft commands of sets with e instead of the commands of host
+func WithSetExecutor(e executor.Executor) setOption {
+	return func(i *IPSet) {
+		i.executor = e
+	}
+}
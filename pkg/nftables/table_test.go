@@ -0,0 +1,220 @@
+package nftables
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+)
+
+const listTableOutput = `table inet bamboo {
+	set BAMBOO-gnsv4-0-example {
+		type ipv4_addr
+		flags interval
+		auto-merge
+		elements = { 10.0.0.0/8, 192.168.1.1,
+			     192.168.1.2 }
+	}
+
+	set BAMBOO-gnsv6-0-example {
+		type ipv6_addr
+		flags interval
+		auto-merge
+		elements = { 2001:db8::/32 }
+	}
+
+	set BAMBOO-netportv4-0123abcd {
+		type ipv4_addr . inet_service
+		flags interval
+		elements = { 10.0.0.0/24 . 80, 10.1.0.1 . 443 }
+	}
+
+	chain ip-INPUT {
+		type filter hook input priority filter; policy accept;
+		meta nfproto ipv4 jump ip-BAMBOO-INPUT comment "bamboo:aaaaaaaaaaaaaaaa; Jump to bamboo input chain"
+	}
+
+	chain ip-BAMBOO-INPUT {
+		ct state { established, related } accept comment "bamboo:bbbbbbbbbbbbbbbb"
+		th dport { 22, 80 } accept
+		drop comment "bamboo:cccccccccccccccc"
+	}
+
+	chain ip6-BAMBOO-INPUT {
+		drop comment "bamboo:dddddddddddddddd"
+	}
+}
+`
+
+func TestReadHashesFrom(t *testing.T) {
+	table := &Table{ipVersion: generictables.IPFamily4}
+	table.chainPrefix = "ip-"
+	table.hashCommentRegexp = newHashCommentRegexp(generictables.HashPrefix)
+
+	hashes, err := table.readHashesFrom([]byte(listTableOutput))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"ip-INPUT":        {"aaaaaaaaaaaaaaaa"},
+		"ip-BAMBOO-INPUT": {"bbbbbbbbbbbbbbbb", "", "cccccccccccccccc"},
+	}, hashes)
+}
+
+func TestReadSetsFrom(t *testing.T) {
+	tests := []struct {
+		name      string
+		ipVersion int
+		expected  map[string]map[string]struct{}
+	}{
+		{
+			name:      "ipv4 sets",
+			ipVersion: generictables.IPFamily4,
+			expected: map[string]map[string]struct{}{
+				"BAMBOO-gnsv4-0-example": {
+					"10.0.0.0/8":     {},
+					"192.168.1.1/32": {},
+					"192.168.1.2/32": {},
+				},
+				"BAMBOO-netportv4-0123abcd": {
+					"10.0.0.0/24 . 80":  {},
+					"10.1.0.1/32 . 443": {},
+				},
+			},
+		},
+		{
+			name:      "ipv6 sets",
+			ipVersion: generictables.IPFamily6,
+			expected: map[string]map[string]struct{}{
+				"BAMBOO-gnsv6-0-example": {
+					"2001:db8::/32": {},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := &IPSet{ipVersion: tt.ipVersion, setType: setTypeV4}
+			if tt.ipVersion == generictables.IPFamily6 {
+				set.setType = setTypeV6
+			}
+			sets, err := set.readSetsFrom([]byte(listTableOutput))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, sets)
+		})
+	}
+}
+
+func TestRenderAdd(t *testing.T) {
+	table := &Table{ipVersion: generictables.IPFamily6, chainPrefix: "ip6-"}
+	r := NewRenderer(tableFamily, TableName, generictables.HashPrefix, table.chainName)
+	rule := generictables.Rule{
+		Match:   NewMatch(generictables.IPFamily6).Protocol("tcp").SourceIPSet("BAMBOO-gnsv6-0-x").DestPorts([]string{"22", "8000:8080"}),
+		Action:  NewAction().Jump("BAMBOO-PI-0-x"),
+		Comment: []string{"ssh"},
+	}
+	assert.Equal(t,
+		`add rule inet bamboo ip6-BAMBOO-INPUT meta l4proto tcp ip6 saddr @BAMBOO-gnsv6-0-x th dport { 22, 8000-8080 } jump ip6-BAMBOO-PI-0-x comment "bamboo:abc; ssh"`,
+		r.RenderAdd(&rule, "ip6-BAMBOO-INPUT", "abc"))
+}
+
+func newFakeTable(t *testing.T, dataplane *fake.Dataplane) *Table {
+	table, err := NewTable(TableName, generictables.HashPrefix,
+		WithIPFamily(generictables.IPFamily4), WithExecutor(dataplane))
+	require.NoError(t, err)
+	table.SetDefaultRuleOfDefaultChain(generictables.DefaultChainInput, generictables.Rule{
+		Match:  NewMatch(generictables.IPFamily4),
+		Action: NewAction().Jump(generictables.OurDefaultInputChain),
+	})
+	return table
+}
+
+func policyChains(policyChain string, ports ...string) []*generictables.Chain {
+	policy := &generictables.Chain{Name: policyChain}
+	for _, port := range ports {
+		policy.Rules = append(policy.Rules, generictables.Rule{
+			Match:  NewMatch(generictables.IPFamily4).Protocol("tcp").DestPorts([]string{port}),
+			Action: NewAction().Allow(),
+		})
+	}
+	return []*generictables.Chain{
+		{
+			Name: generictables.OurDefaultInputChain,
+			Rules: []generictables.Rule{
+				{Match: NewMatch(generictables.IPFamily4), Action: NewAction().Jump(policyChain)},
+				{Match: NewMatch(generictables.IPFamily4), Action: NewAction().Drop()},
+			},
+		},
+		policy,
+	}
+}
+
+// withoutComments strips the hash comment of rules to compare them with the rendered rules
+func withoutComments(rules []string) []string {
+	stripped := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		rule, _, _ = strings.Cut(rule, " comment \"")
+		stripped = append(stripped, rule)
+	}
+	return stripped
+}
+
+func TestTableApply(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	table := newFakeTable(t, dataplane)
+
+	// initial apply creates the table and chains
+	table.UpdateChains(policyChains("BAMBOO-PI-web", "80", "443"))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"meta nfproto ipv4 jump ip-BAMBOO-INPUT"},
+		withoutComments(dataplane.NFTRules(tableFamily, TableName, "ip-INPUT")))
+	assert.Equal(t, []string{"jump ip-BAMBOO-PI-web", "drop"},
+		withoutComments(dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-INPUT")))
+	assert.Equal(t, []string{"meta l4proto tcp th dport { 80 } accept", "meta l4proto tcp th dport { 443 } accept"},
+		withoutComments(dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-PI-web")))
+
+	// applying the same chains is a no-op
+	restores := dataplane.Restores()
+	require.NoError(t, table.Apply())
+	assert.Equal(t, restores, dataplane.Restores())
+
+	// changed chains are rewritten, unreferenced chains are deleted
+	table.UpdateChains(policyChains("BAMBOO-PI-api", "9090"))
+	require.NoError(t, table.Apply())
+	assert.Equal(t, []string{"jump ip-BAMBOO-PI-api", "drop"},
+		withoutComments(dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-INPUT")))
+	assert.Nil(t, dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-PI-web"))
+}
+
+func TestTableApplyAbortsWhenLoadFails(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	table := newFakeTable(t, dataplane)
+	table.UpdateChains(policyChains("BAMBOO-PI-web", "80"))
+	require.NoError(t, table.Apply())
+
+	// without the view of dataplane the chains would be added again, the apply must not run
+	restores := dataplane.Restores()
+	table.UpdateChains(policyChains("BAMBOO-PI-api", "9090"))
+	dataplane.FailSaves(4)
+	assert.Error(t, table.Apply())
+	assert.Equal(t, restores, dataplane.Restores())
+	assert.Len(t, dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-PI-web"), 1)
+
+	// the next apply loads dataplane again
+	require.NoError(t, table.Apply())
+	assert.Nil(t, dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-PI-web"))
+	assert.Len(t, dataplane.NFTRules(tableFamily, TableName, "ip-BAMBOO-PI-api"), 1)
+}
+
+func TestIPSetApplyAbortsWhenLoadFails(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	set, err := NewIPSet(TableName, generictables.IPFamily4, WithSetExecutor(dataplane))
+	require.NoError(t, err)
+	set.UpdateIPSet(map[string]map[string]struct{}{"BAMBOO-gnsv4-0-web": {"10.0.0.1/32": {}}})
+
+	dataplane.FailSaves(1)
+	assert.Error(t, set.Apply())
+	assert.Zero(t, dataplane.Restores())
+}
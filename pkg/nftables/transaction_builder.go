@@ -0,0 +1,67 @@
+package nftables
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// TransactionBuilder build nft -f data. All commands are applied atomically by nft
+// example:
+/*
+add table inet bamboo
+add chain inet bamboo ip-TEST_CHAIN
+flush chain inet bamboo ip-TEST_CHAIN
+add rule inet bamboo ip-TEST_CHAIN accept comment "test chain"
+*/
+type TransactionBuilder struct {
+	buf       bytes.Buffer
+	family    string
+	tableName string
+	isWriting bool
+}
+
+func (b *TransactionBuilder) IsEmpty() bool {
+	return b.buf.Len() == 0
+}
+
+func (b *TransactionBuilder) Reset() {
+	b.buf.Reset()
+	b.family = ""
+	b.tableName = ""
+	b.isWriting = false
+}
+
+func (b *TransactionBuilder) StartTransaction(family, tableName string) {
+	b.family = family
+	b.tableName = tableName
+	b.isWriting = false
+}
+
+// startTransaction make sure our table exists before any other command. "add table" does not fail
+// when the table already exists.
+func (b *TransactionBuilder) startTransaction() {
+	if !b.isWriting {
+		b.writeFormattedLine(fmt.Sprintf("add table %s %s", b.family, b.tableName))
+		b.isWriting = true
+	}
+}
+
+func (b *TransactionBuilder) EndTransaction() {
+	b.family = ""
+	b.tableName = ""
+	b.isWriting = false
+}
+
+func (b *TransactionBuilder) WriteLine(line string) {
+	b.startTransaction()
+	b.writeFormattedLine(line)
+}
+
+func (b *TransactionBuilder) Bytes() []byte {
+	return b.buf.Next(b.buf.Len())
+}
+
+func (b *TransactionBuilder) writeFormattedLine(formatted string) {
+	b.buf.WriteString(formatted)
+	b.buf.WriteByte('\n')
+}
@@ -0,0 +1,97 @@
+package integration
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/internal/daemon"
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+	"github.com/bamboo-firewall/agent/pkg/nftables"
+)
+
+// applyOurRules applies a policy chain jumped from INPUT and a set of ipVersion, like a running agent
+func applyOurRules(t *testing.T, dataplane *fake.Dataplane, ipVersion int, setName string) {
+	set, err := ipset.NewIPSet(ipVersion, ipset.WithExecutor(dataplane))
+	require.NoError(t, err)
+	member := "10.0.0.0/8"
+	if ipVersion == generictables.IPFamily6 {
+		member = "2001:db8::/32"
+	}
+	set.UpdateIPSet(map[string]map[string]struct{}{setName: {member: {}}})
+	require.NoError(t, set.Apply())
+
+	table, err := iptables.NewTable(generictables.TableFilter, generictables.HashPrefix,
+		iptables.WithIPFamily(ipVersion), iptables.WithExecutor(dataplane))
+	require.NoError(t, err)
+	table.SetDefaultRuleOfDefaultChain(generictables.DefaultChainInput, generictables.Rule{
+		Match:  iptables.NewMatch(),
+		Action: iptables.NewAction().Jump(generictables.OurDefaultInputChain),
+	})
+	table.UpdateChains([]*generictables.Chain{
+		{
+			Name: generictables.OurDefaultInputChain,
+			Rules: []generictables.Rule{
+				{Match: iptables.NewMatch().SourceIPSet(setName), Action: iptables.NewAction().Allow()},
+				{Match: iptables.NewMatch(), Action: iptables.NewAction().Drop()},
+			},
+		},
+	})
+	require.NoError(t, table.Apply())
+}
+
+func TestCleanup(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	nameConvention := ipset.NewNameConvention()
+	for _, ipVersion := range []int{generictables.IPFamily4, generictables.IPFamily6} {
+		dataplane.AppendRule(ipVersion, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+		applyOurRules(t, dataplane, ipVersion, nameConvention.SetMainNameOfSet(gnsUUID, ipVersion, "gns", "office"))
+	}
+	require.Len(t, dataplane.Sets(), 2)
+
+	// neither api-server nor ipv6 support is configured, both families are cleaned anyway
+	require.NoError(t, daemon.Cleanup(config.Config{StateFile: filepath.Join(t.TempDir(), "state.json")},
+		daemon.WithExecutor(dataplane)))
+
+	for _, ipVersion := range []int{generictables.IPFamily4, generictables.IPFamily6} {
+		assert.Equal(t, []string{"INPUT", "FORWARD", "OUTPUT"}, dataplane.Chains(ipVersion, generictables.TableFilter))
+		assert.Equal(t, []string{foreignInputRule},
+			dataplane.Rules(ipVersion, generictables.TableFilter, generictables.DefaultChainInput))
+	}
+	assert.Empty(t, dataplane.Sets())
+}
+
+func TestCleanupNFTables(t *testing.T) {
+	dataplane := fake.NewDataplane()
+	for _, ipVersion := range []int{generictables.IPFamily4, generictables.IPFamily6} {
+		table, err := nftables.NewTable(nftables.TableName, generictables.HashPrefix,
+			nftables.WithIPFamily(ipVersion), nftables.WithExecutor(dataplane))
+		require.NoError(t, err)
+		table.SetDefaultRuleOfDefaultChain(generictables.DefaultChainInput, generictables.Rule{
+			Match:  nftables.NewMatch(ipVersion),
+			Action: nftables.NewAction().Jump(generictables.OurDefaultInputChain),
+		})
+		table.UpdateChains([]*generictables.Chain{
+			{
+				Name:  generictables.OurDefaultInputChain,
+				Rules: []generictables.Rule{{Match: nftables.NewMatch(ipVersion), Action: nftables.NewAction().Drop()}},
+			},
+		})
+		require.NoError(t, table.Apply())
+	}
+	require.Equal(t, []string{"inet " + nftables.TableName}, dataplane.NFTTables())
+
+	conf := config.Config{DataplaneBackend: config.DataplaneBackendNFTables, StateFile: filepath.Join(t.TempDir(), "state.json")}
+	require.NoError(t, daemon.Cleanup(conf, daemon.WithExecutor(dataplane)))
+	// no empty table is left behind
+	assert.Empty(t, dataplane.NFTTables())
+
+	// cleaning a clean host succeeds
+	require.NoError(t, daemon.Cleanup(conf, daemon.WithExecutor(dataplane)))
+}
@@ -0,0 +1,234 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bamboo-firewall/agent/config"
+	"github.com/bamboo-firewall/agent/internal/daemon"
+	"github.com/bamboo-firewall/agent/pkg/apiserver/dto"
+	"github.com/bamboo-firewall/agent/pkg/executor/fake"
+	"github.com/bamboo-firewall/agent/pkg/generictables"
+	"github.com/bamboo-firewall/agent/pkg/ipset"
+	"github.com/bamboo-firewall/agent/pkg/iptables"
+)
+
+const (
+	tenantID = 1
+	hostIP   = "10.0.0.10"
+
+	hepUUID = "7d4d4a0e-0b6e-4a55-9a0c-2f5d3c0e1a01"
+	gnpUUID = "0f3c9a53-5d0c-4b1f-8f57-6a1c2b7d4e02"
+	gnsUUID = "c2a1e4b7-8d6f-4e3a-9b5c-1d7e0f2a3b03"
+
+	foreignInputRule = "-p tcp -m tcp --dport 2222 -j ACCEPT"
+
+	eventuallyTimeout = 5 * time.Second
+	eventuallyTick    = 10 * time.Millisecond
+)
+
+// fakeAPIServer serves fetchPolicies with the policies of the current step
+type fakeAPIServer struct {
+	mu         sync.Mutex
+	policies   []*dto.HostEndpointPolicy
+	statusCode int
+	fetches    int
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/v1/ping":
+		w.WriteHeader(http.StatusOK)
+	case "/api/internal/v1/hostEndpoints/fetchPolicies":
+		s.fetches++
+		if r.URL.Query().Get("ip") != hostIP {
+			http.Error(w, `{"code": 404, "message": "host endpoint not found"}`, http.StatusNotFound)
+			return
+		}
+		if s.statusCode != http.StatusOK {
+			http.Error(w, `{"code": 500, "message": "internal error"}`, s.statusCode)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.policies)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeAPIServer) setPolicies(policies []*dto.HostEndpointPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+	s.statusCode = http.StatusOK
+}
+
+func (s *fakeAPIServer) setStatusCode(statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusCode = statusCode
+}
+
+func (s *fakeAPIServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+type fixture struct {
+	gnpVersion uint
+	dstPort    string
+	gnsVersion uint
+	gnsNets    []string
+}
+
+// policies returns the response of api-server for a host endpoint selected by a policy allowing
+// the nets of a global network set
+func (f fixture) policies() []*dto.HostEndpointPolicy {
+	return []*dto.HostEndpointPolicy{
+		{
+			MetaData: dto.HostEndPointPolicyMetadata{
+				HEPVersions: map[string]uint{hepUUID: 1},
+				GNPVersions: map[string]uint{gnpUUID: f.gnpVersion},
+				GNSVersions: map[string]uint{gnsUUID: f.gnsVersion},
+			},
+			HEP: &dto.HostEndpoint{
+				UUID:     hepUUID,
+				Version:  1,
+				Metadata: dto.HostEndpointMetadata{Name: "web"},
+				Spec:     dto.HostEndpointSpec{IPs: []string{hostIP}},
+			},
+			ParsedGNPs: []*dto.ParsedGNP{
+				{
+					UUID:    gnpUUID,
+					Version: f.gnpVersion,
+					Name:    "allow-office",
+					InboundRules: []*dto.ParsedRule{
+						{
+							Action:      "allow",
+							Protocol:    dto.ProtocolTCP,
+							SrcGNSUUIDs: []string{gnsUUID},
+							DstPorts:    []string{f.dstPort},
+						},
+					},
+				},
+			},
+			ParsedGNSs: []*dto.ParsedGNS{
+				{UUID: gnsUUID, Name: "office", NetsV4: f.gnsNets},
+			},
+		},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	apiServer := &fakeAPIServer{statusCode: http.StatusOK}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+
+	dataplane := fake.NewDataplane()
+	dataplane.AppendRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, foreignInputRule)
+
+	conf, err := config.New("")
+	require.NoError(t, err)
+	conf.TenantID = tenantID
+	conf.HostIP = hostIP
+	conf.APIServerAddress = server.URL
+	conf.DatastoreRefreshInterval = 20 * time.Millisecond
+	// out-of-band changes are only repaired by the drift check
+	conf.DataplaneRefreshInterval = time.Hour
+	conf.DriftCheckInterval = 20 * time.Millisecond
+	conf.DriftRepair = true
+	conf.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- daemon.Run(ctx, conf, daemon.WithExecutor(dataplane))
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-runErr)
+	}()
+
+	policyChain := iptables.GetCustomChainName(generictables.OurInputChainPrefix+"allow-office", gnpUUID)
+	gnsSet := ipset.NewNameConvention().SetMainNameOfSet(gnsUUID, generictables.IPFamily4, "gns", "office")
+	current := fixture{gnpVersion: 1, dstPort: "80", gnsVersion: 1, gnsNets: []string{"192.168.1.0/24"}}
+
+	assertPolicyApplied := func(t *testing.T, f fixture) {
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, f.gnsNets, dataplane.SetMembers(gnsSet))
+			policyRules := dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, policyChain)
+			if assert.Len(c, policyRules, 1) {
+				assert.Contains(c, policyRules[0], "--match-set "+gnsSet+" src")
+				assert.Contains(c, policyRules[0], "--destination-ports "+f.dstPort+" ")
+				assert.True(c, strings.HasSuffix(policyRules[0], "-j ACCEPT"))
+			}
+			inputRules := dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput)
+			if assert.Len(c, inputRules, 2) {
+				assert.Equal(c, foreignInputRule, inputRules[0])
+				assert.True(c, strings.HasSuffix(inputRules[1], "-j "+generictables.OurDefaultInputChain))
+			}
+			assert.True(c, slices.ContainsFunc(
+				dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.OurDefaultInputChain),
+				func(rule string) bool { return strings.HasSuffix(rule, "-j "+policyChain) }))
+		}, eventuallyTimeout, eventuallyTick)
+	}
+
+	t.Run("initial sync", func(t *testing.T) {
+		apiServer.setPolicies(current.policies())
+		assertPolicyApplied(t, current)
+	})
+
+	t.Run("policy change", func(t *testing.T) {
+		current.gnpVersion, current.dstPort = 2, "443"
+		apiServer.setPolicies(current.policies())
+		assertPolicyApplied(t, current)
+	})
+
+	t.Run("global network set membership change", func(t *testing.T) {
+		current.gnsVersion, current.gnsNets = 2, []string{"192.168.1.0/24", "192.168.2.0/24"}
+		apiServer.setPolicies(current.policies())
+		assertPolicyApplied(t, current)
+	})
+
+	t.Run("api errors keep the applied policy", func(t *testing.T) {
+		apiServer.setStatusCode(http.StatusInternalServerError)
+		fetches := apiServer.fetchCount()
+		require.Eventually(t, func() bool { return apiServer.fetchCount() >= fetches+3 }, eventuallyTimeout, eventuallyTick)
+		assertPolicyApplied(t, current)
+
+		// policies fetched after api-server recovers are applied
+		current.gnpVersion, current.dstPort = 3, "8443"
+		apiServer.setPolicies(current.policies())
+		assertPolicyApplied(t, current)
+	})
+
+	t.Run("drift is repaired", func(t *testing.T) {
+		require.NoError(t, dataplane.DeleteRule(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput, 2))
+		require.NoError(t, dataplane.DeleteRule(generictables.IPFamily4, generictables.TableFilter, policyChain, 1))
+		assertPolicyApplied(t, current)
+	})
+
+	t.Run("host endpoint deletion", func(t *testing.T) {
+		apiServer.setPolicies([]*dto.HostEndpointPolicy{})
+		assert.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, []string{"INPUT", "FORWARD", "OUTPUT"}, dataplane.Chains(generictables.IPFamily4, generictables.TableFilter))
+			assert.Equal(c, []string{foreignInputRule},
+				dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainInput))
+			assert.Empty(c, dataplane.Rules(generictables.IPFamily4, generictables.TableFilter, generictables.DefaultChainOutput))
+			assert.Empty(c, dataplane.Sets())
+		}, eventuallyTimeout, eventuallyTick)
+	})
+}